@@ -0,0 +1,57 @@
+// backend/middleware/access_token.go
+package middleware
+
+// the mobile app signs in through the normal login endpoints (two factor and all), then swaps the auth-session
+// cookie for a short lived JWT access token and a refresh token at POST /api/auth/token.
+// BearerAuth sends every bearer token without the personal access token prefix here, LoadUser takes the user from it
+
+import (
+	"backend/config"
+	"backend/oidc"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const accessTokenContextKey contextKey = "access_token"
+
+// AccessToken is who a JWT access token was issued to
+type AccessToken struct {
+	UserID int
+	// the refresh token family (one sign in on one device) the access token came from
+	FamilyID string
+}
+
+// verifyAccessToken checks the token is one we signed for the api and hasn't expired
+func verifyAccessToken(r *http.Request, keys *oidc.KeySet, raw string) (*AccessToken, bool) {
+	claims, err := keys.Verify(r.Context(), raw, oidc.TypeAccessToken)
+	if err != nil {
+		if !errors.Is(err, oidc.ErrInvalidToken) {
+			log.Printf("❌ Failed to verify access token: %v", err)
+		}
+		return nil, false
+	}
+
+	// partner apps get access tokens from the same keys, theirs are only good for userinfo
+	if claims["aud"] != oidc.AudienceAPI || claims["iss"] != strings.TrimSuffix(config.GetBackendURL(), "/") {
+		return nil, false
+	}
+
+	sub, _ := claims["sub"].(string)
+	userID, err := strconv.Atoi(sub)
+	if err != nil {
+		return nil, false
+	}
+	familyID, _ := claims["sid"].(string)
+
+	return &AccessToken{UserID: userID, FamilyID: familyID}, true
+}
+
+// AccessTokenFromContext returns the JWT a request was authenticated with, ok is false for cookies and API tokens
+func AccessTokenFromContext(ctx context.Context) (*AccessToken, bool) {
+	token, ok := ctx.Value(accessTokenContextKey).(*AccessToken)
+	return token, ok
+}
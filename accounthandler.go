@@ -0,0 +1,263 @@
+// backend/handlers/account_handlers.go
+package handlers
+
+import (
+	"archive/zip"
+	"backend/config"
+	"backend/database"
+	"backend/mailer"
+	"backend/models"
+	"backend/utils"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ============================================
+// PERSONAL DATA EXPORT AND ACCOUNT DELETION
+// ============================================
+
+// the export can take a while when there are a lot of uploads, longer than the server's write timeout
+const exportWriteTimeout = 5 * time.Minute
+
+// ExportDataHandler sends everything we store about the user as a zip of json files plus their uploads
+// POST /api/users/me/export
+func (h *AuthHandler) ExportDataHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	if !h.throttle(w, r, fmt.Sprintf("export:user:%d", userID), dataExportRate) {
+		return
+	}
+
+	// Step 1: Load everything before writing anything, once the zip starts we can't send a JSON error anymore
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "User not found")
+		return
+	}
+	identities, err := h.db.ListUserIdentities(r.Context(), userID)
+	if err != nil {
+		log.Printf("❌ Export failed loading identities: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to export data")
+		return
+	}
+	sessions, err := h.db.ListUserSessions(r.Context(), userID)
+	if err != nil {
+		log.Printf("❌ Export failed loading sessions: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to export data")
+		return
+	}
+	passkeys, err := h.db.GetWebAuthnCredentialsByUser(r.Context(), userID)
+	if err != nil {
+		log.Printf("❌ Export failed loading passkeys: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to export data")
+		return
+	}
+	auditLogs, err := h.db.GetAuditLogsByUser(r.Context(), userID, 100000)
+	if err != nil {
+		log.Printf("❌ Export failed loading audit log: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to export data")
+		return
+	}
+	media, err := h.db.ListMediaByUser(r.Context(), userID)
+	if err != nil {
+		log.Printf("❌ Export failed loading media: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to export data")
+		return
+	}
+	devices, err := h.db.ListUserDevices(r.Context(), userID)
+	if err != nil {
+		log.Printf("❌ Export failed loading devices: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to export data")
+		return
+	}
+	apiTokens, err := h.db.ListAPITokens(r.Context(), userID)
+	if err != nil {
+		log.Printf("❌ Export failed loading API tokens: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to export data")
+		return
+	}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", user},
+		{"identities.json", identities},
+		{"sessions.json", sessions},
+		{"passkeys.json", passkeys},
+		{"audit_log.json", auditLogs},
+		{"media.json", media},
+		{"devices.json", devices},
+		{"api_tokens.json", apiTokens},
+		// course progress and payments aren't stored by the backend yet, the files are there so the layout doesn't change when they are
+		{"progress.json", []interface{}{}},
+		{"payments.json", []interface{}{}},
+	}
+
+	// Step 2: Stream the zip
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportWriteTimeout)); err != nil {
+		log.Printf("⚠️  Could not extend write deadline for export: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="virgoai-export-%s.zip"`, time.Now().Format("2006-01-02")))
+	w.WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(w)
+	for _, file := range files {
+		if err := writeZipJSON(archive, file.name, file.data); err != nil {
+			log.Printf("❌ Export failed writing %s: %v", file.name, err)
+			return
+		}
+	}
+	for i := range media {
+		if err := h.writeZipMedia(r, archive, &media[i]); err != nil {
+			log.Printf("⚠️  Export skipped media %d: %v", media[i].ID, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("❌ Export failed: %v", err)
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		"data_exported",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		"",
+	)
+
+	log.Printf("✅ Data export sent for user ID %d", userID)
+}
+
+// RequestAccountDeletionHandler schedules the account to be deleted once the grace period is over
+// POST /api/users/me/deletion
+func (h *AuthHandler) RequestAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	var req models.DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	// a session left open on a shared computer shouldn't be enough to delete the account
+	if user.PasswordHash != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+			utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Password is incorrect")
+			return
+		}
+	}
+
+	deleteAt, err := h.db.ScheduleUserDeletion(r.Context(), userID, time.Now().Add(config.AccountDeletionGrace))
+	if err != nil {
+		log.Printf("❌ Failed to schedule deletion: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to schedule deletion")
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		"account_deletion_requested",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		"",
+	)
+
+	// the email is the safety net if someone else asked for this
+	h.sendEmail(r, user, mailer.TemplateAccountDeletion, config.GetFrontendURL()+"/Profile")
+
+	log.Printf("🗑️  Account deletion scheduled for user ID %d at %s", userID, deleteAt.Format(time.RFC3339))
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"message":              "Your account will be deleted. You can cancel until then.",
+		"deletionScheduledFor": deleteAt,
+	})
+}
+
+// CancelAccountDeletionHandler keeps the account
+// DELETE /api/users/me/deletion
+func (h *AuthHandler) CancelAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	if err := h.db.CancelUserDeletion(r.Context(), userID); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "No deletion is scheduled")
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		"account_deletion_cancelled",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		"",
+	)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"message": "Account deletion cancelled",
+	})
+}
+
+// writeZipJSON adds one indented json file to the archive
+func writeZipJSON(archive *zip.Writer, name string, data interface{}) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
+
+// writeZipMedia copies one upload from the blob store into the archive as media/<id>-<original name>
+func (h *AuthHandler) writeZipMedia(r *http.Request, archive *zip.Writer, media *database.Media) error {
+	object, err := h.store.Get(r.Context(), media.ObjectKey)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	// the original name came from the browser, keep it from escaping the media folder when the zip is extracted
+	name := path.Base(strings.ReplaceAll(media.OriginalName, `\`, "/"))
+	if name == "." || name == "/" || name == ".." {
+		name = media.ObjectKey
+	}
+	file, err := archive.Create(fmt.Sprintf("media/%d-%s", media.ID, name))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, object)
+	return err
+}
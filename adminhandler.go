@@ -0,0 +1,486 @@
+// backend/handlers/admin_handlers.go
+package handlers
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/mailer"
+	"backend/middleware"
+	"backend/models"
+	"backend/utils"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ============================================
+// ADMIN
+// ============================================
+
+// ChangeUserRoleHandler changes another user's role
+// admins can only hand out roles up to their own and can't touch users ranked above them
+// PUT /api/admin/users/{id}/role
+func (h *AuthHandler) ChangeUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	actor, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	targetID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	var req models.ChangeRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// nobody demotes themselves by accident and leaves the school without an admin
+	if targetID == actor.ID {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "You can't change your own role")
+		return
+	}
+
+	target, err := h.db.GetUserByID(r.Context(), targetID)
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if !models.CanAssignRole(actor.Role, target.Role, req.Role) {
+		utils.ErrorResponseJSON(w, http.StatusForbidden, "You can't assign that role")
+		return
+	}
+
+	if target.Role == req.Role {
+		utils.ResponseJSON(w, http.StatusOK, map[string]string{
+			"message": "Role unchanged",
+			"role":    target.Role,
+		})
+		return
+	}
+
+	if err := h.db.UpdateUserRole(r.Context(), targetID, req.Role); err != nil {
+		log.Printf("❌ Failed to change role: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to change role")
+		return
+	}
+
+	h.db.CreateAdminAuditLog(
+		r.Context(),
+		actor.ID,
+		targetID,
+		"role_changed",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		target.Role+" -> "+req.Role,
+	)
+
+	log.Printf("✅ User ID %d changed role of user ID %d from %s to %s", actor.ID, targetID, target.Role, req.Role)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"message": "Role updated",
+		"role":    req.Role,
+	})
+}
+
+// AdminUserListResponse is one page of users for the admin table
+type AdminUserListResponse struct {
+	Users []database.User `json:"users"`
+	Total int             `json:"total"`
+	Page  int             `json:"page"`
+	Limit int             `json:"limit"`
+}
+
+const (
+	defaultAdminPageSize = 25
+	maxAdminPageSize     = 100
+)
+
+// ListUsersHandler lists users with search, filters, sorting and pagination
+// GET /api/admin/users?q=&role=&provider=&verified=&suspended=&sort=&order=&page=&limit=
+func (h *AuthHandler) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := database.UserFilter{
+		Search:   strings.TrimSpace(query.Get("q")),
+		Role:     query.Get("role"),
+		Provider: query.Get("provider"),
+		Sort:     query.Get("sort"),
+		Desc:     query.Get("order") == "desc",
+	}
+	if filter.Role != "" && !models.ValidRole(filter.Role) {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid role")
+		return
+	}
+
+	var ok bool
+	if filter.Verified, ok = optionalBool(query.Get("verified")); !ok {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "verified must be true or false")
+		return
+	}
+	if filter.Suspended, ok = optionalBool(query.Get("suspended")); !ok {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "suspended must be true or false")
+		return
+	}
+
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit < 1 {
+		limit = defaultAdminPageSize
+	}
+	if limit > maxAdminPageSize {
+		limit = maxAdminPageSize
+	}
+
+	users, err := h.db.ListUsers(r.Context(), filter, limit, (page-1)*limit)
+	if err != nil {
+		log.Printf("❌ Failed to list users: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to load users")
+		return
+	}
+	total, err := h.db.CountUsers(r.Context(), filter)
+	if err != nil {
+		log.Printf("❌ Failed to count users: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to load users")
+		return
+	}
+
+	if users == nil {
+		users = []database.User{}
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, AdminUserListResponse{
+		Users: users,
+		Total: total,
+		Page:  page,
+		Limit: limit,
+	})
+}
+
+// GetUserHandler returns one user
+// GET /api/admin/users/{id}
+func (h *AuthHandler) GetUserHandler(w http.ResponseWriter, r *http.Request) {
+	targetID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	user, err := h.db.GetUserByID(r.Context(), targetID)
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, user)
+}
+
+// SuspendUserHandler blocks every way of logging in and logs the user out everywhere
+// POST /api/admin/users/{id}/suspend
+func (h *AuthHandler) SuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	actor, target, ok := h.manageableUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.db.SetUserSuspended(r.Context(), target.ID, true, database.SuspendedByAdmin); err != nil {
+		log.Printf("❌ Failed to suspend user: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to suspend user")
+		return
+	}
+	if _, err := h.db.DeleteUserSessions(r.Context(), target.ID, ""); err != nil {
+		log.Printf("⚠️  Failed to revoke sessions of suspended user: %v", err)
+	}
+	if _, err := h.db.RevokeUserRefreshTokens(r.Context(), target.ID, ""); err != nil {
+		log.Printf("⚠️  Failed to revoke refresh tokens of suspended user: %v", err)
+	}
+
+	h.db.CreateAdminAuditLog(
+		r.Context(),
+		actor.ID,
+		target.ID,
+		"user_suspended",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		"",
+	)
+
+	log.Printf("⛔ User ID %d suspended user ID %d", actor.ID, target.ID)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"message": "User suspended",
+	})
+}
+
+// UnsuspendUserHandler lets a suspended user log in again
+// POST /api/admin/users/{id}/unsuspend
+func (h *AuthHandler) UnsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	actor, target, ok := h.manageableUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.db.SetUserSuspended(r.Context(), target.ID, false, database.SuspendedByAdmin); err != nil {
+		log.Printf("❌ Failed to unsuspend user: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to unsuspend user")
+		return
+	}
+
+	h.db.CreateAdminAuditLog(
+		r.Context(),
+		actor.ID,
+		target.ID,
+		"user_unsuspended",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		"",
+	)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"message": "User unsuspended",
+	})
+}
+
+// ForcePasswordResetHandler makes the user choose a new password before they can log in with one again
+// their sessions are revoked and they get the normal reset email
+// POST /api/admin/users/{id}/force-password-reset
+func (h *AuthHandler) ForcePasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	actor, target, ok := h.manageableUser(w, r)
+	if !ok {
+		return
+	}
+
+	if target.PasswordHash == "" {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "This user doesn't have a password")
+		return
+	}
+
+	if err := h.db.SetPasswordResetRequired(r.Context(), target.ID, true); err != nil {
+		log.Printf("❌ Failed to force password reset: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to force password reset")
+		return
+	}
+	if _, err := h.db.DeleteUserSessions(r.Context(), target.ID, ""); err != nil {
+		log.Printf("⚠️  Failed to revoke sessions: %v", err)
+	}
+	if _, err := h.db.RevokeUserRefreshTokens(r.Context(), target.ID, ""); err != nil {
+		log.Printf("⚠️  Failed to revoke refresh tokens: %v", err)
+	}
+
+	token := utils.GenerateSecureToken(32)
+	expiresAt := time.Now().Add(15 * time.Minute)
+	if err := h.db.CreatePasswordResetToken(r.Context(), target.ID, token, expiresAt); err != nil {
+		log.Printf("❌ Failed to create reset token: %v", err)
+	} else {
+		h.sendEmail(r, target, mailer.TemplatePasswordReset, config.GetFrontendURL()+"/reset-password?token="+url.QueryEscape(token))
+	}
+
+	h.db.CreateAdminAuditLog(
+		r.Context(),
+		actor.ID,
+		target.ID,
+		"password_reset_forced",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		"",
+	)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"message": "Password reset required, the user has been emailed a reset link",
+	})
+}
+
+// UnlockUserHandler clears a lockout from too many wrong passwords
+// POST /api/admin/users/{id}/unlock
+func (h *AuthHandler) UnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	actor, target, ok := h.manageableUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.db.UnlockUser(r.Context(), target.ID); err != nil {
+		log.Printf("❌ Failed to unlock user: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to unlock user")
+		return
+	}
+
+	h.db.CreateAdminAuditLog(
+		r.Context(),
+		actor.ID,
+		target.ID,
+		"account_unlocked",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		"",
+	)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"message": "User unlocked",
+	})
+}
+
+// DeleteUserHandler deletes a user and everything that belongs to them
+// DELETE /api/admin/users/{id}
+func (h *AuthHandler) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	actor, target, ok := h.manageableUser(w, r)
+	if !ok {
+		return
+	}
+
+	// the media rows go with the user, their files have to be deleted from the blob store by hand
+	media, err := h.db.ListMediaByUser(r.Context(), target.ID)
+	if err != nil {
+		log.Printf("⚠️  Failed to list media of deleted user: %v", err)
+	}
+
+	if err := h.db.DeleteUser(r.Context(), target.ID); err != nil {
+		log.Printf("❌ Failed to delete user: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to delete user")
+		return
+	}
+	for i := range media {
+		h.deleteMediaObjects(r.Context(), &media[i])
+	}
+
+	// no email or name in details, the row outlives the user and can't be edited later without breaking the audit chain
+	h.db.CreateAdminAuditLog(
+		r.Context(),
+		actor.ID,
+		target.ID,
+		"user_deleted",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		"",
+	)
+
+	log.Printf("🗑️  User ID %d deleted user ID %d", actor.ID, target.ID)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"message": "User deleted",
+	})
+}
+
+// ListUserSessionsHandler lists the devices a user is signed in on, for support and compromised accounts
+// GET /api/admin/users/{id}/sessions
+func (h *AuthHandler) ListUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	_, target, ok := h.manageableUser(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.db.ListUserSessions(r.Context(), target.ID)
+	if err != nil {
+		log.Printf("❌ Failed to list sessions: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to load sessions")
+		return
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"sessions": sessionResponses(sessions, ""),
+	})
+}
+
+// RevokeUserSessionsHandler signs a user out on every device, web sessions and mobile app tokens both
+// DELETE /api/admin/users/{id}/sessions
+func (h *AuthHandler) RevokeUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	actor, target, ok := h.manageableUser(w, r)
+	if !ok {
+		return
+	}
+
+	revoked, err := h.db.DeleteUserSessions(r.Context(), target.ID, "")
+	if err != nil {
+		log.Printf("❌ Failed to revoke sessions: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to sign out the user")
+		return
+	}
+	signedOut, err := h.db.RevokeUserRefreshTokens(r.Context(), target.ID, "")
+	if err != nil {
+		log.Printf("❌ Failed to revoke refresh tokens: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to sign out the user")
+		return
+	}
+	revoked += signedOut
+
+	h.db.CreateAdminAuditLog(
+		r.Context(),
+		actor.ID,
+		target.ID,
+		"sessions_revoked",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		strconv.FormatInt(revoked, 10)+" signed out",
+	)
+
+	log.Printf("✅ User ID %d signed out user ID %d everywhere", actor.ID, target.ID)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "User signed out of all devices",
+		"revoked": revoked,
+	})
+}
+
+// manageableUser loads the {id} user for an admin action and checks the actor is allowed to touch them,
+// it writes the error response itself when they aren't
+func (h *AuthHandler) manageableUser(w http.ResponseWriter, r *http.Request) (*database.User, *database.User, bool) {
+	actor, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return nil, nil, false
+	}
+
+	targetID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid user id")
+		return nil, nil, false
+	}
+
+	// suspending or deleting yourself goes through the normal account settings, not the admin panel
+	if targetID == actor.ID {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "You can't do that to your own account")
+		return nil, nil, false
+	}
+
+	target, err := h.db.GetUserByID(r.Context(), targetID)
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "User not found")
+		return nil, nil, false
+	}
+
+	if !models.CanManageUser(actor.Role, target.Role) {
+		utils.ErrorResponseJSON(w, http.StatusForbidden, "You can't manage this user")
+		return nil, nil, false
+	}
+
+	return actor, target, true
+}
+
+// optionalBool parses a true/false query filter, an empty value means "dont filter"
+func optionalBool(value string) (*bool, bool) {
+	if value == "" {
+		return nil, true
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, false
+	}
+	return &parsed, true
+}
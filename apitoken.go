@@ -0,0 +1,114 @@
+// backend/middleware/api_token.go
+package middleware
+
+// BearerAuth lets scripts use a personal access token and the mobile app a JWT access token instead of the auth-session cookie:
+//
+//	protected.Use(middleware.BearerAuth(db, keys, middleware.AuthMiddleware))
+//
+// bearer tokens with the vgo_pat_ prefix are checked against api_tokens, other bearer tokens must be our signed JWTs
+// (access_token.go) and everything else goes through the session check.
+// a token acts as its user but RequirePermission also wants the permission in the token's scopes.
+// every other route except /auth/me (the account, profile, uploads...) is wrapped in SessionOnly,
+// a route that takes a token without checking a scope would let any token use it
+
+import (
+	"backend/database"
+	"backend/models"
+	"backend/oidc"
+	"backend/utils"
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// APITokenPrefix starts every personal access token, it makes leaked tokens easy to spot (and to scan for)
+const APITokenPrefix = "vgo_pat_"
+
+const apiTokenContextKey contextKey = "api_token"
+
+// BearerAuth checks Authorization: Bearer tokens and hands every other request to sessionAuth
+func BearerAuth(db *database.Postgres, keys *oidc.KeySet, sessionAuth mux.MiddlewareFunc) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		viaSession := sessionAuth(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				viaSession.ServeHTTP(w, r)
+				return
+			}
+
+			scheme, raw, _ := strings.Cut(header, " ")
+			raw = strings.TrimSpace(raw)
+			if !strings.EqualFold(scheme, "Bearer") {
+				utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Invalid API token")
+				return
+			}
+
+			// anything without our prefix has to be a JWT from the mobile app
+			if !strings.HasPrefix(raw, APITokenPrefix) {
+				token, ok := verifyAccessToken(r, keys, raw)
+				if !ok {
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Invalid or expired access token")
+					return
+				}
+				ctx := context.WithValue(r.Context(), accessTokenContextKey, token)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			// only the hash is stored, so the lookup is by hash too
+			token, err := db.GetAPITokenByHash(r.Context(), utils.HashToken(raw))
+			if err != nil {
+				log.Printf("⚠️  Rejected API token from %s: %v", utils.GetIPAddress(r), err)
+				utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Invalid API token")
+				return
+			}
+
+			now := time.Now().UTC().Truncate(time.Microsecond)
+			if !now.Before(token.ExpiresAt) {
+				utils.ErrorResponseJSON(w, http.StatusUnauthorized, "This API token has expired")
+				return
+			}
+
+			if err := db.TouchAPIToken(r.Context(), token.ID, now); err != nil {
+				log.Printf("⚠️  Failed to update API token last use: %v", err)
+			}
+
+			ctx := context.WithValue(r.Context(), apiTokenContextKey, token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// APITokenFromContext returns the token a request was authenticated with, ok is false for session requests
+func APITokenFromContext(ctx context.Context) (*database.APIToken, bool) {
+	token, ok := ctx.Value(apiTokenContextKey).(*database.APIToken)
+	return token, ok
+}
+
+// SessionOnly refuses requests made with an API token, a leaked token must not be able to take over the account
+func SessionOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := APITokenFromContext(r.Context()); ok {
+			log.Printf("⛔ API token %d refused on session only route %s", token.ID, r.URL.Path)
+			utils.ErrorResponseJSON(w, http.StatusForbidden, "This can't be done with an API token, sign in instead")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tokenAllows reports whether perm is one of the token's scopes
+func tokenAllows(token *database.APIToken, perm models.Permission) bool {
+	for _, scope := range token.Scopes {
+		if models.Permission(scope) == perm {
+			return true
+		}
+	}
+	return false
+}
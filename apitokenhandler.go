@@ -0,0 +1,174 @@
+// backend/handlers/api_token_handlers.go
+package handlers
+
+import (
+	"backend/database"
+	"backend/middleware"
+	"backend/models"
+	"backend/utils"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ============================================
+// PERSONAL ACCESS TOKENS
+// ============================================
+
+// a script per class and a few spares, more than this is almost certainly tokens nobody cleans up
+const maxAPITokensPerUser = 20
+
+// CreateAPITokenResponse is the only time the token itself is ever shown
+type CreateAPITokenResponse struct {
+	database.APIToken
+	Token string `json:"token"`
+}
+
+// ListAPITokensHandler lists the user's personal access tokens, without the tokens themselves
+// GET /api/auth/tokens
+func (h *AuthHandler) ListAPITokensHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	tokens, err := h.db.ListAPITokens(r.Context(), userID)
+	if err != nil {
+		log.Printf("❌ Failed to list API tokens: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to load API tokens")
+		return
+	}
+	if tokens == nil {
+		tokens = []database.APIToken{}
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, tokens)
+}
+
+// CreateAPITokenHandler creates a named, scoped, expiring token for scripts
+// POST /api/auth/tokens
+func (h *AuthHandler) CreateAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	// Step 1: Parse and validate
+	var req models.CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Step 2: A token can only carry permissions the user has right now, RequirePermission checks the role again on every request
+	for _, scope := range req.Scopes {
+		if !models.HasPermission(user.Role, models.Permission(scope)) {
+			utils.ErrorResponseJSON(w, http.StatusForbidden, "Your role doesn't allow the "+scope+" scope")
+			return
+		}
+	}
+
+	count, err := h.db.CountActiveAPITokens(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("❌ Failed to count API tokens: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to create API token")
+		return
+	}
+	if count >= maxAPITokensPerUser {
+		utils.ErrorResponseJSON(w, http.StatusConflict, fmt.Sprintf("You can have at most %d API tokens, revoke one first", maxAPITokensPerUser))
+		return
+	}
+
+	// Step 3: Create the token, the database only ever sees its hash
+	raw := middleware.APITokenPrefix + utils.GenerateSecureToken(32)
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	token := &database.APIToken{
+		UserID:      user.ID,
+		Name:        req.Name,
+		TokenHash:   utils.HashToken(raw),
+		TokenPrefix: raw[:len(middleware.APITokenPrefix)+4],
+		Scopes:      dedupeScopes(req.Scopes),
+		ExpiresAt:   now.AddDate(0, 0, req.ExpiresInDays),
+		CreatedAt:   now,
+	}
+	if err := h.db.CreateAPIToken(r.Context(), token); err != nil {
+		log.Printf("❌ Failed to create API token: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to create API token")
+		return
+	}
+
+	// Step 4: Audit
+	h.db.CreateAuditLog(
+		r.Context(),
+		&user.ID,
+		"api_token_created",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		fmt.Sprintf("token %d %q scopes %s", token.ID, token.Name, strings.Join(token.Scopes, ",")),
+	)
+
+	log.Printf("🔑 User ID %d created API token %d", user.ID, token.ID)
+
+	utils.ResponseJSON(w, http.StatusCreated, CreateAPITokenResponse{APIToken: *token, Token: raw})
+}
+
+// RevokeAPITokenHandler deletes one of the user's tokens, scripts using it get a 401 from the next request on
+// DELETE /api/auth/tokens/{id}
+func (h *AuthHandler) RevokeAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	tokenID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid token id")
+		return
+	}
+
+	if err := h.db.RevokeAPIToken(r.Context(), userID, tokenID); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "API token not found")
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		"api_token_revoked",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		fmt.Sprintf("token %d", tokenID),
+	)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"message": "API token revoked",
+	})
+}
+
+// dedupeScopes drops repeated scopes so the token list reads cleanly
+func dedupeScopes(scopes []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, scope := range scopes {
+		if !seen[scope] {
+			seen[scope] = true
+			unique = append(unique, scope)
+		}
+	}
+	return unique
+}
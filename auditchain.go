@@ -0,0 +1,233 @@
+package database
+
+// tamper evident audit log. every row written through appendAuditLog gets
+//   hash = sha256(prev_hash + the row's content)
+// where prev_hash is the hash of the chained row before it, so changing, deleting or reordering a row
+// breaks the chain from that row on. VerifyAuditChain walks the chain and reports the first broken link.
+//
+// appends are serialized with a transaction level advisory lock, two requests writing at the same time
+// would otherwise both read the same previous hash and fork the chain.
+//
+// the raw user ids aren't hashed because deleting a user nulls them (ON DELETE SET NULL), which would look like tampering.
+// the hash covers user_ref instead, an hmac of the id with AUDIT_CHAIN_KEY that stays after the user is gone.
+// that key must never change, rows written with an old key fail the user_ref check.
+// user_ref is pseudonymous, not anonymous: with the key and a user id the ref can be recomputed, so a deleted user's
+// rows can still be found. keep personal data (emails, names) out of details, hashed rows can't be edited later.
+//
+// the chain can't tell if rows were cut off the end, keep the head hash VerifyAuditChain reports somewhere outside the database
+// (a ticket, the ops log) to catch that.
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// any constant works, it only has to be the same for every writer
+const auditChainLockID = 7316001
+
+// the fallback is only for local dev, the warning in SetAuditChainKey says so
+const devAuditChainKey = "virgoai-dev-audit-chain-key"
+
+// created_at is hashed as text, this layout matches what a TIMESTAMP column gives back (microseconds, no zone)
+const auditTimeLayout = "2006-01-02T15:04:05.000000"
+
+// auditEntry is one row about to be appended
+type auditEntry struct {
+	userID        *int
+	targetUserID  *int
+	action        string
+	ipAddress     string
+	userAgent     string
+	success       bool
+	failureReason string
+	details       string
+}
+
+// SetAuditChainKey sets the key for the user references in the audit chain, call it before anything writes an audit row
+func (pg *Postgres) SetAuditChainKey(key string) {
+	if key == "" {
+		log.Println("⚠️  AUDIT_CHAIN_KEY is not set, using the development key for the audit chain")
+		key = devAuditChainKey
+	}
+	pg.auditKey = []byte(key)
+}
+
+// userRef is the keyed reference the chain stores for a user id, empty for no user
+func (pg *Postgres) userRef(userID *int) string {
+	if userID == nil {
+		return ""
+	}
+	key := pg.auditKey
+	if key == nil {
+		key = []byte(devAuditChainKey)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strconv.Itoa(*userID)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// auditHash hashes a row's content together with the previous hash.
+// the fields go through json so no value can be crafted to look like two fields
+func auditHash(prevHash, userRef, targetUserRef, action, ipAddress, userAgent string, success bool, failureReason, details string, createdAt time.Time) string {
+	content, _ := json.Marshal([]interface{}{
+		prevHash,
+		userRef,
+		targetUserRef,
+		action,
+		ipAddress,
+		userAgent,
+		success,
+		failureReason,
+		details,
+		createdAt.UTC().Format(auditTimeLayout),
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// appendAuditLog writes one row at the end of the chain
+func (pg *Postgres) appendAuditLog(ctx context.Context, entry auditEntry) error {
+	// truncated to what postgres stores so the hash we compute now matches what verification reads back
+	createdAt := time.Now().UTC().Truncate(time.Microsecond)
+	userRef := pg.userRef(entry.userID)
+	targetUserRef := pg.userRef(entry.targetUserID)
+
+	return pg.WithTransaction(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLockID); err != nil {
+			return fmt.Errorf("unable to lock audit chain: %w", err)
+		}
+
+		var prevHash string
+		err := tx.QueryRow(ctx, `SELECT hash FROM audit_log WHERE hash IS NOT NULL ORDER BY id DESC LIMIT 1`).Scan(&prevHash)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("unable to read audit chain head: %w", err)
+		}
+
+		hash := auditHash(prevHash, userRef, targetUserRef, entry.action, entry.ipAddress, entry.userAgent, entry.success, entry.failureReason, entry.details, createdAt)
+
+		query := `
+			INSERT INTO audit_log (user_id, target_user_id, user_ref, target_user_ref, action, ip_address, user_agent, success, failure_reason, details, created_at, prev_hash, hash)
+			VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		`
+		_, err = tx.Exec(ctx, query,
+			entry.userID,
+			entry.targetUserID,
+			userRef,
+			targetUserRef,
+			entry.action,
+			entry.ipAddress,
+			entry.userAgent,
+			entry.success,
+			entry.failureReason,
+			entry.details,
+			createdAt,
+			prevHash,
+			hash,
+		)
+		if err != nil {
+			return fmt.Errorf("unable to create audit log: %w", err)
+		}
+		return nil
+	})
+}
+
+// AuditChainReport is the result of VerifyAuditChain
+type AuditChainReport struct {
+	Checked       int    `json:"checked"`                 // chained rows looked at
+	Unchained     int    `json:"unchained"`               // rows from before the chain existed
+	HeadID        int    `json:"headId,omitempty"`        // the last chained row
+	HeadHash      string `json:"headHash,omitempty"`      // compare with a copy kept outside the database
+	BrokenID      int    `json:"brokenId,omitempty"`      // first row that doesn't check out, 0 when the chain is intact
+	BrokenReason  string `json:"brokenReason,omitempty"`  // what was wrong with it
+	ExpectedHash  string `json:"expectedHash,omitempty"`  // what the hash should have been
+	StoredHash    string `json:"storedHash,omitempty"`    // what the row says
+	PreviousRowID int    `json:"previousRowId,omitempty"` // the chained row before the broken one
+}
+
+// Intact reports whether every chained row checked out
+func (r *AuditChainReport) Intact() bool {
+	return r.BrokenID == 0
+}
+
+// VerifyAuditChain walks the whole chain from the first row and stops at the first broken link
+func (pg *Postgres) VerifyAuditChain(ctx context.Context) (*AuditChainReport, error) {
+	report := &AuditChainReport{}
+
+	if err := pg.db.QueryRow(ctx, `SELECT COUNT(*) FROM audit_log WHERE hash IS NULL`).Scan(&report.Unchained); err != nil {
+		return nil, fmt.Errorf("unable to count unchained audit logs: %w", err)
+	}
+
+	query := `
+		SELECT id, user_id, target_user_id, COALESCE(user_ref, ''), COALESCE(target_user_ref, ''), action,
+		       COALESCE(ip_address, ''), COALESCE(user_agent, ''), COALESCE(success, false), COALESCE(failure_reason, ''),
+		       COALESCE(details, ''), created_at, COALESCE(prev_hash, ''), hash
+		FROM audit_log
+		WHERE hash IS NOT NULL
+		ORDER BY id
+	`
+	rows, err := pg.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read audit chain: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		id, prevID                                                   int
+		userID, targetUserID                                         *int
+		userRef, targetUserRef, action, ipAddress, userAgent, reason string
+		details, prevHash, hash, lastHash                            string
+		success                                                      bool
+		createdAt                                                    time.Time
+	)
+	for rows.Next() {
+		err := rows.Scan(&id, &userID, &targetUserID, &userRef, &targetUserRef, &action, &ipAddress, &userAgent, &success, &reason, &details, &createdAt, &prevHash, &hash)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan audit log: %w", err)
+		}
+		report.Checked++
+
+		broken := func(why, expected string) {
+			report.BrokenID = id
+			report.BrokenReason = why
+			report.ExpectedHash = expected
+			report.StoredHash = hash
+			report.PreviousRowID = prevID
+		}
+
+		expected := auditHash(prevHash, userRef, targetUserRef, action, ipAddress, userAgent, success, reason, details, createdAt)
+		switch {
+		case prevHash != lastHash:
+			broken("prev_hash does not match the previous row, a row was deleted or inserted before this one", lastHash)
+		case hash != expected:
+			broken("content does not match its hash, the row was edited", expected)
+		case userID != nil && pg.userRef(userID) != userRef:
+			broken("user_id does not match user_ref", "")
+		case targetUserID != nil && pg.userRef(targetUserID) != targetUserRef:
+			broken("target_user_id does not match target_user_ref", "")
+		}
+		if report.BrokenID != 0 {
+			return report, nil
+		}
+
+		lastHash = hash
+		prevID = id
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit chain: %w", err)
+	}
+
+	report.HeadID = prevID
+	report.HeadHash = lastHash
+	return report, nil
+}
@@ -0,0 +1,294 @@
+// backend/handlers/audit_handlers.go
+package handlers
+
+import (
+	"backend/database"
+	"backend/middleware"
+	"backend/utils"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================
+// AUDIT LOG AND SECURITY ACTIVITY
+// ============================================
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+	// exports stream for as long as postgres keeps sending rows, well past the server's write timeout
+	auditExportWriteTimeout = 10 * time.Minute
+)
+
+// AuditPageResponse is one page of audit logs, pass nextCursor back as ?cursor= for the next one
+type AuditPageResponse struct {
+	Entries    []database.AuditLog `json:"entries"`
+	NextCursor string              `json:"nextCursor,omitempty"`
+}
+
+// ActivityHandler shows the user their own recent security activity (logins, password changes, new devices...)
+// GET /api/auth/activity?cursor=&limit=
+func (h *AuthHandler) ActivityHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := database.AuditFilter{UserID: &userID}
+	if !parseCursor(query.Get("cursor"), &filter.BeforeID) {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+
+	h.writeAuditPage(w, r, filter, pageSize(query.Get("limit")))
+}
+
+// AdminAuditHandler searches the whole audit log, format=csv or format=ndjson downloads every match instead of one page
+// GET /api/admin/audit?user=&action=&success=&ip=&from=&to=&cursor=&limit=&format=
+func (h *AuthHandler) AdminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	actor, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	filter, err := auditFilterFromQuery(r.URL.Query())
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "json":
+		h.writeAuditPage(w, r, filter, pageSize(r.URL.Query().Get("limit")))
+		return
+	case "csv", "ndjson":
+	default:
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "format must be json, csv or ndjson")
+		return
+	}
+
+	// downloading the audit log is itself worth a line in the audit log
+	h.db.CreateAuditLog(
+		r.Context(),
+		&actor.ID,
+		"audit_exported",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		format+" "+r.URL.RawQuery,
+	)
+
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(auditExportWriteTimeout)); err != nil {
+		log.Printf("⚠️  Could not extend write deadline for audit export: %v", err)
+	}
+	filename := fmt.Sprintf("audit-log-%s.%s", time.Now().Format("2006-01-02"), format)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	// the status is sent with the first row, a failure halfway through can only be logged and the download ends short
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		err = streamAuditCSV(w, r, h.db, filter)
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		err = streamAuditNDJSON(w, r, h.db, filter)
+	}
+	if err != nil {
+		log.Printf("❌ Audit export failed: %v", err)
+	}
+}
+
+// VerifyAuditChainHandler checks the tamper evident audit chain and reports the first broken row
+// it reads the whole chain, so it is slow on a big log
+// GET /api/admin/audit/verify
+func (h *AuthHandler) VerifyAuditChainHandler(w http.ResponseWriter, r *http.Request) {
+	actor, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	report, err := h.db.VerifyAuditChain(r.Context())
+	if err != nil {
+		log.Printf("❌ Audit chain verification failed: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to verify the audit log")
+		return
+	}
+
+	if !report.Intact() {
+		log.Printf("🚨 Audit chain broken at row %d: %s", report.BrokenID, report.BrokenReason)
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&actor.ID,
+		"audit_verified",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		report.Intact(),
+		report.BrokenReason,
+	)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"intact": report.Intact(),
+		"report": report,
+	})
+}
+
+// writeAuditPage answers with one page of the filter and the cursor for the next one
+func (h *AuthHandler) writeAuditPage(w http.ResponseWriter, r *http.Request, filter database.AuditFilter, limit int) {
+	// one extra row tells us whether there is a next page without a COUNT(*) over the whole log
+	entries, err := h.db.ListAuditLogs(r.Context(), filter, limit+1)
+	if err != nil {
+		log.Printf("❌ Failed to list audit logs: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to load activity")
+		return
+	}
+
+	response := AuditPageResponse{Entries: entries}
+	if len(entries) > limit {
+		response.Entries = entries[:limit]
+		response.NextCursor = strconv.Itoa(entries[limit-1].ID)
+	}
+	if response.Entries == nil {
+		response.Entries = []database.AuditLog{}
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, response)
+}
+
+// auditFilterFromQuery reads the admin filters, times are RFC 3339 (2024-05-01T00:00:00Z)
+func auditFilterFromQuery(query url.Values) (database.AuditFilter, error) {
+	filter := database.AuditFilter{
+		Action: query.Get("action"),
+		IP:     query.Get("ip"),
+	}
+
+	if value := query.Get("user"); value != "" {
+		userID, err := strconv.Atoi(value)
+		if err != nil {
+			return filter, fmt.Errorf("user must be a user id")
+		}
+		filter.AboutUserID = &userID
+	}
+
+	var ok bool
+	if filter.Success, ok = optionalBool(query.Get("success")); !ok {
+		return filter, fmt.Errorf("success must be true or false")
+	}
+
+	for _, bound := range []struct {
+		name string
+		dest **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		value := query.Get(bound.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("%s must be an RFC 3339 time like 2024-05-01T00:00:00Z", bound.name)
+		}
+		// created_at is stored without a zone, in the server's time (UTC in production)
+		t = t.UTC()
+		*bound.dest = &t
+	}
+
+	if !parseCursor(query.Get("cursor"), &filter.BeforeID) {
+		return filter, fmt.Errorf("invalid cursor")
+	}
+
+	return filter, nil
+}
+
+// streamAuditCSV writes every row of the filter as csv, flushing as it goes
+func streamAuditCSV(w http.ResponseWriter, r *http.Request, db *database.Postgres, filter database.AuditFilter) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "created_at", "user_id", "target_user_id", "action", "success", "ip_address", "user_agent", "failure_reason", "details"})
+
+	rows := 0
+	err := db.StreamAuditLogs(r.Context(), filter, func(entry *database.AuditLog) error {
+		writer.Write([]string{
+			strconv.Itoa(entry.ID),
+			entry.CreatedAt.Format(time.RFC3339),
+			optionalInt(entry.UserID),
+			optionalInt(entry.TargetUserID),
+			csvSafe(entry.Action),
+			strconv.FormatBool(entry.Success),
+			csvSafe(entry.IPAddress),
+			csvSafe(entry.UserAgent),
+			csvSafe(entry.FailureReason),
+			csvSafe(entry.Details),
+		})
+		rows++
+		if rows%500 == 0 {
+			writer.Flush()
+			return writer.Error()
+		}
+		return nil
+	})
+
+	writer.Flush()
+	if err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// streamAuditNDJSON writes every row of the filter as one json object per line
+func streamAuditNDJSON(w http.ResponseWriter, r *http.Request, db *database.Postgres, filter database.AuditFilter) error {
+	encoder := json.NewEncoder(w)
+	return db.StreamAuditLogs(r.Context(), filter, func(entry *database.AuditLog) error {
+		return encoder.Encode(entry)
+	})
+}
+
+// csvSafe stops a spreadsheet from running a cell as a formula, user agents and details come from whoever sent the request
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func optionalInt(value *int) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.Itoa(*value)
+}
+
+// parseCursor reads a cursor from a previous page, an empty cursor means the first page
+func parseCursor(value string, dest *int) bool {
+	if value == "" {
+		return true
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 1 {
+		return false
+	}
+	*dest = id
+	return true
+}
+
+// pageSize reads ?limit=, falling back to the default and capping it
+func pageSize(value string) int {
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 {
+		return defaultAuditPageSize
+	}
+	if limit > maxAuditPageSize {
+		return maxAuditPageSize
+	}
+	return limit
+}
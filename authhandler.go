@@ -4,26 +4,60 @@ package handlers
 import (
 	"backend/config"
 	"backend/database"
+	"backend/mailer"
 	"backend/models"
+	"backend/oidc"
+	"backend/ratelimit"
+	"backend/risk"
+	"backend/sso"
+	"backend/storage"
 	"backend/utils"
 	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // AuthHandler holds dependencies for auth operations
 type AuthHandler struct {
-	db *database.Postgres // Use your postgres instance
+	db      *database.Postgres // Use your postgres instance
+	mailer  mailer.Mailer      // sends the reset, verification and welcome emails
+	limiter ratelimit.Limiter  // throttles login, register and the password reset endpoints
+	store   storage.BlobStore  // uploaded avatars, audio and pdfs
+	risk    *risk.Evaluator    // new device and suspicious login checks
+	keys    *oidc.KeySet       // signs the id tokens for "Sign in with VirgoAI"
+	saml    *sso.SAMLProvider  // SAML single sign on for organizations
 }
 
 // NewAuthHandler creates a new auth handler
-func NewAuthHandler(db *database.Postgres) *AuthHandler {
-	return &AuthHandler{db: db}
+func NewAuthHandler(db *database.Postgres, mail mailer.Mailer, limiter ratelimit.Limiter, store storage.BlobStore, riskEvaluator *risk.Evaluator, keys *oidc.KeySet, samlProvider *sso.SAMLProvider) *AuthHandler {
+	return &AuthHandler{db: db, mailer: mail, limiter: limiter, store: store, risk: riskEvaluator, keys: keys, saml: samlProvider}
+}
+
+// sendEmail renders one of the mailer templates in the user's language and sends it
+// the language they picked on their profile wins, otherwise we go by the browser
+// a failed email never fails the request, we only log it
+func (h *AuthHandler) sendEmail(r *http.Request, user *database.User, template, link string) {
+	h.sendEmailData(r, user, template, mailer.TemplateData{Link: link})
+}
+
+// sendEmailData is sendEmail for templates that need more than a link
+func (h *AuthHandler) sendEmailData(r *http.Request, user *database.User, template string, data mailer.TemplateData) {
+	locale := mailer.NormalizeLocale(r.Header.Get("Accept-Language"))
+	if user.PreferredLanguage != "" {
+		locale = mailer.NormalizeLocale(user.PreferredLanguage)
+	}
+	data.FirstName = user.FirstName
+
+	if err := mailer.SendTemplate(r.Context(), h.mailer, user.Email, template, locale, data); err != nil {
+		log.Printf("❌ Failed to send %s email to %s: %v", template, user.Email, err)
+	}
 }
 
 // ============================================
@@ -35,6 +69,11 @@ func NewAuthHandler(db *database.Postgres) *AuthHandler {
 func (h *AuthHandler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("Registration request received")
 
+	// slow down scripts creating accounts from one ip
+	if !h.throttleIP(w, r, "register", registerIPRate) {
+		return
+	}
+
 	// Step 1: Parse incoming JSON
 	// Step 2: make a var called req that calls the users struct
 	// look at the struct in the users files
@@ -99,7 +138,12 @@ func (h *AuthHandler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Congrats users, you registered successfully: %s", user.Email)
 
-	// Step 7: Send success response
+	// Step 7: Send the verification email, the account still works if this fails and they can ask for a new one
+	if err := h.sendVerificationEmail(r, user); err != nil {
+		log.Printf("❌ Failed to create verification token: %v", err)
+	}
+
+	// Step 8: Send success response
 	utils.ResponseJSON(w, http.StatusCreated, map[string]interface{}{
 		"message": "Registration successful! Please check your email to verify your account.",
 		"user":    user,
@@ -111,20 +155,30 @@ func (h *AuthHandler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 func (h *AuthHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println(" Login request received")
 
-	// Step 1: Parse login credentials
+	// Step 1: Throttle per ip before doing any work
+	if !h.throttleIP(w, r, "login", loginIPRate) {
+		return
+	}
+
+	// Step 2: Parse login credentials
 	var req models.LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid request format")
 		return
 	}
 
-	// Step 2: Validate required fields
+	// Step 3: Validate required fields
 	if req.Email == "" || req.Password == "" {
 		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Email and password are required")
 		return
 	}
 
-	// Step 3: Find user in database using new DB method
+	// Step 4: Throttle per email too, so spreading the guesses over many ips doesnt help
+	if !h.throttleEmail(w, r, "login", req.Email, loginEmailRate) {
+		return
+	}
+
+	// Step 5: Find user in database using new DB method
 	user, err := h.db.GetUserByEmail(r.Context(), req.Email)
 	if err != nil {
 		// Log failed login attempt
@@ -141,15 +195,38 @@ func (h *AuthHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Step 4: Check if user is local provider
-	if user.Provider != "local" {
+	// Step 6: Accounts created through OAuth have no password
+	if user.PasswordHash == "" {
 		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Please use OAuth login for this account")
 		return
 	}
 
-	// Step 5: Verify password matches stored hash
+	// Step 7: Refuse while the account is locked or still waiting out the delay from earlier wrong passwords
+	attempts, lockedFor, err := h.db.GetLoginLockout(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("❌ Failed to check login lockout: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	if lockedFor > 0 {
+		h.db.CreateAuditLog(
+			r.Context(),
+			&user.ID,
+			"login_failed",
+			utils.GetIPAddress(r),
+			r.UserAgent(),
+			false,
+			"account locked",
+		)
+		tooManyRequests(w, lockedFor, "Too many failed attempts. Try again later or reset your password.")
+		return
+	}
+
+	// Step 8: Verify password matches stored hash
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
 	if err != nil {
+		h.recordFailedLogin(r, user)
+
 		// Log failed login attempt
 		h.db.CreateAuditLog(
 			r.Context(),
@@ -164,25 +241,79 @@ func (h *AuthHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Step 6: Optional - Check if email is verified
-	// if !user.EmailVerified {
-	//     utils.RespondWithError(w, http.StatusForbidden, "Please verify your email before logging in")
-	//     return
-	// }
+	// the password was right, start counting from zero again
+	if attempts > 0 {
+		if err := h.db.UnlockUser(r.Context(), user.ID); err != nil {
+			log.Printf("⚠️  Failed to reset failed login counter: %v", err)
+		}
+	}
 
-	// Step 7: Create session
-	session, _ := config.GetSessionStore().Get(r, "auth-session")
-	session.Values["user_id"] = user.ID
-	session.Values["email"] = user.Email
-	session.Values["provider"] = user.Provider
+	// only tell them about admin actions once they proved they know the password
+	if h.accountSuspended(r, user) {
+		utils.ErrorResponseJSON(w, http.StatusForbidden, "This account has been suspended")
+		return
+	}
+	if user.PasswordResetRequired {
+		h.db.CreateAuditLog(
+			r.Context(),
+			&user.ID,
+			"login_failed",
+			utils.GetIPAddress(r),
+			r.UserAgent(),
+			false,
+			"password reset required",
+		)
+		utils.ErrorResponseJSON(w, http.StatusForbidden, "You need to choose a new password. Check your email for a reset link.")
+		return
+	}
 
-	if err := session.Save(r, w); err != nil {
+	// Step 9: Check if email is verified, only enforced when REQUIRE_EMAIL_VERIFICATION is on
+	if config.RequireEmailVerification() && !user.EmailVerified {
+		h.db.CreateAuditLog(
+			r.Context(),
+			&user.ID,
+			"login_failed",
+			utils.GetIPAddress(r),
+			r.UserAgent(),
+			false,
+			"email not verified",
+		)
+		utils.ErrorResponseJSON(w, http.StatusForbidden, "Please verify your email before logging in")
+		return
+	}
+
+	// Step 10: New device and suspicious login checks, a risky login without two factor may have to step up
+	hasMFA := h.mfaEnabled(r, user.ID)
+	if h.checkLoginRisk(r, user, hasMFA) {
+		utils.ResponseJSON(w, http.StatusForbidden, map[string]interface{}{
+			"message":        "This sign in looks unusual. We emailed you a link to finish signing in.",
+			"stepUpRequired": true,
+		})
+		return
+	}
+
+	// Step 11: If two factor is on, park the login in the mfa-pending state instead of creating the session
+	if hasMFA {
+		if err := setMFAPending(w, r, user.ID, user.Provider); err != nil {
+			log.Printf("❌ Failed to save mfa-pending state: %v", err)
+			utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to create session")
+			return
+		}
+		utils.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+			"message":     "Enter the code from your authenticator app",
+			"mfaRequired": true,
+		})
+		return
+	}
+
+	// Step 12: Create session
+	if err := h.startSession(w, r, user, user.Provider); err != nil {
 		log.Printf("❌ Failed to save session: %v", err)
 		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to create session")
 		return
 	}
 
-	// Step 8: Log successful login
+	// Step 13: Log successful login
 	h.db.CreateAuditLog(
 		r.Context(),
 		&user.ID,
@@ -208,10 +339,39 @@ func (h *AuthHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 // 2. OAUTH AUTHENTICATION
 // ============================================
 
+// ProvidersHandler lists the OAuth providers that are turned on so the login page knows which buttons to show
+// GET /api/auth/providers
+func (h *AuthHandler) ProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	providers := config.EnabledProviders()
+	if providers == nil {
+		providers = []config.OAuthProvider{}
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"providers": providers,
+	})
+}
+
+// knownProvider checks {provider} against the providers registered in InitAuth
+// without this an unknown name makes gothic fail with an internal error. callers hold config.HoldProviders
+func knownProvider(w http.ResponseWriter, provider string) bool {
+	if _, err := goth.GetProvider(provider); err != nil {
+		log.Printf("⚠️  Unknown OAuth provider requested: %q", provider)
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "Unknown login provider")
+		return false
+	}
+	return true
+}
+
 // BeginAuthHandler initiates OAuth flow
 // GET /auth/{provider}
 func (h *AuthHandler) BeginAuthHandler(w http.ResponseWriter, r *http.Request) {
+	defer config.HoldProviders()()
+
 	provider := mux.Vars(r)["provider"]
+	if !knownProvider(w, provider) {
+		return
+	}
 	log.Printf("🔑 Starting OAuth flow with provider: %s", provider)
 
 	q := r.URL.Query()
@@ -222,80 +382,81 @@ func (h *AuthHandler) BeginAuthHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // CallbackHandler processes OAuth callback
-// GET /auth/{provider}/callback
+// GET /auth/{provider}/callback (POST for apple, which sends the code as a form post)
 func (h *AuthHandler) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	defer config.HoldProviders()()
+
 	provider := mux.Vars(r)["provider"]
+	if !knownProvider(w, provider) {
+		return
+	}
 	log.Printf("🔄 OAuth callback received from: %s", provider)
 
 	q := r.URL.Query()
-	q.Add("provider", provider)
+	// gothic only reads the code and state from the query string, so copy a form post into it
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err == nil {
+			for key, values := range r.PostForm {
+				q[key] = values
+			}
+		}
+	}
+	q.Set("provider", provider)
 	r.URL.RawQuery = q.Encode()
 
+	// every redirect below is a 303 so the browser follows it with a GET even when apple posted to us
+
 	// Step 1: Complete OAuth flow
+	// a link request has to be read first, gothic clears its session once the flow completes
+	linkUserID, linking := oauthLinkRequest(r, provider)
+
 	// this comes from the OAUth config flow
 	gothUser, err := gothic.CompleteUserAuth(w, r)
 	if err != nil {
 		log.Printf("❌ OAuth authentication failed: %v", err)
-		http.Redirect(w, r, config.GetFrontendURL()+"/Login?error=oauth_failed", http.StatusTemporaryRedirect)
+		http.Redirect(w, r, config.GetFrontendURL()+"/Login?error=oauth_failed", http.StatusSeeOther)
 		return
 	}
 
 	log.Printf("✅ OAuth user data received: %s (%s)", gothUser.Email, gothUser.Name)
 
-	// Step 2: Check if user exists using new DB method
-	user, err := h.db.GetUserByProviderID(r.Context(), provider, gothUser.UserID)
-
-	// Step 3: Create new user if they don't exist
-	if err != nil {
-		log.Printf("👤 Creating new user from %s OAuth: %s", provider, gothUser.Email)
+	// Step 2: A logged in user linking this provider from their settings
+	if linking {
+		h.finishLink(w, r, linkUserID, provider, gothUser)
+		return
+	}
 
-		firstName := gothUser.FirstName
-		lastName := gothUser.LastName
-		if firstName == "" {
-			firstName = gothUser.Name
-		}
+	// Step 3: Find the linked user, link by verified email, or create a new user
+	user, errorCode := h.oauthAccount(r, provider, gothUser)
+	if user == nil {
+		http.Redirect(w, r, config.GetFrontendURL()+"/Login?error="+errorCode, http.StatusSeeOther)
+		return
+	}
+	if h.accountSuspended(r, user) {
+		http.Redirect(w, r, config.GetFrontendURL()+"/Login?error=account_suspended", http.StatusSeeOther)
+		return
+	}
+	hasMFA := h.mfaEnabled(r, user.ID)
+	if h.checkLoginRisk(r, user, hasMFA) {
+		http.Redirect(w, r, config.GetFrontendURL()+"/Login?error=step_up_required", http.StatusSeeOther)
+		return
+	}
 
-		// Create user using new DB method
-		user, err = h.db.CreateUser(
-			r.Context(),
-			gothUser.Email,
-			"",
-			firstName,
-			lastName,
-			"student",       //  Default role for OAuth users
-			provider,        //  "google", "github", etc.
-			gothUser.UserID, //  OAuth provider's user ID
-		)
-		if err != nil {
-			log.Printf("❌ Failed to create OAuth user: %v", err)
-			http.Redirect(w, r, config.GetFrontendURL()+"/Login?error=create_failed", http.StatusTemporaryRedirect)
+	// Step 4: Two factor users still need their code after OAuth, the frontend shows the code form
+	if hasMFA {
+		if err := setMFAPending(w, r, user.ID, provider); err != nil {
+			log.Printf("❌ Failed to save mfa-pending state: %v", err)
+			http.Redirect(w, r, config.GetFrontendURL()+"/Login?error=session_failed", http.StatusSeeOther)
 			return
 		}
-
-		// Verify email automatically for OAuth users
-		h.db.VerifyEmail(r.Context(), user.ID)
-
-		// Log OAuth registration
-		h.db.CreateAuditLog(
-			r.Context(),
-			&user.ID,
-			"oauth_register",
-			utils.GetIPAddress(r),
-			r.UserAgent(),
-			true,
-			"",
-		)
+		http.Redirect(w, r, config.GetFrontendURL()+"/Login?mfa=required", http.StatusSeeOther)
+		return
 	}
 
-	// Step 4: Create session
-	session, _ := config.GetSessionStore().Get(r, "auth-session")
-	session.Values["user_id"] = user.ID
-	session.Values["email"] = user.Email
-	session.Values["provider"] = provider
-
-	if err := session.Save(r, w); err != nil {
+	// Step 5: Create session
+	if err := h.startSession(w, r, user, provider); err != nil {
 		log.Printf("❌ Failed to save OAuth session: %v", err)
-		http.Redirect(w, r, config.GetFrontendURL()+"/Login?error=session_failed", http.StatusTemporaryRedirect)
+		http.Redirect(w, r, config.GetFrontendURL()+"/Login?error=session_failed", http.StatusSeeOther)
 		return
 	}
 
@@ -312,18 +473,62 @@ func (h *AuthHandler) CallbackHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("✅ OAuth login successful: %s via %s", user.Email, provider)
 
-	http.Redirect(w, r, config.GetFrontendURL()+"/auth/callback?Login=success", http.StatusTemporaryRedirect)
+	http.Redirect(w, r, config.GetFrontendURL()+"/auth/callback?Login=success", http.StatusSeeOther)
 }
 
 // ============================================
 // 3. SESSION MANAGEMENT
 // ============================================
 
+// accountSuspended records a login attempt on an account an admin suspended
+// every login path checks it before creating a session or an mfa-pending state
+func (h *AuthHandler) accountSuspended(r *http.Request, user *database.User) bool {
+	if user.SuspendedAt == nil {
+		return false
+	}
+	h.db.CreateAuditLog(
+		r.Context(),
+		&user.ID,
+		"login_failed",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		false,
+		"account suspended",
+	)
+	return true
+}
+
+// startSession logs the user in by writing a fresh auth-session
+// every login (password, OAuth, two factor) goes through here so the session always looks the same
+func (h *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, user *database.User, provider string) error {
+	session, _ := config.GetSessionStore().Get(r, "auth-session")
+
+	// never reuse an id from before the login, that would let someone plant a session id on a victim
+	if !session.IsNew && session.ID != "" {
+		h.db.DeleteSession(r.Context(), config.SessionRecordID(session.ID))
+		session.ID = ""
+		session.Values = make(map[interface{}]interface{})
+	}
+
+	session.Values["user_id"] = user.ID
+	session.Values["email"] = user.Email
+	session.Values["provider"] = provider
+	// "Sign in with VirgoAI" puts this in the id token and checks it against max_age
+	session.Values["auth_time"] = time.Now().Unix()
+
+	if err := session.Save(r, w); err != nil {
+		return err
+	}
+
+	// the next login from this device is no longer new
+	h.recordLogin(r, user.ID)
+	return nil
+}
+
 // GetCurrentUserHandler returns logged-in user's information
 // GET /api/auth/me
 func (h *AuthHandler) GetCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
-	session, _ := config.GetSessionStore().Get(r, "auth-session")
-	userID, ok := session.Values["user_id"].(int)
+	userID, ok := currentUserID(r)
 	if !ok {
 		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
 		return
@@ -404,8 +609,8 @@ func (h *AuthHandler) ChangePasswordHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	session, _ := config.GetSessionStore().Get(r, "auth-session")
-	userID, ok := session.Values["user_id"].(int)
+	// the mobile app changes its password with its access token, there's no cookie then
+	userID, ok := currentUserID(r)
 	if !ok {
 		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
 		return
@@ -418,7 +623,8 @@ func (h *AuthHandler) ChangePasswordHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	if user.Provider != "local" {
+	// accounts created through OAuth never had a password to change
+	if user.PasswordHash == "" {
 		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Cannot change password for OAuth accounts")
 		return
 	}
@@ -443,6 +649,15 @@ func (h *AuthHandler) ChangePasswordHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Sign out every other device, whoever knew the old password shouldnt keep their session.
+	// from the app every cookie session goes and only the app's own refresh token family stays
+	if _, err := h.db.DeleteUserSessions(r.Context(), userID, currentSessionRecordID(r)); err != nil {
+		log.Printf("⚠️  Failed to revoke other sessions: %v", err)
+	}
+	if _, err := h.db.RevokeUserRefreshTokens(r.Context(), userID, currentRefreshFamily(r)); err != nil {
+		log.Printf("⚠️  Failed to revoke other refresh tokens: %v", err)
+	}
+
 	// Log password change
 	h.db.CreateAuditLog(
 		r.Context(),
@@ -477,9 +692,15 @@ func (h *AuthHandler) ForgotPasswordHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// dont let anyone flood an inbox with reset emails
+	if !h.throttleIP(w, r, "forgot-password", forgotPasswordIPRate) ||
+		!h.throttleEmail(w, r, "forgot-password", req.Email, forgotPasswordRate) {
+		return
+	}
+
 	// Check if user exists
 	user, err := h.db.GetUserByEmail(r.Context(), req.Email)
-	if err == nil && user.Provider == "local" {
+	if err == nil && user.PasswordHash != "" {
 		// Generate reset token
 		token := utils.GenerateSecureToken(32)
 		expiresAt := time.Now().Add(15 * time.Minute)
@@ -488,8 +709,7 @@ func (h *AuthHandler) ForgotPasswordHandler(w http.ResponseWriter, r *http.Reque
 		if err := h.db.CreatePasswordResetToken(r.Context(), user.ID, token, expiresAt); err != nil {
 			log.Printf("❌ Failed to create reset token: %v", err)
 		} else {
-			// TODO: Send email with reset link
-			// utils.SendPasswordResetEmail(user.Email, token)
+			h.sendEmail(r, user, mailer.TemplatePasswordReset, config.GetFrontendURL()+"/reset-password?token="+url.QueryEscape(token))
 			log.Printf("📧 Password reset token created for: %s", req.Email)
 		}
 	}
@@ -505,6 +725,11 @@ func (h *AuthHandler) ForgotPasswordHandler(w http.ResponseWriter, r *http.Reque
 func (h *AuthHandler) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("🔓 Password reset request received")
 
+	// reset tokens are long and random, this just stops anyone from hammering the endpoint
+	if !h.throttleIP(w, r, "reset-password", resetPasswordIPRate) {
+		return
+	}
+
 	var req models.ResetPasswordRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		utils.ResponseJSON(w, http.StatusBadRequest, "Invalid request format")
@@ -561,6 +786,19 @@ func (h *AuthHandler) ResetPasswordHandler(w http.ResponseWriter, r *http.Reques
 	// Mark token as used
 	h.db.MarkPasswordResetTokenAsUsed(r.Context(), req.Token)
 
+	// proving they own the inbox is enough to lift a lockout
+	if err := h.db.UnlockUser(r.Context(), userID); err != nil {
+		log.Printf("⚠️  Failed to unlock user: %v", err)
+	}
+
+	// A reset means the account may have been taken over, so sign out every session
+	if _, err := h.db.DeleteUserSessions(r.Context(), userID, ""); err != nil {
+		log.Printf("⚠️  Failed to revoke sessions: %v", err)
+	}
+	if _, err := h.db.RevokeUserRefreshTokens(r.Context(), userID, ""); err != nil {
+		log.Printf("⚠️  Failed to revoke refresh tokens: %v", err)
+	}
+
 	// Log password reset
 	h.db.CreateAuditLog(
 		r.Context(),
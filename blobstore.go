@@ -0,0 +1,86 @@
+// backend/storage/blobstore.go
+package storage
+
+// the storage package keeps uploaded files (avatars, audio, pdfs). handlers only talk to the BlobStore interface,
+// which one we actually use is picked from STORAGE_DRIVER in the env file:
+//   local -> files in STORAGE_DIR, served by the backend itself through signed urls. fine for dev and a single server
+//   s3    -> any s3 compatible bucket (aws, minio, r2...), the bucket serves the files through presigned urls
+//
+// the database only stores object keys, never urls, since every download url expires
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Get when there is no object with that key
+var ErrNotFound = errors.New("object not found")
+
+// BlobStore is implemented by every storage driver
+type BlobStore interface {
+	// Put stores size bytes from body under key, replacing whatever was there
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Get opens the object, the caller closes it
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object, deleting a key that doesnt exist is not an error
+	Delete(ctx context.Context, key string) error
+	// SignedURL is a download url for key that stops working after ttl
+	SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// NewFromEnv builds the store chosen by STORAGE_DRIVER, backendURL is where the local driver serves files from
+func NewFromEnv(backendURL string) (BlobStore, error) {
+	switch driver := getEnv("STORAGE_DRIVER", "local"); driver {
+	case "local":
+		return NewLocalStore(getEnv("STORAGE_DIR", "tmp/uploads"), backendURL+"/api/media/files", os.Getenv("STORAGE_SIGNING_KEY"))
+
+	case "s3":
+		bucket := os.Getenv("S3_BUCKET")
+		if bucket == "" {
+			return nil, fmt.Errorf("S3_BUCKET is required for the s3 storage driver")
+		}
+		return NewS3Store(S3Config{
+			Endpoint:  getEnv("S3_ENDPOINT", "s3.amazonaws.com"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    bucket,
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			UseSSL:    getEnv("S3_USE_SSL", "true") == "true",
+		})
+
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_DRIVER %q", driver)
+	}
+}
+
+// keys are random hex plus an extension, so they never contain a slash or anything a file path could trip on
+var validKey = regexp.MustCompile(`^[a-f0-9]{32}(\.[a-z0-9]+)+$`)
+
+// NewKey makes a random object key ending in ext (".png", ".pdf"...)
+func NewKey(ext string) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return hex.EncodeToString(b) + strings.ToLower(ext)
+}
+
+// ValidKey reports whether key looks like something NewKey made
+func ValidKey(key string) bool {
+	return validKey.MatchString(key)
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,299 @@
+package main
+
+// small command line for maintenance tasks so we dont have to open psql on the server
+// usage:
+//   go run . migrate up
+//   go run . migrate down [steps]
+//   go run . migrate status
+//   go run . unlock <email>
+//   go run . set-role <email> <role>
+//   go run . purge-deleted
+//   go run . audit verify
+//   go run . oidc add-client [--public] [--first-party] <name> <redirect uri>...
+//   go run . oidc list-clients
+//   go run . oidc delete-client <client id>
+//   go run . oidc rotate-keys
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/models"
+	"backend/oidc"
+	"backend/storage"
+	"backend/utils"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runCommand picks the subcommand from the args after the program name
+func runCommand(ctx context.Context, db *database.Postgres, args []string) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrateCommand(ctx, db, args[1:])
+	case "unlock":
+		return runUnlockCommand(ctx, db, args[1:])
+	case "set-role":
+		return runSetRoleCommand(ctx, db, args[1:])
+	case "purge-deleted":
+		return runPurgeDeletedCommand(ctx, db)
+	case "audit":
+		return runAuditCommand(ctx, db, args[1:])
+	case "oidc":
+		return runOIDCCommand(ctx, db, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// runMigrateCommand handles "migrate up", "migrate down [steps]" and "migrate status"
+func runMigrateCommand(ctx context.Context, db *database.Postgres, args []string) error {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "up":
+		return db.Migrate(ctx)
+
+	case "down":
+		// default to rolling back one migration so nobody drops the whole schema by accident
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of steps: %s", args[1])
+			}
+			steps = n
+		}
+		return db.MigrateDown(ctx, steps)
+
+	case "status":
+		statuses, err := db.GetMigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			if status.Applied {
+				fmt.Printf("%04d_%s\tapplied %s\n", status.Version, status.Name, status.AppliedAt.Format("2006-01-02 15:04:05"))
+			} else {
+				fmt.Printf("%04d_%s\tpending\n", status.Version, status.Name)
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown migrate action %q (use up, down or status)", action)
+	}
+}
+
+// runUnlockCommand clears a locked account so support can help a learner without waiting for the lockout to expire
+func runUnlockCommand(ctx context.Context, db *database.Postgres, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: unlock <email>")
+	}
+
+	user, err := db.GetUserByEmail(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if err := db.UnlockUser(ctx, user.ID); err != nil {
+		return err
+	}
+
+	db.CreateAuditLog(ctx, &user.ID, "account_unlocked", "", "cli", true, "")
+	fmt.Printf("unlocked %s\n", user.Email)
+	return nil
+}
+
+// runSetRoleCommand changes a user's role, this is how the first platform_admin gets created
+func runSetRoleCommand(ctx context.Context, db *database.Postgres, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: set-role <email> <role>")
+	}
+	if args[1] == models.RoleSchoolAdmin {
+		return fmt.Errorf("school_admin can't be assigned yet, it has no admin permissions until the admin endpoints are scoped to an organization")
+	}
+	if !models.AssignableRole(args[1]) {
+		return fmt.Errorf("unknown role %q", args[1])
+	}
+
+	user, err := db.GetUserByEmail(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if err := db.UpdateUserRole(ctx, user.ID, args[1]); err != nil {
+		return err
+	}
+
+	db.CreateAuditLog(ctx, &user.ID, "role_changed", "", "cli", true, user.Role+" -> "+args[1])
+	fmt.Printf("%s is now %s\n", user.Email, args[1])
+	return nil
+}
+
+// runPurgeDeletedCommand runs the account deletion job once, handy when the server isnt running
+func runPurgeDeletedCommand(ctx context.Context, db *database.Postgres) error {
+	store, err := storage.NewFromEnv(config.GetBackendURL())
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for {
+		deleted, err := purgeDeletedAccounts(ctx, db, store)
+		if err != nil {
+			return err
+		}
+		total += deleted
+		if deleted < deletionJobBatch {
+			break
+		}
+	}
+
+	fmt.Printf("deleted %d accounts\n", total)
+	return nil
+}
+
+// runAuditCommand handles "audit verify", it fails when the audit chain is broken so it can run from cron
+func runAuditCommand(ctx context.Context, db *database.Postgres, args []string) error {
+	if len(args) != 1 || args[0] != "verify" {
+		return fmt.Errorf("usage: audit verify")
+	}
+
+	report, err := db.VerifyAuditChain(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("checked %d chained rows (%d older rows are unchained)\n", report.Checked, report.Unchained)
+	if !report.Intact() {
+		fmt.Printf("first broken row: %d (previous chained row %d)\n", report.BrokenID, report.PreviousRowID)
+		fmt.Printf("reason: %s\n", report.BrokenReason)
+		if report.ExpectedHash != "" {
+			fmt.Printf("expected %s\nstored   %s\n", report.ExpectedHash, report.StoredHash)
+		}
+		return fmt.Errorf("audit chain is broken at row %d", report.BrokenID)
+	}
+
+	fmt.Printf("chain intact, head row %d hash %s\n", report.HeadID, report.HeadHash)
+	return nil
+}
+
+// runOIDCCommand registers the partner apps that use "Sign in with VirgoAI" and rotates the id token keys
+func runOIDCCommand(ctx context.Context, db *database.Postgres, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: oidc add-client|list-clients|delete-client|rotate-keys")
+	}
+
+	switch args[0] {
+	case "add-client":
+		return runAddOIDCClient(ctx, db, args[1:])
+
+	case "list-clients":
+		clients, err := db.ListOAuthClients(ctx)
+		if err != nil {
+			return err
+		}
+		for _, client := range clients {
+			kind := "confidential"
+			if client.Public() {
+				kind = "public"
+			}
+			if client.FirstParty {
+				kind += ", first party"
+			}
+			fmt.Printf("%s\t%s (%s)\t%s\n", client.ClientID, client.Name, kind, strings.Join(client.RedirectURIs, " "))
+		}
+		return nil
+
+	case "delete-client":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: oidc delete-client <client id>")
+		}
+		if err := db.DeleteOAuthClient(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("deleted %s\n", args[1])
+		return nil
+
+	case "rotate-keys":
+		keys, err := oidc.NewKeySetFromEnv(db)
+		if err != nil {
+			return err
+		}
+		kid, err := keys.Rotate(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("now signing with key %s, replicas pick it up within a few minutes\n", kid)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown oidc action %q", args[0])
+	}
+}
+
+// runAddOIDCClient handles "oidc add-client", the secret is printed once and only its hash is stored
+func runAddOIDCClient(ctx context.Context, db *database.Postgres, args []string) error {
+	public, firstParty := false, false
+	var rest []string
+	for _, arg := range args {
+		switch arg {
+		case "--public":
+			public = true
+		case "--first-party":
+			firstParty = true
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if len(rest) < 2 {
+		return fmt.Errorf("usage: oidc add-client [--public] [--first-party] <name> <redirect uri>...")
+	}
+
+	// redirect uris are matched exactly, so catch typos here. http is only ok for local development
+	for _, redirectURI := range rest[1:] {
+		parsed, err := url.Parse(redirectURI)
+		if err != nil || parsed.Scheme == "" || parsed.Fragment != "" {
+			return fmt.Errorf("invalid redirect uri %q", redirectURI)
+		}
+		if parsed.Scheme == "http" && parsed.Hostname() != "localhost" && parsed.Hostname() != "127.0.0.1" {
+			return fmt.Errorf("redirect uri %q must use https", redirectURI)
+		}
+	}
+
+	// client ids end up in urls, so hex instead of a token that may need escaping
+	idBytes := make([]byte, 12)
+	if _, err := rand.Read(idBytes); err != nil {
+		return fmt.Errorf("unable to generate client id: %w", err)
+	}
+
+	client := &database.OAuthClient{
+		ClientID:     "vgo_" + hex.EncodeToString(idBytes),
+		Name:         rest[0],
+		RedirectURIs: rest[1:],
+		FirstParty:   firstParty,
+		CreatedAt:    time.Now().UTC().Truncate(time.Microsecond),
+	}
+	secret := ""
+	if !public {
+		secret = utils.GenerateSecureToken(32)
+		client.ClientSecretHash = utils.HashToken(secret)
+	}
+	if err := db.CreateOAuthClient(ctx, client); err != nil {
+		return err
+	}
+
+	fmt.Printf("client_id     %s\n", client.ClientID)
+	if secret != "" {
+		fmt.Printf("client_secret %s\n", secret)
+		fmt.Println("the secret is not stored, copy it now")
+	}
+	return nil
+}
@@ -2,43 +2,84 @@
 package config
 
 import (
+	"backend/database"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/gorilla/sessions"
 	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
-	"github.com/markbates/goth/providers/google"
 )
 
 const (
 	// cost of hashing algos should be between (11-14)
 	BcryptCost = 12
+	// how long the link in a verification email stays valid
+	EmailVerificationTTL = 24 * time.Hour
+	// how long an emailed sign in link stays valid, short because the link alone logs you in
+	MagicLinkTTL = 15 * time.Minute
+	// how long a download url for an uploaded file works, the frontend asks for a fresh one after that
+	MediaURLTTL = 15 * time.Minute
+	// how long a deleted account can still be restored, the account_deletion email says 30 days too
+	AccountDeletionGrace = 30 * 24 * time.Hour
+	// how long a "Sign in with VirgoAI" authorization code can be swapped for tokens
+	AuthorizationCodeTTL = 5 * time.Minute
+	// how long the id tokens and access tokens we give partner apps are valid
+	OIDCTokenTTL = time.Hour
+	// how long a school's identity provider has to answer our SAML AuthnRequest
+	SAMLRequestTTL = 10 * time.Minute
+	// how long the mobile app's access tokens are valid, they can't be revoked so keep it short
+	AccessTokenTTL = 15 * time.Minute
+	// how long a mobile refresh token is valid, every refresh hands out a new one so an app in use stays signed in
+	RefreshTokenTTL = 30 * 24 * time.Hour
 )
 
 var store *sessions.CookieStore
 
+// webAuthn runs the passkey ceremonies, set up by InitWebAuthn
+var webAuthn *webauthn.WebAuthn
+
+// sessionStore backs the auth-session cookie, set up by InitSessionStore once the db is connected
+var sessionStore *PostgresStore
+
 // here i init the auth from goth
-// Note if you need other client id's you can use goth providers
-func InitAuth() {
-	googleClientID := os.Getenv("GOOGLE_CLIENT_ID")
-	googleClientSecret := os.Getenv("GOOGLE_CLIENT_SECRET")
-	callbackURL := os.Getenv("BACKEND_URL") // this is the backend url
+// every provider turned on in the env file gets registered, see providers.go
+func InitAuth() error {
+	providers, enabled, usesFormPost, err := buildProviders(GetBackendURL())
+	if err != nil {
+		return err
+	}
+	if len(providers) == 0 {
+		log.Println("⚠️  No OAuth providers configured, only email/password and passkey login will work")
+	}
 
 	// orginally i did this goth.providers((Google.Client, callbackURL))
 	// key = "", maxAge:= 86400 *30, store:= sessions
-	goth.UseProviders(
-		google.New(googleClientID, googleClientSecret,
-			callbackURL+"/api/auth/google/callback",
-			"email", "profile"),
-	)
-	// my custom store
-	gothic.Store = GetSessionStore()
-
-	if callbackURL == "" {
-		callbackURL = "http://localhost:8080"
+	goth.UseProviders(providers...)
+	oauthProviders = enabled
+
+	// gothic only keeps the short lived OAuth state, so it stays on a cookie store
+	gothic.Store = GetStore()
+	if usesFormPost {
+		// apple posts the callback to us from its own site, a Lax cookie isnt sent with a cross site POST
+		// so the OAuth state gets its own SameSite=None cookie instead of loosening the shared store
+		oauthStore := sessions.NewCookieStore([]byte(os.Getenv("SESSIONKEY")))
+		oauthStore.Options = &sessions.Options{
+			Path:     "/",
+			MaxAge:   600,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteNoneMode,
+		}
+		gothic.Store = oauthStore
 	}
 
+	return nil
 }
 
 // get store comes from gorilla/sessions
@@ -59,14 +100,32 @@ func GetStore() *sessions.CookieStore {
 			MaxAge:   86400 * 7, // will it be active for 7 days
 			HttpOnly: true,
 			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
 		}
 	}
 	return store
 }
 
-// getter method that points to the sessions cookestore from max
-func GetSessionStore() *sessions.CookieStore {
-	return GetStore()
+// InitSessionStore moves the auth-session into postgres so sessions can be revoked server side
+// the cookie still uses SESSIONKEY to sign the session id
+func InitSessionStore(db *database.Postgres) {
+	sessionStore = NewPostgresStore(db, []byte(os.Getenv("SESSIONKEY")))
+	sessionStore.Options = &sessions.Options{
+		Path:     "/",
+		MaxAge:   86400 * 7, // will it be active for 7 days, every request pushes this forward
+		HttpOnly: true,
+		Secure:   true,
+		// other sites' posts go without the cookie, the CSRF middleware covers the rest
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// getter method that points to the postgres session store, call InitSessionStore first
+func GetSessionStore() *PostgresStore {
+	if sessionStore == nil {
+		log.Fatal("GetSessionStore called before InitSessionStore")
+	}
+	return sessionStore
 }
 
 // Getter method for the frontend
@@ -77,3 +136,63 @@ func GetFrontendURL() string {
 	}
 	return url
 }
+
+// GetBackendURL is where this server is reachable from the browser, used for OAuth callbacks and emailed links
+func GetBackendURL() string {
+	url := os.Getenv("BACKEND_URL")
+	if url == "" {
+		return "http://localhost:8080"
+	}
+	return url
+}
+
+// RequireEmailVerification decides if local users must verify their email before they can log in
+// set REQUIRE_EMAIL_VERIFICATION=true in the env file to turn it on
+func RequireEmailVerification() bool {
+	required, err := strconv.ParseBool(os.Getenv("REQUIRE_EMAIL_VERIFICATION"))
+	if err != nil {
+		return false
+	}
+	return required
+}
+
+// MFAIssuer is the name authenticator apps show next to the 6 digit code
+func MFAIssuer() string {
+	issuer := os.Getenv("MFA_ISSUER")
+	if issuer == "" {
+		return "VirgoAI"
+	}
+	return issuer
+}
+
+// InitWebAuthn sets up passkeys. the RP ID has to be the domain the frontend runs on (no scheme or port)
+// and the origins are every url the browser can run the ceremony from
+func InitWebAuthn() error {
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = "localhost"
+	}
+	origins := []string{GetFrontendURL()}
+	if extra := os.Getenv("WEBAUTHN_RP_ORIGINS"); extra != "" {
+		origins = strings.Split(extra, ",")
+	}
+
+	wa, err := webauthn.New(&webauthn.Config{
+		RPDisplayName: MFAIssuer(),
+		RPID:          rpID,
+		RPOrigins:     origins,
+	})
+	if err != nil {
+		return err
+	}
+	webAuthn = wa
+	return nil
+}
+
+// GetWebAuthn returns the passkey config, call InitWebAuthn first
+func GetWebAuthn() *webauthn.WebAuthn {
+	if webAuthn == nil {
+		log.Fatal("GetWebAuthn called before InitWebAuthn")
+	}
+	return webAuthn
+}
@@ -0,0 +1,171 @@
+// backend/middleware/csrf.go
+package middleware
+
+// CSRF protects the cookie authenticated routes from forms and fetches other sites make with the user's cookies:
+//
+//	api.Use(middleware.CSRF("/api/auth/saml/{org}/acs"))
+//
+// every POST, PUT, PATCH and DELETE has to come from the frontend or the backend itself (Origin, or Referer when there's no Origin),
+// and when it carries the auth-session cookie it also needs the X-CSRF-Token header. the token is double submitted:
+// GET /api/auth/csrf sets it in a cookie and returns it, the Next.js app sends it back in the header.
+// the token is bound to the session, nonce.hmac(SESSIONKEY, session id + nonce). a sibling subdomain can plant cookies,
+// but a token it got for its own session is worthless with anyone else's, and it can't read the victim's session id.
+// signing in starts a new session, so the app has to fetch a new token after that.
+// requests with Authorization: Bearer skip all of this, a browser never adds that header on its own
+
+import (
+	"backend/config"
+	"backend/utils"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// CSRFCookieName holds the signed token, HttpOnly since the frontend gets its copy from GET /api/auth/csrf
+	CSRFCookieName = "csrf_token"
+	// CSRFHeaderName is where the frontend sends the token back
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
+// CSRF checks every state changing request except bearer token requests and the route templates in exempt,
+// which are for posts other sites make on purpose (an identity provider's response) and carry their own state check
+func CSRF(exempt ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			scheme, _, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if strings.EqualFold(scheme, "Bearer") || csrfExempt(r, exempt) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Step 1: The request has to come from one of our own pages
+			if !trustedOrigin(r) {
+				log.Printf("⛔ Cross site %s %s refused from %s (origin %q)", r.Method, r.URL.Path, utils.GetIPAddress(r), r.Header.Get("Origin"))
+				utils.ErrorResponseJSON(w, http.StatusForbidden, "Cross site request refused")
+				return
+			}
+
+			// Step 2: With the session cookie the double submitted token has to match too and belong to that session,
+			// without it there's nothing a forged request could act with (the mobile app after swapping its cookie)
+			if _, err := r.Cookie("auth-session"); err == nil {
+				cookie, err := r.Cookie(CSRFCookieName)
+				if err != nil || !validCSRFToken(cookie.Value, csrfSessionID(r)) || !hmac.Equal([]byte(cookie.Value), []byte(r.Header.Get(CSRFHeaderName))) {
+					log.Printf("⛔ Missing or invalid CSRF token for %s %s from %s", r.Method, r.URL.Path, utils.GetIPAddress(r))
+					utils.ErrorResponseJSON(w, http.StatusForbidden, "CSRF token missing or invalid")
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CSRFToken returns the request's token, a valid one for this session from the cookie is kept so tabs open
+// at the same time don't fight, otherwise a new one is made and set as the cookie
+func CSRFToken(w http.ResponseWriter, r *http.Request) string {
+	sessionID := csrfSessionID(r)
+	if cookie, err := r.Cookie(CSRFCookieName); err == nil && validCSRFToken(cookie.Value, sessionID) {
+		return cookie.Value
+	}
+
+	nonce := utils.GenerateSecureToken(32)
+	token := nonce + "." + signCSRFNonce(sessionID, nonce)
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   86400 * 7, // same as the auth-session
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token
+}
+
+// validCSRFToken checks the token is nonce.signature with our signature for sessionID
+func validCSRFToken(token, sessionID string) bool {
+	nonce, signature, ok := strings.Cut(token, ".")
+	if !ok || nonce == "" {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(signCSRFNonce(sessionID, nonce)))
+}
+
+// signCSRFNonce is the hmac of the session id and nonce, "" is the session id of someone not signed in.
+// the session id has no "." or ":" in it (base32), so the two can't run into each other
+func signCSRFNonce(sessionID, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("SESSIONKEY")))
+	mac.Write([]byte("csrf:" + sessionID + ":" + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// csrfSessionID is the id of the request's auth-session, "" without a (live) one.
+// the store caches the session for the request, LoadUser and the handlers don't load it again
+func csrfSessionID(r *http.Request) string {
+	session, err := config.GetSessionStore().Get(r, "auth-session")
+	if err != nil || session == nil {
+		return ""
+	}
+	return session.ID
+}
+
+// trustedOrigin is true when Origin (or Referer, some browsers leave Origin off same origin posts) is the frontend or the backend.
+// a request with neither isn't from a browser page, apps and scripts don't send them
+func trustedOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+		if source == "" {
+			return true
+		}
+	}
+
+	// "null" and anything else that doesn't parse is an opaque origin like a sandboxed iframe
+	origin := originOf(source)
+	if origin == "" {
+		return false
+	}
+	return origin == originOf(config.GetFrontendURL()) || origin == originOf(config.GetBackendURL())
+}
+
+// originOf reduces a url to scheme://host[:port], "" when it isn't an absolute url
+func originOf(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// csrfExempt is true when the matched route's template is in exempt
+func csrfExempt(r *http.Request, exempt []string) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return false
+	}
+	for _, path := range exempt {
+		if path == template {
+			return true
+		}
+	}
+	return false
+}
@@ -11,6 +11,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
@@ -25,7 +26,8 @@ import (
 // safer for thread usage
 // for go make sure the name of the struct is upper case because then they can be exported
 type Postgres struct {
-	db *pgxpool.Pool
+	db       *pgxpool.Pool
+	auditKey []byte // see SetAuditChainKey
 }
 
 // Uses sync.Once to guarantee the connection pool is initialized exactly once, even with concurrent access
@@ -89,106 +91,6 @@ func (pg *Postgres) Close() {
 	pg.db.Close()
 }
 
-// here we will implement the schema for the project
-func (pg *Postgres) CreateTables(ctx context.Context) error {
-	log.Println("📋 Creating database tables...")
-
-	// Users table
-	// note this comes from users models and mirror them
-	// users:= Create Table users( ID, email, passwordhash, Note: this means that the parameters must be in the same order)
-	//
-	usersTable := `
-CREATE TABLE IF NOT EXISTS users (
-    id SERIAL PRIMARY KEY,
-    email VARCHAR(255) NOT NULL UNIQUE,
-    password_hash VARCHAR(255),
-    first_name VARCHAR(100),
-    last_name VARCHAR(100),
-    role VARCHAR(50) DEFAULT 'student',  -- ✅ ADD THIS
-    provider VARCHAR(50) DEFAULT 'local',
-    provider_id VARCHAR(255),
-    email_verified BOOLEAN DEFAULT FALSE,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    CONSTRAINT unique_provider_user UNIQUE (provider, provider_id)
-);
-CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
-CREATE INDEX IF NOT EXISTS idx_users_provider ON users(provider, provider_id);
-CREATE INDEX IF NOT EXISTS idx_users_role ON users(role); 
-`
-	// check the edge case such that the user is empty
-	if _, err := pg.db.Exec(ctx, usersTable); err != nil {
-		return fmt.Errorf("failed to create users table: %w", err)
-	}
-	log.Println(" Users table ready")
-
-	// Password reset tokens table
-	resetTokensTable := `
-	CREATE TABLE IF NOT EXISTS password_reset_tokens (
-		id SERIAL PRIMARY KEY,
-		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-		token VARCHAR(255) NOT NULL UNIQUE,
-		expires_at TIMESTAMP NOT NULL,
-		used BOOLEAN DEFAULT FALSE,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_reset_tokens_token ON password_reset_tokens(token);
-	CREATE INDEX IF NOT EXISTS idx_reset_tokens_user_id ON password_reset_tokens(user_id);
-	CREATE INDEX IF NOT EXISTS idx_reset_tokens_expires ON password_reset_tokens(expires_at);
-	`
-
-	if _, err := pg.db.Exec(ctx, resetTokensTable); err != nil {
-		return fmt.Errorf("failed to create password_reset_tokens table: %w", err)
-	}
-	log.Println(" Password reset tokens table ready")
-
-	// Sessions table
-	sessionsTable := `
-	CREATE TABLE IF NOT EXISTS sessions (
-		id VARCHAR(128) PRIMARY KEY,
-		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-		data TEXT,
-		expires_at TIMESTAMP NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
-	CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at);
-	`
-
-	if _, err := pg.db.Exec(ctx, sessionsTable); err != nil {
-		return fmt.Errorf("failed to create sessions table: %w", err)
-	}
-	log.Println(" Sessions table ready")
-
-	// Audit log table
-	auditLogTable := `
-	CREATE TABLE IF NOT EXISTS audit_log (
-		id SERIAL PRIMARY KEY,
-		user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
-		action VARCHAR(50) NOT NULL,
-		ip_address VARCHAR(45),
-		user_agent TEXT,
-		success BOOLEAN,
-		failure_reason VARCHAR(255),
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_audit_log_user_id ON audit_log(user_id);
-	CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at);
-	CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action);
-	`
-
-	if _, err := pg.db.Exec(ctx, auditLogTable); err != nil {
-		return fmt.Errorf("failed to create audit_log table: %w", err)
-	}
-	log.Println(" Audit log table ready")
-
-	log.Println("All tables created successfully")
-	return nil
-}
-
 // here we will create the user. Note: the user will have the attributed from the methods
 func (pg *Postgres) CreateUser(ctx context.Context, email, passwordHash, firstName, lastName, role, provider, providerID string) (*User, error) {
 	query := `
@@ -223,28 +125,42 @@ func (pg *Postgres) CreateUser(ctx context.Context, email, passwordHash, firstNa
 	return &user, nil
 }
 
-// here i will refer to the users file for models
-// file has the models
-func (pg *Postgres) GetUserByEmail(ctx context.Context, email string) (*User, error) {
-	query := `
-		SELECT id, email, password_hash, first_name, last_name, provider, provider_id, email_verified, created_at, updated_at
-		FROM users
-		WHERE email = $1
-	`
+// userColumns and userFields are the one place a whole user is read, GetUserByEmail, GetUserByID and
+// GetUserByProviderID all use them. a column added to User goes in both, in the same position
+const userColumns = `id, email, password_hash, first_name, last_name, COALESCE(role, 'student'), provider, provider_id, email_verified, created_at, updated_at, suspended_at, COALESCE(password_reset_required, false),
+	preferred_language, timezone, native_language, target_interview_date, avatar_url, deletion_scheduled_for`
 
-	var user User
-	err := pg.db.QueryRow(ctx, query, email).Scan(
+func userFields(user *User) []interface{} {
+	return []interface{}{
 		&user.ID,
 		&user.Email,
 		&user.PasswordHash,
 		&user.FirstName,
 		&user.LastName,
+		&user.Role,
 		&user.Provider,
 		&user.ProviderID,
 		&user.EmailVerified,
 		&user.CreatedAt,
 		&user.UpdatedAt,
-	)
+		&user.SuspendedAt,
+		&user.PasswordResetRequired,
+		&user.PreferredLanguage,
+		&user.Timezone,
+		&user.NativeLanguage,
+		&user.TargetInterviewDate,
+		&user.AvatarURL,
+		&user.DeletionScheduledFor,
+	}
+}
+
+// here i will refer to the users file for models
+// file has the models
+func (pg *Postgres) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = $1`
+
+	var user User
+	err := pg.db.QueryRow(ctx, query, email).Scan(userFields(&user)...)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -258,25 +174,10 @@ func (pg *Postgres) GetUserByEmail(ctx context.Context, email string) (*User, er
 
 // GetUserByID retrieves a user by ID
 func (pg *Postgres) GetUserByID(ctx context.Context, userID int) (*User, error) {
-	query := `
-		SELECT id, email, password_hash, first_name, last_name, provider, provider_id, email_verified, created_at, updated_at
-		FROM users
-		WHERE id = $1
-	`
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
 
 	var user User
-	err := pg.db.QueryRow(ctx, query, userID).Scan(
-		&user.ID,
-		&user.Email,
-		&user.PasswordHash,
-		&user.FirstName,
-		&user.LastName,
-		&user.Provider,
-		&user.ProviderID,
-		&user.EmailVerified,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	err := pg.db.QueryRow(ctx, query, userID).Scan(userFields(&user)...)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -289,26 +190,13 @@ func (pg *Postgres) GetUserByID(ctx context.Context, userID int) (*User, error)
 }
 
 // GetUserByProviderID retrieves a user by OAuth provider and provider ID
+// it looks through user_identities so it finds accounts that linked the provider later too
 func (pg *Postgres) GetUserByProviderID(ctx context.Context, provider, providerID string) (*User, error) {
-	query := `
-		SELECT id, email, password_hash, first_name, last_name, provider, provider_id, email_verified, created_at, updated_at
-		FROM users
-		WHERE provider = $1 AND provider_id = $2
-	`
+	query := `SELECT ` + userColumns + ` FROM users
+		WHERE id = (SELECT user_id FROM user_identities WHERE provider = $1 AND provider_id = $2)`
 
 	var user User
-	err := pg.db.QueryRow(ctx, query, provider, providerID).Scan(
-		&user.ID,
-		&user.Email,
-		&user.PasswordHash,
-		&user.FirstName,
-		&user.LastName,
-		&user.Provider,
-		&user.ProviderID,
-		&user.EmailVerified,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	err := pg.db.QueryRow(ctx, query, provider, providerID).Scan(userFields(&user)...)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -324,7 +212,7 @@ func (pg *Postgres) GetUserByProviderID(ctx context.Context, provider, providerI
 func (pg *Postgres) UpdatePassword(ctx context.Context, email, newPasswordHash string) error {
 	query := `
 		UPDATE users
-		SET password_hash = $1, updated_at = CURRENT_TIMESTAMP
+		SET password_hash = $1, password_reset_required = false, updated_at = CURRENT_TIMESTAMP
 		WHERE email = $2
 	`
 
@@ -341,15 +229,37 @@ func (pg *Postgres) UpdatePassword(ctx context.Context, email, newPasswordHash s
 	return nil
 }
 
-// UpdateUser updates user information
-func (pg *Postgres) UpdateUser(ctx context.Context, userID int, firstName, lastName string) error {
-	query := `
+// UpdateUser saves the profile fields that are set in update
+func (pg *Postgres) UpdateUser(ctx context.Context, userID int, update ProfileUpdate) error {
+	var sets []string
+	var args []interface{}
+	set := func(column string, value *string) {
+		if value == nil {
+			return
+		}
+		args = append(args, *value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+
+	set("first_name", update.FirstName)
+	set("last_name", update.LastName)
+	set("preferred_language", update.PreferredLanguage)
+	set("timezone", update.Timezone)
+	set("native_language", update.NativeLanguage)
+	set("avatar_url", update.AvatarURL)
+	if update.TargetInterviewDate != nil {
+		args = append(args, *update.TargetInterviewDate)
+		sets = append(sets, fmt.Sprintf("target_interview_date = NULLIF($%d, '')::date", len(args)))
+	}
+
+	args = append(args, userID)
+	query := fmt.Sprintf(`
 		UPDATE users
-		SET first_name = $1, last_name = $2, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $3
-	`
+		SET %s
+		WHERE id = $%d
+	`, strings.Join(append(sets, "updated_at = CURRENT_TIMESTAMP"), ", "), len(args))
 
-	result, err := pg.db.Exec(ctx, query, firstName, lastName, userID)
+	result, err := pg.db.Exec(ctx, query, args...)
 	if err != nil {
 		return fmt.Errorf("unable to update user: %w", err)
 	}
@@ -383,6 +293,27 @@ func (pg *Postgres) VerifyEmail(ctx context.Context, userID int) error {
 	return nil
 }
 
+// UpdateUserRole changes a user's role, the caller checks the actor is allowed to
+func (pg *Postgres) UpdateUserRole(ctx context.Context, userID int, role string) error {
+	query := `
+		UPDATE users
+		SET role = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`
+
+	result, err := pg.db.Exec(ctx, query, role, userID)
+	if err != nil {
+		return fmt.Errorf("unable to update role: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	log.Printf(" Role changed to %s for user ID: %d", role, userID)
+	return nil
+}
+
 // DeleteUser deletes a user by ID
 func (pg *Postgres) DeleteUser(ctx context.Context, userID int) error {
 	query := `DELETE FROM users WHERE id = $1`
@@ -400,16 +331,161 @@ func (pg *Postgres) DeleteUser(ctx context.Context, userID int) error {
 	return nil
 }
 
-// ListUsers retrieves all users with pagination
-func (pg *Postgres) ListUsers(ctx context.Context, limit, offset int) ([]User, error) {
+// ScheduleUserDeletion marks the account to be deleted at deleteAt, asking twice keeps the first date
+func (pg *Postgres) ScheduleUserDeletion(ctx context.Context, userID int, deleteAt time.Time) (time.Time, error) {
+	query := `
+		UPDATE users
+		SET deletion_scheduled_for = COALESCE(deletion_scheduled_for, $2), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING deletion_scheduled_for
+	`
+
+	var scheduled time.Time
+	err := pg.db.QueryRow(ctx, query, userID, deleteAt).Scan(&scheduled)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, fmt.Errorf("user not found")
+		}
+		return time.Time{}, fmt.Errorf("unable to schedule deletion: %w", err)
+	}
+
+	return scheduled, nil
+}
+
+// CancelUserDeletion keeps the account after all
+func (pg *Postgres) CancelUserDeletion(ctx context.Context, userID int) error {
+	query := `
+		UPDATE users
+		SET deletion_scheduled_for = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND deletion_scheduled_for IS NOT NULL
+	`
+
+	result, err := pg.db.Exec(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("unable to cancel deletion: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("no deletion scheduled")
+	}
+
+	return nil
+}
+
+// ListUsersDueForDeletion returns the ids of accounts whose grace period is over, oldest first
+func (pg *Postgres) ListUsersDueForDeletion(ctx context.Context, limit int) ([]int, error) {
 	query := `
-		SELECT id, email, first_name, last_name, provider, provider_id, email_verified, created_at, updated_at
+		SELECT id
 		FROM users
-		ORDER BY created_at DESC
-		LIMIT $1 OFFSET $2
+		WHERE deletion_scheduled_for <= CURRENT_TIMESTAMP
+		ORDER BY deletion_scheduled_for
+		LIMIT $1
 	`
 
-	rows, err := pg.db.Query(ctx, query, limit, offset)
+	rows, err := pg.db.Query(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list users due for deletion: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("unable to scan user id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users: %w", err)
+	}
+
+	return ids, nil
+}
+
+// UserFilter narrows ListUsers and CountUsers, zero values mean "dont filter on this"
+type UserFilter struct {
+	Search    string // part of the email, first or last name
+	Role      string
+	Provider  string // how they signed up or any provider they linked later
+	Verified  *bool
+	Suspended *bool
+	Sort      string // one of userSortColumns, defaults to created_at
+	Desc      bool
+}
+
+// userSortColumns whitelists what ListUsers can ORDER BY, the sort comes straight from the query string
+var userSortColumns = map[string]string{
+	"created_at": "created_at",
+	"email":      "email",
+	"first_name": "first_name",
+	"last_name":  "last_name",
+	"role":       "role",
+}
+
+// where builds the WHERE clause for the filter, every value goes in as a parameter
+func (f UserFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if f.Search != "" {
+		// escape the LIKE wildcards so searching for "_" doesnt match everyone
+		search := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(f.Search)
+		add("(email ILIKE ? OR first_name ILIKE ? OR last_name ILIKE ? OR first_name || ' ' || last_name ILIKE ?)", "%"+search+"%")
+	}
+	if f.Role != "" {
+		add("role = ?", f.Role)
+	}
+	if f.Provider != "" {
+		add("(provider = ? OR EXISTS (SELECT 1 FROM user_identities i WHERE i.user_id = users.id AND i.provider = ?))", f.Provider)
+	}
+	if f.Verified != nil {
+		add("email_verified = ?", *f.Verified)
+	}
+	if f.Suspended != nil {
+		if *f.Suspended {
+			conditions = append(conditions, "suspended_at IS NOT NULL")
+		} else {
+			conditions = append(conditions, "suspended_at IS NULL")
+		}
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// ListUsers retrieves the users matching filter with pagination
+func (pg *Postgres) ListUsers(ctx context.Context, filter UserFilter, limit, offset int) ([]User, error) {
+	where, args := filter.where()
+
+	sortColumn, ok := userSortColumns[filter.Sort]
+	if !ok {
+		sortColumn = "created_at"
+	}
+	direction := "ASC"
+	if filter.Desc {
+		direction = "DESC"
+	}
+
+	// id breaks ties so paging through users with the same name never skips or repeats anyone
+	query := fmt.Sprintf(`
+		SELECT id, email, first_name, last_name, COALESCE(role, 'student'), provider, provider_id, email_verified, created_at, updated_at, suspended_at, COALESCE(password_reset_required, false),
+		       preferred_language, timezone, native_language, target_interview_date, avatar_url, deletion_scheduled_for
+		FROM users
+		%s
+		ORDER BY %s %s, id %s
+		LIMIT $%d OFFSET $%d
+	`, where, sortColumn, direction, direction, len(args)+1, len(args)+2)
+	args = append(args, limit, offset)
+
+	rows, err := pg.db.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to list users: %w", err)
 	}
@@ -423,11 +499,20 @@ func (pg *Postgres) ListUsers(ctx context.Context, limit, offset int) ([]User, e
 			&user.Email,
 			&user.FirstName,
 			&user.LastName,
+			&user.Role,
 			&user.Provider,
 			&user.ProviderID,
 			&user.EmailVerified,
 			&user.CreatedAt,
 			&user.UpdatedAt,
+			&user.SuspendedAt,
+			&user.PasswordResetRequired,
+			&user.PreferredLanguage,
+			&user.Timezone,
+			&user.NativeLanguage,
+			&user.TargetInterviewDate,
+			&user.AvatarURL,
+			&user.DeletionScheduledFor,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("unable to scan user: %w", err)
@@ -442,12 +527,13 @@ func (pg *Postgres) ListUsers(ctx context.Context, limit, offset int) ([]User, e
 	return users, nil
 }
 
-// CountUsers returns the total number of users
-func (pg *Postgres) CountUsers(ctx context.Context) (int, error) {
-	query := `SELECT COUNT(*) FROM users`
+// CountUsers returns how many users match filter
+func (pg *Postgres) CountUsers(ctx context.Context, filter UserFilter) (int, error) {
+	where, args := filter.where()
+	query := `SELECT COUNT(*) FROM users ` + where
 
 	var count int
-	err := pg.db.QueryRow(ctx, query).Scan(&count)
+	err := pg.db.QueryRow(ctx, query, args...).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("unable to count users: %w", err)
 	}
@@ -455,6 +541,59 @@ func (pg *Postgres) CountUsers(ctx context.Context) (int, error) {
 	return count, nil
 }
 
+// who suspended an account, users.suspended_by
+const (
+	SuspendedByAdmin = "admin"
+	SuspendedBySCIM  = "scim"
+)
+
+// SetUserSuspended suspends (or lifts the suspension of) a user, by is SuspendedByAdmin or SuspendedBySCIM.
+// an admin suspending an account SCIM already suspended takes the suspension over, SCIM never takes over an admin's
+func (pg *Postgres) SetUserSuspended(ctx context.Context, userID int, suspended bool, by string) error {
+	query := `
+		UPDATE users
+		SET suspended_at = CASE WHEN $2 THEN COALESCE(suspended_at, CURRENT_TIMESTAMP) ELSE NULL END,
+			suspended_by = CASE
+				WHEN NOT $2 THEN NULL
+				WHEN suspended_at IS NULL OR $3::text = 'admin' THEN $3::text
+				ELSE suspended_by
+			END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`
+
+	result, err := pg.db.Exec(ctx, query, userID, suspended, by)
+	if err != nil {
+		return fmt.Errorf("unable to update suspension: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	return nil
+}
+
+// SetPasswordResetRequired makes the user (or stops making them) pick a new password before they can log in
+func (pg *Postgres) SetPasswordResetRequired(ctx context.Context, userID int, required bool) error {
+	query := `
+		UPDATE users
+		SET password_reset_required = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`
+
+	result, err := pg.db.Exec(ctx, query, userID, required)
+	if err != nil {
+		return fmt.Errorf("unable to update password reset flag: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	return nil
+}
+
 // BulkInsertUsers inserts multiple users using batch operations
 // Note: this is  for 100s-1000s o
 func (pg *Postgres) BulkInsertUsers(ctx context.Context, users []User) error {
@@ -589,113 +728,2715 @@ func (pg *Postgres) DeleteExpiredPasswordResetTokens(ctx context.Context) error
 }
 
 // ============================================
-// AUDIT LOG OPERATIONS
+// EMAIL VERIFICATION TOKEN OPERATIONS
 // ============================================
 
-// CreateAuditLog creates a new audit log entry
-func (pg *Postgres) CreateAuditLog(ctx context.Context, userID *int, action, ipAddress, userAgent string, success bool, failureReason string) error {
+// CreateEmailVerificationToken stores the hash of a new email verification token
+func (pg *Postgres) CreateEmailVerificationToken(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error {
 	query := `
-		INSERT INTO audit_log (user_id, action, ip_address, user_agent, success, failure_reason)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO email_verification_tokens (user_id, token_hash, expires_at, used)
+		VALUES ($1, $2, $3, false)
 	`
 
-	_, err := pg.db.Exec(ctx, query, userID, action, ipAddress, userAgent, success, failureReason)
+	_, err := pg.db.Exec(ctx, query, userID, tokenHash, expiresAt)
 	if err != nil {
-		return fmt.Errorf("unable to create audit log: %w", err)
+		return fmt.Errorf("unable to create email verification token: %w", err)
 	}
 
+	log.Printf(" Created email verification token for user ID: %d", userID)
 	return nil
 }
 
-// GetAuditLogsByUser retrieves audit logs for a specific user
-func (pg *Postgres) GetAuditLogsByUser(ctx context.Context, userID int, limit int) ([]AuditLog, error) {
+// GetEmailVerificationToken retrieves an email verification token by its hash
+func (pg *Postgres) GetEmailVerificationToken(ctx context.Context, tokenHash string) (int, time.Time, bool, error) {
 	query := `
-		SELECT id, user_id, action, ip_address, user_agent, success, failure_reason, created_at
-		FROM audit_log
-		WHERE user_id = $1
-		ORDER BY created_at DESC
-		LIMIT $2
+		SELECT user_id, expires_at, used
+		FROM email_verification_tokens
+		WHERE token_hash = $1
 	`
 
-	rows, err := pg.db.Query(ctx, query, userID, limit)
-	if err != nil {
-		return nil, fmt.Errorf("unable to get audit logs: %w", err)
-	}
-	defer rows.Close()
+	var userID int
+	var expiresAt time.Time
+	var used bool
 
-	var logs []AuditLog
-	for rows.Next() {
-		var log AuditLog
-		err := rows.Scan(
-			&log.ID,
-			&log.UserID,
-			&log.Action,
-			&log.IPAddress,
-			&log.UserAgent,
-			&log.Success,
-			&log.FailureReason,
-			&log.CreatedAt,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("unable to scan audit log: %w", err)
+	err := pg.db.QueryRow(ctx, query, tokenHash).Scan(&userID, &expiresAt, &used)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, time.Time{}, false, fmt.Errorf("token not found")
 		}
-		logs = append(logs, log)
+		return 0, time.Time{}, false, fmt.Errorf("unable to get token: %w", err)
 	}
 
-	return logs, nil
+	return userID, expiresAt, used, nil
 }
 
-// here we allocate how long the session would be
-func (pg *Postgres) CreateSession(ctx context.Context, sessionID string, userID int, data string, expiresAt time.Time) error {
+// MarkEmailVerificationTokenAsUsed marks a token as used
+// the used = false check makes sure two requests with the same token cant both win
+func (pg *Postgres) MarkEmailVerificationTokenAsUsed(ctx context.Context, tokenHash string) error {
 	query := `
-		INSERT INTO sessions (id, user_id, data, expires_at)
-		VALUES ($1, $2, $3, $4)
-		ON CONFLICT (id) DO UPDATE SET data = $3, expires_at = $4
+		UPDATE email_verification_tokens
+		SET used = true
+		WHERE token_hash = $1 AND used = false
 	`
 
-	_, err := pg.db.Exec(ctx, query, sessionID, userID, data, expiresAt)
+	result, err := pg.db.Exec(ctx, query, tokenHash)
 	if err != nil {
-		return fmt.Errorf("unable to create session: %w", err)
+		return fmt.Errorf("unable to mark token as used: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("token not found")
 	}
 
 	return nil
 }
 
-// GetSession retrieves a session by ID
-func (pg *Postgres) GetSession(ctx context.Context, sessionID string) (int, string, time.Time, error) {
+// InvalidateEmailVerificationTokens burns every unused token for a user, used before we send a fresh one
+func (pg *Postgres) InvalidateEmailVerificationTokens(ctx context.Context, userID int) error {
 	query := `
-		SELECT user_id, data, expires_at
-		FROM sessions
-		WHERE id = $1 AND expires_at > CURRENT_TIMESTAMP
+		UPDATE email_verification_tokens
+		SET used = true
+		WHERE user_id = $1 AND used = false
 	`
 
-	var userID int
-	var data string
-	var expiresAt time.Time
-
-	err := pg.db.QueryRow(ctx, query, sessionID).Scan(&userID, &data, &expiresAt)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return 0, "", time.Time{}, fmt.Errorf("session not found or expired")
-		}
-		return 0, "", time.Time{}, fmt.Errorf("unable to get session: %w", err)
+	if _, err := pg.db.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("unable to invalidate verification tokens: %w", err)
 	}
 
-	return userID, data, expiresAt, nil
+	return nil
 }
 
-// DeleteSession deletes a session
-func (pg *Postgres) DeleteSession(ctx context.Context, sessionID string) error {
-	query := `DELETE FROM sessions WHERE id = $1`
+// DeleteExpiredEmailVerificationTokens deletes expired tokens (cleanup)
+func (pg *Postgres) DeleteExpiredEmailVerificationTokens(ctx context.Context) error {
+	query := `
+		DELETE FROM email_verification_tokens
+		WHERE expires_at < CURRENT_TIMESTAMP OR used = true
+	`
 
-	_, err := pg.db.Exec(ctx, query, sessionID)
+	result, err := pg.db.Exec(ctx, query)
 	if err != nil {
-		return fmt.Errorf("unable to delete session: %w", err)
+		return fmt.Errorf("unable to delete expired tokens: %w", err)
+	}
+
+	log.Printf(" Deleted %d expired/used email verification tokens", result.RowsAffected())
+	return nil
+}
+
+// ============================================
+// MAGIC LINK TOKEN OPERATIONS
+// ============================================
+
+// CreateMagicLinkToken stores the hash of a new sign in link token
+func (pg *Postgres) CreateMagicLinkToken(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO magic_link_tokens (user_id, token_hash, expires_at, used)
+		VALUES ($1, $2, $3, false)
+	`
+
+	_, err := pg.db.Exec(ctx, query, userID, tokenHash, expiresAt)
+	if err != nil {
+		return fmt.Errorf("unable to create magic link token: %w", err)
+	}
+
+	log.Printf(" Created magic link token for user ID: %d", userID)
+	return nil
+}
+
+// GetMagicLinkToken retrieves a sign in link token by its hash
+func (pg *Postgres) GetMagicLinkToken(ctx context.Context, tokenHash string) (int, time.Time, bool, error) {
+	query := `
+		SELECT user_id, expires_at, used
+		FROM magic_link_tokens
+		WHERE token_hash = $1
+	`
+
+	var userID int
+	var expiresAt time.Time
+	var used bool
+
+	err := pg.db.QueryRow(ctx, query, tokenHash).Scan(&userID, &expiresAt, &used)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, time.Time{}, false, fmt.Errorf("token not found")
+		}
+		return 0, time.Time{}, false, fmt.Errorf("unable to get token: %w", err)
+	}
+
+	return userID, expiresAt, used, nil
+}
+
+// MarkMagicLinkTokenAsUsed burns a sign in link token
+// the used = false check makes sure two requests with the same link cant both log in
+func (pg *Postgres) MarkMagicLinkTokenAsUsed(ctx context.Context, tokenHash string) error {
+	query := `
+		UPDATE magic_link_tokens
+		SET used = true
+		WHERE token_hash = $1 AND used = false
+	`
+
+	result, err := pg.db.Exec(ctx, query, tokenHash)
+	if err != nil {
+		return fmt.Errorf("unable to mark token as used: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("token not found")
+	}
+
+	return nil
+}
+
+// InvalidateMagicLinkTokens burns every unused link for a user, so only the newest email works
+func (pg *Postgres) InvalidateMagicLinkTokens(ctx context.Context, userID int) error {
+	query := `
+		UPDATE magic_link_tokens
+		SET used = true
+		WHERE user_id = $1 AND used = false
+	`
+
+	if _, err := pg.db.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("unable to invalidate magic link tokens: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteExpiredMagicLinkTokens deletes expired tokens (cleanup)
+func (pg *Postgres) DeleteExpiredMagicLinkTokens(ctx context.Context) error {
+	query := `
+		DELETE FROM magic_link_tokens
+		WHERE expires_at < CURRENT_TIMESTAMP OR used = true
+	`
+
+	result, err := pg.db.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("unable to delete expired tokens: %w", err)
+	}
+
+	log.Printf(" Deleted %d expired/used magic link tokens", result.RowsAffected())
+	return nil
+}
+
+// ============================================
+// TWO FACTOR (TOTP) OPERATIONS
+// ============================================
+
+// SaveMFASecret stores a new TOTP secret for a user that is enrolling
+// it refuses to overwrite the secret once two factor is enabled
+func (pg *Postgres) SaveMFASecret(ctx context.Context, userID int, secret string) error {
+	query := `
+		INSERT INTO user_mfa (user_id, totp_secret, enabled)
+		VALUES ($1, $2, false)
+		ON CONFLICT (user_id) DO UPDATE SET totp_secret = $2, created_at = CURRENT_TIMESTAMP
+		WHERE user_mfa.enabled = false
+	`
+
+	result, err := pg.db.Exec(ctx, query, userID, secret)
+	if err != nil {
+		return fmt.Errorf("unable to save mfa secret: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("two factor is already enabled")
+	}
+
+	return nil
+}
+
+// GetMFA returns the two factor settings for a user
+func (pg *Postgres) GetMFA(ctx context.Context, userID int) (*MFASettings, error) {
+	query := `
+		SELECT user_id, totp_secret, enabled, last_used_step, confirmed_at
+		FROM user_mfa
+		WHERE user_id = $1
+	`
+
+	var mfa MFASettings
+	err := pg.db.QueryRow(ctx, query, userID).Scan(
+		&mfa.UserID,
+		&mfa.Secret,
+		&mfa.Enabled,
+		&mfa.LastUsedStep,
+		&mfa.ConfirmedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("mfa not found")
+		}
+		return nil, fmt.Errorf("unable to get mfa: %w", err)
+	}
+
+	return &mfa, nil
+}
+
+// EnableMFA turns two factor on and replaces the recovery codes in one transaction
+func (pg *Postgres) EnableMFA(ctx context.Context, userID int, step int64, recoveryCodeHashes []string) error {
+	return pg.WithTransaction(ctx, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			UPDATE user_mfa
+			SET enabled = true, confirmed_at = CURRENT_TIMESTAMP, last_used_step = $2
+			WHERE user_id = $1 AND enabled = false
+		`, userID, step)
+		if err != nil {
+			return fmt.Errorf("unable to enable mfa: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return fmt.Errorf("mfa not found")
+		}
+
+		return replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes)
+	})
+}
+
+// ReplaceRecoveryCodes throws away the old recovery codes and stores new ones
+func (pg *Postgres) ReplaceRecoveryCodes(ctx context.Context, userID int, recoveryCodeHashes []string) error {
+	return pg.WithTransaction(ctx, func(tx pgx.Tx) error {
+		return replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes)
+	})
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID int, recoveryCodeHashes []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("unable to delete recovery codes: %w", err)
+	}
+	for _, codeHash := range recoveryCodeHashes {
+		if _, err := tx.Exec(ctx, `INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, codeHash); err != nil {
+			return fmt.Errorf("unable to save recovery code: %w", err)
+		}
+	}
+	return nil
+}
+
+// UseMFAStep records the time step of an accepted code
+// returns false if that code (or a newer one) was already used, so a code can't be replayed
+func (pg *Postgres) UseMFAStep(ctx context.Context, userID int, step int64) (bool, error) {
+	query := `
+		UPDATE user_mfa
+		SET last_used_step = $2
+		WHERE user_id = $1 AND last_used_step < $2
+	`
+
+	result, err := pg.db.Exec(ctx, query, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("unable to update mfa step: %w", err)
+	}
+
+	return result.RowsAffected() == 1, nil
+}
+
+// UseRecoveryCode burns a recovery code, returns false if it doesn't exist or was already used
+func (pg *Postgres) UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	query := `
+		UPDATE mfa_recovery_codes
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+
+	result, err := pg.db.Exec(ctx, query, userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("unable to use recovery code: %w", err)
+	}
+
+	return result.RowsAffected() == 1, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func (pg *Postgres) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	query := `SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+
+	var count int
+	if err := pg.db.QueryRow(ctx, query, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("unable to count recovery codes: %w", err)
+	}
+
+	return count, nil
+}
+
+// RecordFailedMFA bumps the user's count of wrong codes at the second login step and returns the new count
+func (pg *Postgres) RecordFailedMFA(ctx context.Context, userID int) (int, error) {
+	query := `
+		UPDATE user_mfa
+		SET failed_attempts = failed_attempts + 1
+		WHERE user_id = $1
+		RETURNING failed_attempts
+	`
+
+	var attempts int
+	if err := pg.db.QueryRow(ctx, query, userID).Scan(&attempts); err != nil {
+		return 0, fmt.Errorf("unable to record failed mfa: %w", err)
+	}
+
+	return attempts, nil
+}
+
+// ResetFailedMFA clears the wrong code count, after a good code or once the account got locked for them
+func (pg *Postgres) ResetFailedMFA(ctx context.Context, userID int) error {
+	if _, err := pg.db.Exec(ctx, `UPDATE user_mfa SET failed_attempts = 0 WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("unable to reset failed mfa: %w", err)
+	}
+
+	return nil
+}
+
+// DisableMFA removes the secret and every recovery code
+func (pg *Postgres) DisableMFA(ctx context.Context, userID int) error {
+	return pg.WithTransaction(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return fmt.Errorf("unable to delete recovery codes: %w", err)
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM user_mfa WHERE user_id = $1`, userID); err != nil {
+			return fmt.Errorf("unable to disable mfa: %w", err)
+		}
+		return nil
+	})
+}
+
+// ============================================
+// PASSKEY (WEBAUTHN) OPERATIONS
+// ============================================
+
+// CreateWebAuthnCredential saves a passkey after a successful registration ceremony
+func (pg *Postgres) CreateWebAuthnCredential(ctx context.Context, cred *WebAuthnCredential) error {
+	query := `
+		INSERT INTO webauthn_credentials
+			(user_id, credential_id, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state, name)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at
+	`
+
+	err := pg.db.QueryRow(ctx, query,
+		cred.UserID,
+		cred.CredentialID,
+		cred.PublicKey,
+		cred.AttestationType,
+		cred.AAGUID,
+		cred.SignCount,
+		cred.Transports,
+		cred.BackupEligible,
+		cred.BackupState,
+		cred.Name,
+	).Scan(&cred.ID, &cred.CreatedAt)
+
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return fmt.Errorf("passkey already registered")
+		}
+		return fmt.Errorf("unable to create passkey: %w", err)
+	}
+
+	log.Printf(" Created passkey for user ID: %d", cred.UserID)
+	return nil
+}
+
+// GetWebAuthnCredentialsByUser returns every passkey a user registered
+func (pg *Postgres) GetWebAuthnCredentialsByUser(ctx context.Context, userID int) ([]WebAuthnCredential, error) {
+	query := `
+		SELECT id, user_id, credential_id, public_key, COALESCE(attestation_type, ''), aaguid, sign_count,
+			COALESCE(transports, '{}'), backup_eligible, backup_state, COALESCE(name, ''), created_at, last_used_at
+		FROM webauthn_credentials
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+
+	rows, err := pg.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get passkeys: %w", err)
+	}
+	defer rows.Close()
+
+	var creds []WebAuthnCredential
+	for rows.Next() {
+		var cred WebAuthnCredential
+		err := rows.Scan(
+			&cred.ID,
+			&cred.UserID,
+			&cred.CredentialID,
+			&cred.PublicKey,
+			&cred.AttestationType,
+			&cred.AAGUID,
+			&cred.SignCount,
+			&cred.Transports,
+			&cred.BackupEligible,
+			&cred.BackupState,
+			&cred.Name,
+			&cred.CreatedAt,
+			&cred.LastUsedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan passkey: %w", err)
+		}
+		creds = append(creds, cred)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating passkeys: %w", err)
+	}
+
+	return creds, nil
+}
+
+// UpdateWebAuthnCredentialUsage stores the new signature counter after a login
+func (pg *Postgres) UpdateWebAuthnCredentialUsage(ctx context.Context, credentialID []byte, signCount int64, backupState bool) error {
+	query := `
+		UPDATE webauthn_credentials
+		SET sign_count = $2, backup_state = $3, last_used_at = CURRENT_TIMESTAMP
+		WHERE credential_id = $1
+	`
+
+	result, err := pg.db.Exec(ctx, query, credentialID, signCount, backupState)
+	if err != nil {
+		return fmt.Errorf("unable to update passkey: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("passkey not found")
+	}
+
+	return nil
+}
+
+// DeleteWebAuthnCredential removes one of the user's passkeys
+func (pg *Postgres) DeleteWebAuthnCredential(ctx context.Context, userID, id int) error {
+	query := `DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2`
+
+	result, err := pg.db.Exec(ctx, query, id, userID)
+	if err != nil {
+		return fmt.Errorf("unable to delete passkey: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("passkey not found")
+	}
+
+	return nil
+}
+
+// CreateWebAuthnChallenge stores a ceremony's session data until the finish step, keyed by the hash of the cookie's handle
+func (pg *Postgres) CreateWebAuthnChallenge(ctx context.Context, handleHash, kind, sessionData string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO webauthn_challenges (handle_hash, kind, session_data, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	if _, err := pg.db.Exec(ctx, query, handleHash, kind, sessionData, expiresAt); err != nil {
+		return fmt.Errorf("unable to create webauthn challenge: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeWebAuthnChallenge deletes the challenge and returns its session data, so every challenge can only be answered once
+func (pg *Postgres) ConsumeWebAuthnChallenge(ctx context.Context, handleHash, kind string) (string, error) {
+	query := `
+		DELETE FROM webauthn_challenges
+		WHERE handle_hash = $1 AND kind = $2 AND expires_at > $3
+		RETURNING session_data
+	`
+
+	var sessionData string
+	err := pg.db.QueryRow(ctx, query, handleHash, kind, time.Now().UTC()).Scan(&sessionData)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", fmt.Errorf("webauthn challenge not found")
+		}
+		return "", fmt.Errorf("unable to use webauthn challenge: %w", err)
+	}
+
+	return sessionData, nil
+}
+
+// DeleteExpiredWebAuthnChallenges deletes ceremonies nobody finished (cleanup)
+func (pg *Postgres) DeleteExpiredWebAuthnChallenges(ctx context.Context) error {
+	result, err := pg.db.Exec(ctx, `DELETE FROM webauthn_challenges WHERE expires_at < $1`, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("unable to delete expired webauthn challenges: %w", err)
+	}
+
+	log.Printf(" Deleted %d expired webauthn challenges", result.RowsAffected())
+	return nil
+}
+
+// ============================================
+// LINKED IDENTITY OPERATIONS
+// ============================================
+
+// CreateUserIdentity links an OAuth login to a user
+func (pg *Postgres) CreateUserIdentity(ctx context.Context, userID int, provider, providerID, email string) error {
+	query := `
+		INSERT INTO user_identities (user_id, provider, provider_id, email)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	_, err := pg.db.Exec(ctx, query, userID, provider, providerID, email)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return fmt.Errorf("identity already linked")
+		}
+		return fmt.Errorf("unable to create identity: %w", err)
+	}
+
+	log.Printf("✅ Linked %s identity to user ID: %d", provider, userID)
+	return nil
+}
+
+// ListUserIdentities returns every OAuth login linked to a user
+func (pg *Postgres) ListUserIdentities(ctx context.Context, userID int) ([]UserIdentity, error) {
+	query := `
+		SELECT id, user_id, provider, provider_id, COALESCE(email, ''), created_at, last_used_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+
+	rows, err := pg.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get identities: %w", err)
+	}
+	defer rows.Close()
+
+	var identities []UserIdentity
+	for rows.Next() {
+		var identity UserIdentity
+		err := rows.Scan(
+			&identity.ID,
+			&identity.UserID,
+			&identity.Provider,
+			&identity.ProviderID,
+			&identity.Email,
+			&identity.CreatedAt,
+			&identity.LastUsedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan identity: %w", err)
+		}
+		identities = append(identities, identity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating identities: %w", err)
+	}
+
+	return identities, nil
+}
+
+// TouchUserIdentity records that the identity was just used to log in
+func (pg *Postgres) TouchUserIdentity(ctx context.Context, provider, providerID string) error {
+	query := `
+		UPDATE user_identities
+		SET last_used_at = CURRENT_TIMESTAMP
+		WHERE provider = $1 AND provider_id = $2
+	`
+
+	if _, err := pg.db.Exec(ctx, query, provider, providerID); err != nil {
+		return fmt.Errorf("unable to update identity: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteUserIdentity unlinks one provider from a user
+func (pg *Postgres) DeleteUserIdentity(ctx context.Context, userID int, provider string) error {
+	query := `DELETE FROM user_identities WHERE user_id = $1 AND provider = $2`
+
+	result, err := pg.db.Exec(ctx, query, userID, provider)
+	if err != nil {
+		return fmt.Errorf("unable to delete identity: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("identity not found")
+	}
+
+	return nil
+}
+
+// ============================================
+// USER DEVICE OPERATIONS
+// ============================================
+
+// ListUserDevices returns the devices a user has logged in from, most recently used first
+func (pg *Postgres) ListUserDevices(ctx context.Context, userID int) ([]UserDevice, error) {
+	query := `
+		SELECT id, user_id, device_hash, user_agent, last_ip, latitude, longitude, location, first_seen_at, last_seen_at
+		FROM user_devices
+		WHERE user_id = $1
+		ORDER BY last_seen_at DESC
+	`
+
+	rows, err := pg.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list devices: %w", err)
+	}
+	defer rows.Close()
+
+	var devices []UserDevice
+	for rows.Next() {
+		var device UserDevice
+		err := rows.Scan(
+			&device.ID,
+			&device.UserID,
+			&device.DeviceHash,
+			&device.UserAgent,
+			&device.LastIP,
+			&device.Latitude,
+			&device.Longitude,
+			&device.Location,
+			&device.FirstSeenAt,
+			&device.LastSeenAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan device: %w", err)
+		}
+		devices = append(devices, device)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating devices: %w", err)
+	}
+
+	return devices, nil
+}
+
+// UpsertUserDevice records a successful login from a device, adding the device the first time it's seen
+func (pg *Postgres) UpsertUserDevice(ctx context.Context, device *UserDevice) error {
+	query := `
+		INSERT INTO user_devices (user_id, device_hash, user_agent, last_ip, latitude, longitude, location, first_seen_at, last_seen_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+		ON CONFLICT (user_id, device_hash) DO UPDATE
+		SET user_agent = EXCLUDED.user_agent,
+		    last_ip = EXCLUDED.last_ip,
+		    latitude = EXCLUDED.latitude,
+		    longitude = EXCLUDED.longitude,
+		    location = EXCLUDED.location,
+		    last_seen_at = EXCLUDED.last_seen_at
+	`
+
+	_, err := pg.db.Exec(ctx, query,
+		device.UserID,
+		device.DeviceHash,
+		device.UserAgent,
+		device.LastIP,
+		device.Latitude,
+		device.Longitude,
+		device.Location,
+		device.LastSeenAt,
+	)
+	if err != nil {
+		return fmt.Errorf("unable to save device: %w", err)
+	}
+
+	return nil
+}
+
+// ============================================
+// API TOKEN OPERATIONS
+// ============================================
+
+// apiTokenColumns is the column list every api token query selects, in scanAPIToken's order
+const apiTokenColumns = `id, user_id, name, token_hash, token_prefix, scopes, expires_at, last_used_at, created_at`
+
+func scanAPIToken(row pgx.Row) (*APIToken, error) {
+	var token APIToken
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Name,
+		&token.TokenHash,
+		&token.TokenPrefix,
+		&token.Scopes,
+		&token.ExpiresAt,
+		&token.LastUsedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// CreateAPIToken stores a new personal access token, only its hash is saved
+func (pg *Postgres) CreateAPIToken(ctx context.Context, token *APIToken) error {
+	query := `
+		INSERT INTO api_tokens (user_id, name, token_hash, token_prefix, scopes, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`
+
+	err := pg.db.QueryRow(ctx, query,
+		token.UserID,
+		token.Name,
+		token.TokenHash,
+		token.TokenPrefix,
+		token.Scopes,
+		token.ExpiresAt,
+		token.CreatedAt,
+	).Scan(&token.ID)
+	if err != nil {
+		return fmt.Errorf("unable to create api token: %w", err)
+	}
+
+	log.Printf(" Created api token %d for user ID: %d", token.ID, token.UserID)
+	return nil
+}
+
+// GetAPITokenByHash finds a token by the hash of what the client sent, expired tokens are returned too
+func (pg *Postgres) GetAPITokenByHash(ctx context.Context, tokenHash string) (*APIToken, error) {
+	query := `SELECT ` + apiTokenColumns + ` FROM api_tokens WHERE token_hash = $1`
+
+	token, err := scanAPIToken(pg.db.QueryRow(ctx, query, tokenHash))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("api token not found")
+		}
+		return nil, fmt.Errorf("unable to get api token: %w", err)
+	}
+
+	return token, nil
+}
+
+// ListAPITokens returns a user's tokens, newest first
+func (pg *Postgres) ListAPITokens(ctx context.Context, userID int) ([]APIToken, error) {
+	query := `SELECT ` + apiTokenColumns + ` FROM api_tokens WHERE user_id = $1 ORDER BY created_at DESC, id DESC`
+
+	rows, err := pg.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list api tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan api token: %w", err)
+		}
+		tokens = append(tokens, *token)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating api tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// CountActiveAPITokens counts a user's tokens that haven't expired yet
+func (pg *Postgres) CountActiveAPITokens(ctx context.Context, userID int) (int, error) {
+	query := `SELECT COUNT(*) FROM api_tokens WHERE user_id = $1 AND expires_at > $2`
+
+	var count int
+	if err := pg.db.QueryRow(ctx, query, userID, time.Now().UTC()).Scan(&count); err != nil {
+		return 0, fmt.Errorf("unable to count api tokens: %w", err)
+	}
+
+	return count, nil
+}
+
+// RevokeAPIToken deletes one of the user's tokens, the user id stops anyone revoking someone else's token
+func (pg *Postgres) RevokeAPIToken(ctx context.Context, userID, tokenID int) error {
+	query := `DELETE FROM api_tokens WHERE id = $1 AND user_id = $2`
+
+	result, err := pg.db.Exec(ctx, query, tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("unable to revoke api token: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("api token not found")
+	}
+
+	return nil
+}
+
+// TouchAPIToken records that a token was used, at most once a minute so busy scripts don't write on every request
+func (pg *Postgres) TouchAPIToken(ctx context.Context, tokenID int, usedAt time.Time) error {
+	query := `
+		UPDATE api_tokens
+		SET last_used_at = $2
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $2 - INTERVAL '1 minute')
+	`
+
+	if _, err := pg.db.Exec(ctx, query, tokenID, usedAt); err != nil {
+		return fmt.Errorf("unable to update api token: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteExpiredAPITokens deletes expired tokens (cleanup)
+func (pg *Postgres) DeleteExpiredAPITokens(ctx context.Context) error {
+	query := `DELETE FROM api_tokens WHERE expires_at < $1`
+
+	result, err := pg.db.Exec(ctx, query, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("unable to delete expired api tokens: %w", err)
+	}
+
+	log.Printf(" Deleted %d expired api tokens", result.RowsAffected())
+	return nil
+}
+
+// ============================================
+// OPENID CONNECT PROVIDER OPERATIONS
+// ============================================
+
+// CreateOAuthClient registers an app that can sign users in with their VirgoAI account
+func (pg *Postgres) CreateOAuthClient(ctx context.Context, client *OAuthClient) error {
+	query := `
+		INSERT INTO oauth_clients (client_id, client_secret_hash, name, redirect_uris, first_party, created_at)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6)
+		RETURNING id
+	`
+
+	err := pg.db.QueryRow(ctx, query,
+		client.ClientID,
+		client.ClientSecretHash,
+		client.Name,
+		client.RedirectURIs,
+		client.FirstParty,
+		client.CreatedAt,
+	).Scan(&client.ID)
+	if err != nil {
+		return fmt.Errorf("unable to create oauth client: %w", err)
+	}
+
+	log.Printf(" Created oauth client %s (%s)", client.ClientID, client.Name)
+	return nil
+}
+
+// GetOAuthClient retrieves a registered app by its client id
+func (pg *Postgres) GetOAuthClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	query := `
+		SELECT id, client_id, COALESCE(client_secret_hash, ''), name, redirect_uris, first_party, created_at
+		FROM oauth_clients
+		WHERE client_id = $1
+	`
+
+	var client OAuthClient
+	err := pg.db.QueryRow(ctx, query, clientID).Scan(
+		&client.ID,
+		&client.ClientID,
+		&client.ClientSecretHash,
+		&client.Name,
+		&client.RedirectURIs,
+		&client.FirstParty,
+		&client.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("oauth client not found")
+		}
+		return nil, fmt.Errorf("unable to get oauth client: %w", err)
+	}
+
+	return &client, nil
+}
+
+// ListOAuthClients returns every registered app, oldest first
+func (pg *Postgres) ListOAuthClients(ctx context.Context) ([]OAuthClient, error) {
+	query := `
+		SELECT id, client_id, COALESCE(client_secret_hash, ''), name, redirect_uris, first_party, created_at
+		FROM oauth_clients
+		ORDER BY id
+	`
+
+	rows, err := pg.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list oauth clients: %w", err)
+	}
+	defer rows.Close()
+
+	var clients []OAuthClient
+	for rows.Next() {
+		var client OAuthClient
+		err := rows.Scan(
+			&client.ID,
+			&client.ClientID,
+			&client.ClientSecretHash,
+			&client.Name,
+			&client.RedirectURIs,
+			&client.FirstParty,
+			&client.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan oauth client: %w", err)
+		}
+		clients = append(clients, client)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating oauth clients: %w", err)
+	}
+
+	return clients, nil
+}
+
+// DeleteOAuthClient removes an app, its consents and unused codes go with it
+func (pg *Postgres) DeleteOAuthClient(ctx context.Context, clientID string) error {
+	result, err := pg.db.Exec(ctx, `DELETE FROM oauth_clients WHERE client_id = $1`, clientID)
+	if err != nil {
+		return fmt.Errorf("unable to delete oauth client: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("oauth client not found")
+	}
+
+	return nil
+}
+
+// GetOAuthConsent returns the scopes a user already granted an app, empty when they never did
+func (pg *Postgres) GetOAuthConsent(ctx context.Context, userID int, clientID string) ([]string, error) {
+	query := `SELECT scopes FROM oauth_consents WHERE user_id = $1 AND client_id = $2`
+
+	var scopes []string
+	err := pg.db.QueryRow(ctx, query, userID, clientID).Scan(&scopes)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to get consent: %w", err)
+	}
+
+	return scopes, nil
+}
+
+// SaveOAuthConsent remembers a user approved scopes for an app, added to whatever they approved before
+func (pg *Postgres) SaveOAuthConsent(ctx context.Context, userID int, clientID string, scopes []string, grantedAt time.Time) error {
+	query := `
+		INSERT INTO oauth_consents (user_id, client_id, scopes, granted_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, client_id) DO UPDATE
+		SET scopes = ARRAY(SELECT DISTINCT unnest(oauth_consents.scopes || EXCLUDED.scopes)),
+		    granted_at = EXCLUDED.granted_at
+	`
+
+	if _, err := pg.db.Exec(ctx, query, userID, clientID, scopes, grantedAt); err != nil {
+		return fmt.Errorf("unable to save consent: %w", err)
+	}
+
+	return nil
+}
+
+// CreateAuthorizationCode stores the hash of a new authorization code
+func (pg *Postgres) CreateAuthorizationCode(ctx context.Context, code *AuthorizationCode) error {
+	query := `
+		INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scopes, nonce, code_challenge, auth_time, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
+	`
+
+	err := pg.db.QueryRow(ctx, query,
+		code.CodeHash,
+		code.ClientID,
+		code.UserID,
+		code.RedirectURI,
+		code.Scopes,
+		code.Nonce,
+		code.CodeChallenge,
+		code.AuthTime,
+		code.ExpiresAt,
+	).Scan(&code.ID)
+	if err != nil {
+		return fmt.Errorf("unable to create authorization code: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeAuthorizationCode burns a code and returns it, the used = false check means a code only ever works once
+func (pg *Postgres) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*AuthorizationCode, error) {
+	query := `
+		UPDATE oauth_authorization_codes
+		SET used = true
+		WHERE code_hash = $1 AND used = false
+		RETURNING id, code_hash, client_id, user_id, redirect_uri, scopes, nonce, code_challenge, auth_time, expires_at, used
+	`
+
+	var code AuthorizationCode
+	err := pg.db.QueryRow(ctx, query, codeHash).Scan(
+		&code.ID,
+		&code.CodeHash,
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		&code.Scopes,
+		&code.Nonce,
+		&code.CodeChallenge,
+		&code.AuthTime,
+		&code.ExpiresAt,
+		&code.Used,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("authorization code not found")
+		}
+		return nil, fmt.Errorf("unable to use authorization code: %w", err)
+	}
+
+	return &code, nil
+}
+
+// DeleteExpiredAuthorizationCodes deletes expired and used codes (cleanup)
+func (pg *Postgres) DeleteExpiredAuthorizationCodes(ctx context.Context) error {
+	query := `DELETE FROM oauth_authorization_codes WHERE expires_at < $1 OR used = true`
+
+	result, err := pg.db.Exec(ctx, query, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("unable to delete expired authorization codes: %w", err)
+	}
+
+	log.Printf(" Deleted %d expired/used authorization codes", result.RowsAffected())
+	return nil
+}
+
+// ListSigningKeys returns the id token signing keys created after since, newest first
+func (pg *Postgres) ListSigningKeys(ctx context.Context, since time.Time) ([]SigningKey, error) {
+	query := `
+		SELECT id, kid, private_key, created_at
+		FROM oauth_signing_keys
+		WHERE created_at > $1
+		ORDER BY created_at DESC, id DESC
+	`
+
+	rows, err := pg.db.Query(ctx, query, since)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list signing keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []SigningKey
+	for rows.Next() {
+		var key SigningKey
+		if err := rows.Scan(&key.ID, &key.KID, &key.PrivateKey, &key.CreatedAt); err != nil {
+			return nil, fmt.Errorf("unable to scan signing key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating signing keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// CreateSigningKey stores a new id token signing key
+func (pg *Postgres) CreateSigningKey(ctx context.Context, key *SigningKey) error {
+	query := `
+		INSERT INTO oauth_signing_keys (kid, private_key, created_at)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`
+
+	if err := pg.db.QueryRow(ctx, query, key.KID, key.PrivateKey, key.CreatedAt).Scan(&key.ID); err != nil {
+		return fmt.Errorf("unable to create signing key: %w", err)
+	}
+
+	log.Printf("🔑 Created signing key %s", key.KID)
+	return nil
+}
+
+// UpdateSigningKey replaces the stored private key, used to encrypt keys stored before encryption
+func (pg *Postgres) UpdateSigningKey(ctx context.Context, kid, privateKey string) error {
+	result, err := pg.db.Exec(ctx, `UPDATE oauth_signing_keys SET private_key = $2 WHERE kid = $1`, kid, privateKey)
+	if err != nil {
+		return fmt.Errorf("unable to update signing key: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("signing key not found")
+	}
+	return nil
+}
+
+// DeleteSigningKeysBefore deletes keys old enough that nothing they signed is still valid (cleanup)
+func (pg *Postgres) DeleteSigningKeysBefore(ctx context.Context, before time.Time) error {
+	result, err := pg.db.Exec(ctx, `DELETE FROM oauth_signing_keys WHERE created_at < $1`, before)
+	if err != nil {
+		return fmt.Errorf("unable to delete old signing keys: %w", err)
+	}
+
+	log.Printf(" Deleted %d old signing keys", result.RowsAffected())
+	return nil
+}
+
+// ============================================
+// ORGANIZATION AND SAML SSO OPERATIONS
+// ============================================
+
+// CreateOrganization adds a school or college that can get its own sso
+func (pg *Postgres) CreateOrganization(ctx context.Context, org *Organization) error {
+	query := `
+		INSERT INTO organizations (slug, name, email_domains, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+
+	// a nil slice would go in as NULL
+	if org.EmailDomains == nil {
+		org.EmailDomains = []string{}
+	}
+	if err := pg.db.QueryRow(ctx, query, org.Slug, org.Name, org.EmailDomains, org.CreatedAt).Scan(&org.ID); err != nil {
+		return fmt.Errorf("unable to create organization: %w", err)
+	}
+
+	log.Printf(" Created organization %s", org.Slug)
+	return nil
+}
+
+// GetOrganizationBySlug retrieves an organization by the slug in its sso urls
+func (pg *Postgres) GetOrganizationBySlug(ctx context.Context, slug string) (*Organization, error) {
+	query := `SELECT id, slug, name, email_domains, created_at FROM organizations WHERE slug = $1`
+
+	var org Organization
+	err := pg.db.QueryRow(ctx, query, slug).Scan(&org.ID, &org.Slug, &org.Name, &org.EmailDomains, &org.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("organization not found")
+		}
+		return nil, fmt.Errorf("unable to get organization: %w", err)
+	}
+
+	return &org, nil
+}
+
+// GetOrganizationByID retrieves an organization by id
+func (pg *Postgres) GetOrganizationByID(ctx context.Context, organizationID int) (*Organization, error) {
+	query := `SELECT id, slug, name, email_domains, created_at FROM organizations WHERE id = $1`
+
+	var org Organization
+	err := pg.db.QueryRow(ctx, query, organizationID).Scan(&org.ID, &org.Slug, &org.Name, &org.EmailDomains, &org.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("organization not found")
+		}
+		return nil, fmt.Errorf("unable to get organization: %w", err)
+	}
+
+	return &org, nil
+}
+
+// ListOrganizations returns every organization, by name
+func (pg *Postgres) ListOrganizations(ctx context.Context) ([]Organization, error) {
+	rows, err := pg.db.Query(ctx, `SELECT id, slug, name, email_domains, created_at FROM organizations ORDER BY name, id`)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list organizations: %w", err)
+	}
+	defer rows.Close()
+
+	var orgs []Organization
+	for rows.Next() {
+		var org Organization
+		if err := rows.Scan(&org.ID, &org.Slug, &org.Name, &org.EmailDomains, &org.CreatedAt); err != nil {
+			return nil, fmt.Errorf("unable to scan organization: %w", err)
+		}
+		orgs = append(orgs, org)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating organizations: %w", err)
+	}
+
+	return orgs, nil
+}
+
+// SetOrganizationEmailDomains replaces the domains an organization can provision accounts in
+func (pg *Postgres) SetOrganizationEmailDomains(ctx context.Context, organizationID int, domains []string) error {
+	result, err := pg.db.Exec(ctx, `UPDATE organizations SET email_domains = $2 WHERE id = $1`, organizationID, domains)
+	if err != nil {
+		return fmt.Errorf("unable to update organization email domains: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("organization not found")
+	}
+	return nil
+}
+
+// SaveSAMLConnection creates or replaces an organization's identity provider settings
+func (pg *Postgres) SaveSAMLConnection(ctx context.Context, conn *SAMLConnection) error {
+	query := `
+		INSERT INTO saml_connections (
+			organization_id, idp_entity_id, idp_metadata, email_domains, default_role,
+			email_attribute, first_name_attribute, last_name_attribute, role_attribute, enabled, created_at, updated_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $11)
+		ON CONFLICT (organization_id) DO UPDATE
+		SET idp_entity_id = EXCLUDED.idp_entity_id,
+		    idp_metadata = EXCLUDED.idp_metadata,
+		    email_domains = EXCLUDED.email_domains,
+		    default_role = EXCLUDED.default_role,
+		    email_attribute = EXCLUDED.email_attribute,
+		    first_name_attribute = EXCLUDED.first_name_attribute,
+		    last_name_attribute = EXCLUDED.last_name_attribute,
+		    role_attribute = EXCLUDED.role_attribute,
+		    enabled = EXCLUDED.enabled,
+		    updated_at = EXCLUDED.updated_at
+		RETURNING id, created_at
+	`
+
+	err := pg.db.QueryRow(ctx, query,
+		conn.OrganizationID,
+		conn.IDPEntityID,
+		conn.IDPMetadata,
+		conn.EmailDomains,
+		conn.DefaultRole,
+		conn.EmailAttribute,
+		conn.FirstNameAttribute,
+		conn.LastNameAttribute,
+		conn.RoleAttribute,
+		conn.Enabled,
+		conn.UpdatedAt,
+	).Scan(&conn.ID, &conn.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("unable to save saml connection: %w", err)
+	}
+
+	return nil
+}
+
+// GetSAMLConnection retrieves an organization's identity provider settings
+func (pg *Postgres) GetSAMLConnection(ctx context.Context, organizationID int) (*SAMLConnection, error) {
+	query := `
+		SELECT id, organization_id, idp_entity_id, idp_metadata, email_domains, default_role,
+		       email_attribute, first_name_attribute, last_name_attribute, role_attribute, enabled, created_at, updated_at
+		FROM saml_connections
+		WHERE organization_id = $1
+	`
+
+	var conn SAMLConnection
+	err := pg.db.QueryRow(ctx, query, organizationID).Scan(
+		&conn.ID,
+		&conn.OrganizationID,
+		&conn.IDPEntityID,
+		&conn.IDPMetadata,
+		&conn.EmailDomains,
+		&conn.DefaultRole,
+		&conn.EmailAttribute,
+		&conn.FirstNameAttribute,
+		&conn.LastNameAttribute,
+		&conn.RoleAttribute,
+		&conn.Enabled,
+		&conn.CreatedAt,
+		&conn.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("saml connection not found")
+		}
+		return nil, fmt.Errorf("unable to get saml connection: %w", err)
+	}
+
+	return &conn, nil
+}
+
+// DeleteSAMLConnection turns sso off for an organization, its users keep their accounts
+func (pg *Postgres) DeleteSAMLConnection(ctx context.Context, organizationID int) error {
+	result, err := pg.db.Exec(ctx, `DELETE FROM saml_connections WHERE organization_id = $1`, organizationID)
+	if err != nil {
+		return fmt.Errorf("unable to delete saml connection: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("saml connection not found")
+	}
+
+	return nil
+}
+
+// CreateSAMLRequest remembers an AuthnRequest until the identity provider answers it
+func (pg *Postgres) CreateSAMLRequest(ctx context.Context, requestID string, organizationID int, relayStateHash string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO saml_requests (request_id, organization_id, relay_state_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	if _, err := pg.db.Exec(ctx, query, requestID, organizationID, relayStateHash, expiresAt); err != nil {
+		return fmt.Errorf("unable to create saml request: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeSAMLRequest deletes the request the RelayState points at and returns its id,
+// so every AuthnRequest can only be answered once
+func (pg *Postgres) ConsumeSAMLRequest(ctx context.Context, relayStateHash string, organizationID int) (string, error) {
+	query := `
+		DELETE FROM saml_requests
+		WHERE relay_state_hash = $1 AND organization_id = $2 AND expires_at > $3
+		RETURNING request_id
+	`
+
+	var requestID string
+	err := pg.db.QueryRow(ctx, query, relayStateHash, organizationID, time.Now().UTC()).Scan(&requestID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", fmt.Errorf("saml request not found")
+		}
+		return "", fmt.Errorf("unable to use saml request: %w", err)
+	}
+
+	return requestID, nil
+}
+
+// DeleteExpiredSAMLRequests deletes requests nobody answered (cleanup)
+func (pg *Postgres) DeleteExpiredSAMLRequests(ctx context.Context) error {
+	result, err := pg.db.Exec(ctx, `DELETE FROM saml_requests WHERE expires_at < $1`, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("unable to delete expired saml requests: %w", err)
+	}
+
+	log.Printf(" Deleted %d expired saml requests", result.RowsAffected())
+	return nil
+}
+
+// ============================================
+// SCIM PROVISIONING OPERATIONS
+// ============================================
+
+// CreateSCIMToken stores a new provisioning token for an organization, only its hash is saved
+func (pg *Postgres) CreateSCIMToken(ctx context.Context, token *SCIMToken) error {
+	query := `
+		INSERT INTO scim_tokens (organization_id, name, token_hash, token_prefix, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+
+	err := pg.db.QueryRow(ctx, query,
+		token.OrganizationID,
+		token.Name,
+		token.TokenHash,
+		token.TokenPrefix,
+		token.CreatedAt,
+	).Scan(&token.ID)
+	if err != nil {
+		return fmt.Errorf("unable to create scim token: %w", err)
+	}
+
+	log.Printf(" Created scim token %d for organization ID: %d", token.ID, token.OrganizationID)
+	return nil
+}
+
+// GetSCIMTokenByHash finds a provisioning token by the hash of what the SIS sent
+func (pg *Postgres) GetSCIMTokenByHash(ctx context.Context, tokenHash string) (*SCIMToken, error) {
+	query := `
+		SELECT id, organization_id, name, token_hash, token_prefix, last_used_at, created_at
+		FROM scim_tokens
+		WHERE token_hash = $1
+	`
+
+	var token SCIMToken
+	err := pg.db.QueryRow(ctx, query, tokenHash).Scan(
+		&token.ID,
+		&token.OrganizationID,
+		&token.Name,
+		&token.TokenHash,
+		&token.TokenPrefix,
+		&token.LastUsedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("scim token not found")
+		}
+		return nil, fmt.Errorf("unable to get scim token: %w", err)
+	}
+
+	return &token, nil
+}
+
+// ListSCIMTokens returns an organization's provisioning tokens, newest first
+func (pg *Postgres) ListSCIMTokens(ctx context.Context, organizationID int) ([]SCIMToken, error) {
+	query := `
+		SELECT id, organization_id, name, token_hash, token_prefix, last_used_at, created_at
+		FROM scim_tokens
+		WHERE organization_id = $1
+		ORDER BY created_at DESC, id DESC
+	`
+
+	rows, err := pg.db.Query(ctx, query, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list scim tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []SCIMToken
+	for rows.Next() {
+		var token SCIMToken
+		err := rows.Scan(
+			&token.ID,
+			&token.OrganizationID,
+			&token.Name,
+			&token.TokenHash,
+			&token.TokenPrefix,
+			&token.LastUsedAt,
+			&token.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan scim token: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating scim tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// DeleteSCIMToken revokes one of an organization's provisioning tokens
+func (pg *Postgres) DeleteSCIMToken(ctx context.Context, organizationID, tokenID int) error {
+	result, err := pg.db.Exec(ctx, `DELETE FROM scim_tokens WHERE id = $1 AND organization_id = $2`, tokenID, organizationID)
+	if err != nil {
+		return fmt.Errorf("unable to delete scim token: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("scim token not found")
+	}
+
+	return nil
+}
+
+// TouchSCIMToken records that a token was used, at most once a minute since a sync sends a request per user
+func (pg *Postgres) TouchSCIMToken(ctx context.Context, tokenID int, usedAt time.Time) error {
+	query := `
+		UPDATE scim_tokens
+		SET last_used_at = $2
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $2 - INTERVAL '1 minute')
+	`
+
+	if _, err := pg.db.Exec(ctx, query, tokenID, usedAt); err != nil {
+		return fmt.Errorf("unable to update scim token: %w", err)
+	}
+
+	return nil
+}
+
+// scimUserColumns is the column list every scim user query selects, in scanSCIMUser's order
+const scimUserColumns = `
+	u.id, s.organization_id, s.user_name, COALESCE(s.external_id, ''), u.email, u.first_name, u.last_name,
+	COALESCE(u.role, 'student'), u.suspended_at, COALESCE(u.suspended_by, ''), s.created_at, GREATEST(s.updated_at, u.updated_at)
+`
+
+func scanSCIMUser(row pgx.Row) (*SCIMUser, error) {
+	var user SCIMUser
+	err := row.Scan(
+		&user.UserID,
+		&user.OrganizationID,
+		&user.UserName,
+		&user.ExternalID,
+		&user.Email,
+		&user.FirstName,
+		&user.LastName,
+		&user.Role,
+		&user.SuspendedAt,
+		&user.SuspendedBy,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SCIMUserFilter narrows ListSCIMUsers and CountSCIMUsers, empty fields mean "dont filter on this"
+type SCIMUserFilter struct {
+	UserName   string // case insensitive like scim wants
+	ExternalID string
+	Email      string
+}
+
+// where builds the WHERE clause for one organization's roster, every value goes in as a parameter
+func (f SCIMUserFilter) where(organizationID int) (string, []interface{}) {
+	args := []interface{}{organizationID}
+	conditions := []string{"s.organization_id = $1"}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if f.UserName != "" {
+		add("LOWER(s.user_name) = LOWER(?)", f.UserName)
+	}
+	if f.ExternalID != "" {
+		add("s.external_id = ?", f.ExternalID)
+	}
+	if f.Email != "" {
+		add("LOWER(u.email) = LOWER(?)", f.Email)
+	}
+
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// CreateSCIMUser puts an existing account on an organization's roster
+func (pg *Postgres) CreateSCIMUser(ctx context.Context, user *SCIMUser) error {
+	query := `
+		INSERT INTO scim_users (user_id, organization_id, user_name, external_id, created_at, updated_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $5)
+	`
+
+	_, err := pg.db.Exec(ctx, query, user.UserID, user.OrganizationID, user.UserName, user.ExternalID, user.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return fmt.Errorf("user %s is already provisioned", user.UserName)
+		}
+		return fmt.Errorf("unable to create scim user: %w", err)
+	}
+
+	return nil
+}
+
+// GetSCIMUser retrieves a user on an organization's roster, users on other rosters are "not found"
+func (pg *Postgres) GetSCIMUser(ctx context.Context, organizationID, userID int) (*SCIMUser, error) {
+	query := `SELECT ` + scimUserColumns + ` FROM scim_users s JOIN users u ON u.id = s.user_id WHERE s.organization_id = $1 AND s.user_id = $2`
+
+	user, err := scanSCIMUser(pg.db.QueryRow(ctx, query, organizationID, userID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("scim user not found")
+		}
+		return nil, fmt.Errorf("unable to get scim user: %w", err)
+	}
+
+	return user, nil
+}
+
+// ListSCIMUsers retrieves the users on an organization's roster matching filter, in the order they were provisioned
+func (pg *Postgres) ListSCIMUsers(ctx context.Context, organizationID int, filter SCIMUserFilter, limit, offset int) ([]SCIMUser, error) {
+	where, args := filter.where(organizationID)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM scim_users s
+		JOIN users u ON u.id = s.user_id
+		%s
+		ORDER BY s.created_at, s.user_id
+		LIMIT $%d OFFSET $%d
+	`, scimUserColumns, where, len(args)+1, len(args)+2)
+	args = append(args, limit, offset)
+
+	rows, err := pg.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list scim users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []SCIMUser
+	for rows.Next() {
+		user, err := scanSCIMUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan scim user: %w", err)
+		}
+		users = append(users, *user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating scim users: %w", err)
+	}
+
+	return users, nil
+}
+
+// CountSCIMUsers returns how many users on an organization's roster match filter
+func (pg *Postgres) CountSCIMUsers(ctx context.Context, organizationID int, filter SCIMUserFilter) (int, error) {
+	where, args := filter.where(organizationID)
+	query := `SELECT COUNT(*) FROM scim_users s JOIN users u ON u.id = s.user_id ` + where
+
+	var count int
+	if err := pg.db.QueryRow(ctx, query, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("unable to count scim users: %w", err)
+	}
+
+	return count, nil
+}
+
+// UpdateSCIMUser saves a changed userName and externalId, names and role go through UpdateUser and UpdateUserRole
+func (pg *Postgres) UpdateSCIMUser(ctx context.Context, user *SCIMUser) error {
+	query := `
+		UPDATE scim_users
+		SET user_name = $3, external_id = NULLIF($4, ''), updated_at = $5
+		WHERE user_id = $1 AND organization_id = $2
+	`
+
+	result, err := pg.db.Exec(ctx, query, user.UserID, user.OrganizationID, user.UserName, user.ExternalID, user.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("unable to update scim user: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("scim user not found")
+	}
+
+	return nil
+}
+
+// DeleteSCIMUser takes a user off an organization's roster and out of its groups, the account itself stays
+func (pg *Postgres) DeleteSCIMUser(ctx context.Context, organizationID, userID int) error {
+	return pg.WithTransaction(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			DELETE FROM scim_group_members m
+			USING scim_groups g
+			WHERE g.id = m.group_id AND g.organization_id = $1 AND m.user_id = $2
+		`, organizationID, userID)
+		if err != nil {
+			return fmt.Errorf("unable to delete scim group memberships: %w", err)
+		}
+
+		result, err := tx.Exec(ctx, `DELETE FROM scim_users WHERE organization_id = $1 AND user_id = $2`, organizationID, userID)
+		if err != nil {
+			return fmt.Errorf("unable to delete scim user: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return fmt.Errorf("scim user not found")
+		}
+		return nil
+	})
+}
+
+// ListSCIMUserGroups returns the groups a user is a member of, by name
+func (pg *Postgres) ListSCIMUserGroups(ctx context.Context, userID int) ([]SCIMGroup, error) {
+	query := `
+		SELECT g.id, g.organization_id, g.display_name, COALESCE(g.external_id, ''), g.created_at, g.updated_at
+		FROM scim_group_members m
+		JOIN scim_groups g ON g.id = m.group_id
+		WHERE m.user_id = $1
+		ORDER BY g.display_name, g.id
+	`
+
+	return pg.querySCIMGroups(ctx, query, userID)
+}
+
+// SCIMGroupFilter narrows ListSCIMGroups and CountSCIMGroups, empty fields mean "dont filter on this"
+type SCIMGroupFilter struct {
+	DisplayName string // case insensitive
+	ExternalID  string
+}
+
+// where builds the WHERE clause for one organization's groups, every value goes in as a parameter
+func (f SCIMGroupFilter) where(organizationID int) (string, []interface{}) {
+	args := []interface{}{organizationID}
+	conditions := []string{"organization_id = $1"}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if f.DisplayName != "" {
+		add("LOWER(display_name) = LOWER(?)", f.DisplayName)
+	}
+	if f.ExternalID != "" {
+		add("external_id = ?", f.ExternalID)
+	}
+
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// CreateSCIMGroup adds a group to an organization
+func (pg *Postgres) CreateSCIMGroup(ctx context.Context, group *SCIMGroup) error {
+	query := `
+		INSERT INTO scim_groups (organization_id, display_name, external_id, created_at, updated_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $4)
+		RETURNING id
+	`
+
+	err := pg.db.QueryRow(ctx, query, group.OrganizationID, group.DisplayName, group.ExternalID, group.CreatedAt).Scan(&group.ID)
+	if err != nil {
+		return fmt.Errorf("unable to create scim group: %w", err)
+	}
+
+	group.UpdatedAt = group.CreatedAt
+	return nil
+}
+
+// GetSCIMGroup retrieves one of an organization's groups
+func (pg *Postgres) GetSCIMGroup(ctx context.Context, organizationID, groupID int) (*SCIMGroup, error) {
+	query := `
+		SELECT id, organization_id, display_name, COALESCE(external_id, ''), created_at, updated_at
+		FROM scim_groups
+		WHERE organization_id = $1 AND id = $2
+	`
+
+	var group SCIMGroup
+	err := pg.db.QueryRow(ctx, query, organizationID, groupID).Scan(
+		&group.ID,
+		&group.OrganizationID,
+		&group.DisplayName,
+		&group.ExternalID,
+		&group.CreatedAt,
+		&group.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("scim group not found")
+		}
+		return nil, fmt.Errorf("unable to get scim group: %w", err)
+	}
+
+	return &group, nil
+}
+
+// ListSCIMGroups retrieves an organization's groups matching filter, in the order they were created
+func (pg *Postgres) ListSCIMGroups(ctx context.Context, organizationID int, filter SCIMGroupFilter, limit, offset int) ([]SCIMGroup, error) {
+	where, args := filter.where(organizationID)
+	query := fmt.Sprintf(`
+		SELECT id, organization_id, display_name, COALESCE(external_id, ''), created_at, updated_at
+		FROM scim_groups
+		%s
+		ORDER BY created_at, id
+		LIMIT $%d OFFSET $%d
+	`, where, len(args)+1, len(args)+2)
+	args = append(args, limit, offset)
+
+	return pg.querySCIMGroups(ctx, query, args...)
+}
+
+// CountSCIMGroups returns how many of an organization's groups match filter
+func (pg *Postgres) CountSCIMGroups(ctx context.Context, organizationID int, filter SCIMGroupFilter) (int, error) {
+	where, args := filter.where(organizationID)
+
+	var count int
+	if err := pg.db.QueryRow(ctx, `SELECT COUNT(*) FROM scim_groups `+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("unable to count scim groups: %w", err)
+	}
+
+	return count, nil
+}
+
+func (pg *Postgres) querySCIMGroups(ctx context.Context, query string, args ...interface{}) ([]SCIMGroup, error) {
+	rows, err := pg.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list scim groups: %w", err)
+	}
+	defer rows.Close()
+
+	var groups []SCIMGroup
+	for rows.Next() {
+		var group SCIMGroup
+		if err := rows.Scan(&group.ID, &group.OrganizationID, &group.DisplayName, &group.ExternalID, &group.CreatedAt, &group.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("unable to scan scim group: %w", err)
+		}
+		groups = append(groups, group)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating scim groups: %w", err)
+	}
+
+	return groups, nil
+}
+
+// UpdateSCIMGroup saves a group's name and externalId
+func (pg *Postgres) UpdateSCIMGroup(ctx context.Context, group *SCIMGroup) error {
+	query := `
+		UPDATE scim_groups
+		SET display_name = $3, external_id = NULLIF($4, ''), updated_at = $5
+		WHERE organization_id = $1 AND id = $2
+	`
+
+	result, err := pg.db.Exec(ctx, query, group.OrganizationID, group.ID, group.DisplayName, group.ExternalID, group.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("unable to update scim group: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("scim group not found")
+	}
+
+	return nil
+}
+
+// DeleteSCIMGroup deletes one of an organization's groups, its members keep their accounts
+func (pg *Postgres) DeleteSCIMGroup(ctx context.Context, organizationID, groupID int) error {
+	result, err := pg.db.Exec(ctx, `DELETE FROM scim_groups WHERE organization_id = $1 AND id = $2`, organizationID, groupID)
+	if err != nil {
+		return fmt.Errorf("unable to delete scim group: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("scim group not found")
+	}
+
+	return nil
+}
+
+// ListSCIMGroupMembers returns the users in a group, with the userName the SIS knows them by
+func (pg *Postgres) ListSCIMGroupMembers(ctx context.Context, groupID int) ([]SCIMGroupMember, error) {
+	query := `
+		SELECT m.user_id, s.user_name
+		FROM scim_group_members m
+		JOIN scim_users s ON s.user_id = m.user_id
+		WHERE m.group_id = $1
+		ORDER BY s.user_name, m.user_id
+	`
+
+	rows, err := pg.db.Query(ctx, query, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list scim group members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []SCIMGroupMember
+	for rows.Next() {
+		var member SCIMGroupMember
+		if err := rows.Scan(&member.UserID, &member.UserName); err != nil {
+			return nil, fmt.Errorf("unable to scan scim group member: %w", err)
+		}
+		members = append(members, member)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating scim group members: %w", err)
+	}
+
+	return members, nil
+}
+
+// AddSCIMGroupMembers adds users to a group. only users on the group's own organization's roster are added,
+// any other id is skipped, so a SIS can never pull someone else's account into its groups
+func (pg *Postgres) AddSCIMGroupMembers(ctx context.Context, organizationID, groupID int, userIDs []int) error {
+	return pg.WithTransaction(ctx, func(tx pgx.Tx) error {
+		return addSCIMGroupMembers(ctx, tx, organizationID, groupID, userIDs)
+	})
+}
+
+// RemoveSCIMGroupMembers takes users out of a group
+func (pg *Postgres) RemoveSCIMGroupMembers(ctx context.Context, organizationID, groupID int, userIDs []int) error {
+	return pg.WithTransaction(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM scim_group_members WHERE group_id = $1 AND user_id = ANY($2)`, groupID, userIDs); err != nil {
+			return fmt.Errorf("unable to remove scim group members: %w", err)
+		}
+		return touchSCIMGroup(ctx, tx, organizationID, groupID)
+	})
+}
+
+// ReplaceSCIMGroupMembers makes userIDs the group's whole membership
+func (pg *Postgres) ReplaceSCIMGroupMembers(ctx context.Context, organizationID, groupID int, userIDs []int) error {
+	return pg.WithTransaction(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM scim_group_members WHERE group_id = $1`, groupID); err != nil {
+			return fmt.Errorf("unable to clear scim group members: %w", err)
+		}
+		return addSCIMGroupMembers(ctx, tx, organizationID, groupID, userIDs)
+	})
+}
+
+func addSCIMGroupMembers(ctx context.Context, tx pgx.Tx, organizationID, groupID int, userIDs []int) error {
+	query := `
+		INSERT INTO scim_group_members (group_id, user_id)
+		SELECT $1, user_id FROM scim_users WHERE organization_id = $2 AND user_id = ANY($3)
+		ON CONFLICT DO NOTHING
+	`
+
+	if _, err := tx.Exec(ctx, query, groupID, organizationID, userIDs); err != nil {
+		return fmt.Errorf("unable to add scim group members: %w", err)
+	}
+	return touchSCIMGroup(ctx, tx, organizationID, groupID)
+}
+
+// touchSCIMGroup bumps updated_at (the meta.lastModified scim clients see) after a membership change
+func touchSCIMGroup(ctx context.Context, tx pgx.Tx, organizationID, groupID int) error {
+	result, err := tx.Exec(ctx, `UPDATE scim_groups SET updated_at = $3 WHERE organization_id = $1 AND id = $2`,
+		organizationID, groupID, time.Now().UTC().Truncate(time.Microsecond))
+	if err != nil {
+		return fmt.Errorf("unable to update scim group: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("scim group not found")
+	}
+
+	return nil
+}
+
+// ============================================
+// REFRESH TOKEN OPERATIONS
+// ============================================
+
+// refreshTokenColumns is the column list every refresh token query selects, in scanRefreshToken's order
+const refreshTokenColumns = `id, family_id, user_id, token_hash, used_at, revoked_at, expires_at, created_at`
+
+func scanRefreshToken(row pgx.Row) (*RefreshToken, error) {
+	var token RefreshToken
+	err := row.Scan(
+		&token.ID,
+		&token.FamilyID,
+		&token.UserID,
+		&token.TokenHash,
+		&token.UsedAt,
+		&token.RevokedAt,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// CreateRefreshToken stores a refresh token, only its hash is saved
+func (pg *Postgres) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (family_id, user_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+
+	err := pg.db.QueryRow(ctx, query, token.FamilyID, token.UserID, token.TokenHash, token.ExpiresAt, token.CreatedAt).Scan(&token.ID)
+	if err != nil {
+		return fmt.Errorf("unable to create refresh token: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeRefreshToken uses up a token and returns it. only an unused, unrevoked and unexpired token can be consumed,
+// and only once, two refreshes racing with the same token can't both win
+func (pg *Postgres) ConsumeRefreshToken(ctx context.Context, tokenHash string, now time.Time) (*RefreshToken, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET used_at = $2
+		WHERE token_hash = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > $2
+		RETURNING ` + refreshTokenColumns
+
+	token, err := scanRefreshToken(pg.db.QueryRow(ctx, query, tokenHash, now))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("refresh token not found")
+		}
+		return nil, fmt.Errorf("unable to use refresh token: %w", err)
+	}
+
+	return token, nil
+}
+
+// GetRefreshTokenByHash finds a token whatever its state, it tells a reused token from an unknown one
+func (pg *Postgres) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	query := `SELECT ` + refreshTokenColumns + ` FROM refresh_tokens WHERE token_hash = $1`
+
+	token, err := scanRefreshToken(pg.db.QueryRow(ctx, query, tokenHash))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("refresh token not found")
+		}
+		return nil, fmt.Errorf("unable to get refresh token: %w", err)
+	}
+
+	return token, nil
+}
+
+// RevokeRefreshTokenFamily revokes every token of one sign in, the device has to sign in again
+func (pg *Postgres) RevokeRefreshTokenFamily(ctx context.Context, familyID string) (int64, error) {
+	query := `UPDATE refresh_tokens SET revoked_at = $2 WHERE family_id = $1 AND revoked_at IS NULL`
+
+	result, err := pg.db.Exec(ctx, query, familyID, time.Now().UTC().Truncate(time.Microsecond))
+	if err != nil {
+		return 0, fmt.Errorf("unable to revoke refresh token family: %w", err)
+	}
+
+	return result.RowsAffected(), nil
+}
+
+// RevokeUserRefreshTokens signs the user's apps out everywhere except exceptFamilyID, the same as DeleteUserSessions does for cookies.
+// an empty exceptFamilyID revokes them all. returns how many families (devices) were signed out
+func (pg *Postgres) RevokeUserRefreshTokens(ctx context.Context, userID int, exceptFamilyID string) (int64, error) {
+	query := `
+		WITH revoked AS (
+			UPDATE refresh_tokens SET revoked_at = $3
+			WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL AND expires_at > $3
+			RETURNING family_id
+		)
+		SELECT COUNT(DISTINCT family_id) FROM revoked
+	`
+
+	var families int64
+	err := pg.db.QueryRow(ctx, query, userID, exceptFamilyID, time.Now().UTC().Truncate(time.Microsecond)).Scan(&families)
+	if err != nil {
+		return 0, fmt.Errorf("unable to revoke refresh tokens: %w", err)
+	}
+
+	log.Printf(" Revoked %d refresh token families for user ID: %d", families, userID)
+	return families, nil
+}
+
+// DeleteExpiredRefreshTokens deletes expired tokens (cleanup), used ones stay until they expire so reuse is still caught
+func (pg *Postgres) DeleteExpiredRefreshTokens(ctx context.Context) error {
+	result, err := pg.db.Exec(ctx, `DELETE FROM refresh_tokens WHERE expires_at < $1`, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("unable to delete expired refresh tokens: %w", err)
+	}
+
+	log.Printf(" Deleted %d expired refresh tokens", result.RowsAffected())
+	return nil
+}
+
+// ============================================
+// MEDIA OPERATIONS
+// ============================================
+
+// CreateMedia saves an uploaded file's metadata, the object itself is already in the blob store
+func (pg *Postgres) CreateMedia(ctx context.Context, media *Media) error {
+	query := `
+		INSERT INTO media (user_id, kind, content_type, size_bytes, object_key, thumbnail_key, original_name)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7)
+		RETURNING id, created_at
+	`
+
+	err := pg.db.QueryRow(ctx, query,
+		media.UserID,
+		media.Kind,
+		media.ContentType,
+		media.SizeBytes,
+		media.ObjectKey,
+		media.ThumbnailKey,
+		media.OriginalName,
+	).Scan(&media.ID, &media.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("unable to create media: %w", err)
+	}
+
+	return nil
+}
+
+// GetMedia retrieves one uploaded file
+func (pg *Postgres) GetMedia(ctx context.Context, mediaID int) (*Media, error) {
+	query := `
+		SELECT id, user_id, kind, content_type, size_bytes, object_key, COALESCE(thumbnail_key, ''), original_name, created_at
+		FROM media
+		WHERE id = $1
+	`
+
+	var media Media
+	err := pg.db.QueryRow(ctx, query, mediaID).Scan(
+		&media.ID,
+		&media.UserID,
+		&media.Kind,
+		&media.ContentType,
+		&media.SizeBytes,
+		&media.ObjectKey,
+		&media.ThumbnailKey,
+		&media.OriginalName,
+		&media.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("media not found")
+		}
+		return nil, fmt.Errorf("unable to get media: %w", err)
+	}
+
+	return &media, nil
+}
+
+// ListMediaByUser returns everything a user uploaded, newest first
+func (pg *Postgres) ListMediaByUser(ctx context.Context, userID int) ([]Media, error) {
+	query := `
+		SELECT id, user_id, kind, content_type, size_bytes, object_key, COALESCE(thumbnail_key, ''), original_name, created_at
+		FROM media
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+	`
+
+	rows, err := pg.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list media: %w", err)
+	}
+	defer rows.Close()
+
+	var media []Media
+	for rows.Next() {
+		var m Media
+		err := rows.Scan(
+			&m.ID,
+			&m.UserID,
+			&m.Kind,
+			&m.ContentType,
+			&m.SizeBytes,
+			&m.ObjectKey,
+			&m.ThumbnailKey,
+			&m.OriginalName,
+			&m.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan media: %w", err)
+		}
+		media = append(media, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating media: %w", err)
+	}
+
+	return media, nil
+}
+
+// DeleteMedia removes a media row, if it was someone's avatar their avatar goes back to empty.
+// the caller deletes the objects from the blob store
+func (pg *Postgres) DeleteMedia(ctx context.Context, mediaID int) error {
+	query := `
+		WITH cleared AS (
+			UPDATE users SET avatar_url = '', avatar_media_id = NULL, updated_at = CURRENT_TIMESTAMP
+			WHERE avatar_media_id = $1
+		)
+		DELETE FROM media WHERE id = $1
+	`
+
+	result, err := pg.db.Exec(ctx, query, mediaID)
+	if err != nil {
+		return fmt.Errorf("unable to delete media: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("media not found")
+	}
+
+	return nil
+}
+
+// SetAvatar points the user's avatar at an uploaded image and returns the media it replaced (0 if none)
+func (pg *Postgres) SetAvatar(ctx context.Context, userID, mediaID int, avatarURL string) (int, error) {
+	query := `
+		UPDATE users u
+		SET avatar_media_id = $2, avatar_url = $3, updated_at = CURRENT_TIMESTAMP
+		FROM (SELECT avatar_media_id FROM users WHERE id = $1) old
+		WHERE u.id = $1
+		RETURNING COALESCE(old.avatar_media_id, 0)
+	`
+
+	var previous int
+	err := pg.db.QueryRow(ctx, query, userID, mediaID, avatarURL).Scan(&previous)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, fmt.Errorf("user not found")
+		}
+		return 0, fmt.Errorf("unable to set avatar: %w", err)
+	}
+
+	return previous, nil
+}
+
+// GetAvatarMedia returns the image the user uploaded as their avatar
+func (pg *Postgres) GetAvatarMedia(ctx context.Context, userID int) (*Media, error) {
+	var mediaID *int
+	err := pg.db.QueryRow(ctx, `SELECT avatar_media_id FROM users WHERE id = $1`, userID).Scan(&mediaID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, fmt.Errorf("unable to get avatar: %w", err)
+	}
+	if mediaID == nil {
+		return nil, fmt.Errorf("media not found")
+	}
+
+	return pg.GetMedia(ctx, *mediaID)
+}
+
+// ============================================
+// LOGIN LOCKOUT OPERATIONS
+// ============================================
+
+// GetLoginLockout returns the user's failed logins in a row and how much longer they are locked out (0 if they are not)
+func (pg *Postgres) GetLoginLockout(ctx context.Context, userID int) (int, time.Duration, error) {
+	query := `
+		SELECT failed_login_attempts,
+			GREATEST(EXTRACT(EPOCH FROM (locked_until - CURRENT_TIMESTAMP)), 0)::float8
+		FROM users
+		WHERE id = $1
+	`
+
+	var attempts int
+	var seconds float64
+	err := pg.db.QueryRow(ctx, query, userID).Scan(&attempts, &seconds)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, 0, fmt.Errorf("user not found")
+		}
+		return 0, 0, fmt.Errorf("unable to get login lockout: %w", err)
+	}
+
+	return attempts, time.Duration(seconds * float64(time.Second)), nil
+}
+
+// RecordFailedLogin bumps the failed login counter and returns the new count
+func (pg *Postgres) RecordFailedLogin(ctx context.Context, userID int) (int, error) {
+	query := `
+		UPDATE users
+		SET failed_login_attempts = failed_login_attempts + 1
+		WHERE id = $1
+		RETURNING failed_login_attempts
+	`
+
+	var attempts int
+	if err := pg.db.QueryRow(ctx, query, userID).Scan(&attempts); err != nil {
+		return 0, fmt.Errorf("unable to record failed login: %w", err)
+	}
+
+	return attempts, nil
+}
+
+// LockUser blocks password logins for the user for the given duration
+func (pg *Postgres) LockUser(ctx context.Context, userID int, duration time.Duration) error {
+	query := `
+		UPDATE users
+		SET locked_until = CURRENT_TIMESTAMP + make_interval(secs => $2)
+		WHERE id = $1
+	`
+
+	result, err := pg.db.Exec(ctx, query, userID, duration.Seconds())
+	if err != nil {
+		return fmt.Errorf("unable to lock user: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	return nil
+}
+
+// UnlockUser clears the failed login counter and any lockout
+// used after a good login, a password reset or an admin unlock
+func (pg *Postgres) UnlockUser(ctx context.Context, userID int) error {
+	query := `
+		UPDATE users
+		SET failed_login_attempts = 0, locked_until = NULL
+		WHERE id = $1
+	`
+
+	result, err := pg.db.Exec(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("unable to unlock user: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	return nil
+}
+
+// ============================================
+// RATE LIMIT OPERATIONS
+// ============================================
+
+// TakeRateLimitToken refills the bucket for key and takes one token from it in a single statement,
+// so two replicas can't both take the last token.
+// when the bucket is empty nothing is written and it returns false with the tokens currently in the bucket
+func (pg *Postgres) TakeRateLimitToken(ctx context.Context, key string, burst, perSecond float64) (bool, float64, error) {
+	query := `
+		INSERT INTO rate_limit_buckets (key, tokens, updated_at)
+		VALUES ($1, $2::float8 - 1, CURRENT_TIMESTAMP)
+		ON CONFLICT (key) DO UPDATE
+		SET tokens = LEAST($2::float8, rate_limit_buckets.tokens + EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - rate_limit_buckets.updated_at))::float8 * $3::float8) - 1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE LEAST($2::float8, rate_limit_buckets.tokens + EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - rate_limit_buckets.updated_at))::float8 * $3::float8) >= 1
+		RETURNING tokens
+	`
+
+	var tokens float64
+	err := pg.db.QueryRow(ctx, query, key, burst, perSecond).Scan(&tokens)
+	if err == nil {
+		return true, tokens, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return false, 0, fmt.Errorf("unable to take rate limit token: %w", err)
+	}
+
+	// the bucket is empty, read how far it has refilled so the caller can say when to retry
+	query = `
+		SELECT LEAST($2::float8, tokens + EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - updated_at))::float8 * $3::float8)
+		FROM rate_limit_buckets
+		WHERE key = $1
+	`
+	if err := pg.db.QueryRow(ctx, query, key, burst, perSecond).Scan(&tokens); err != nil {
+		return false, 0, fmt.Errorf("unable to read rate limit bucket: %w", err)
+	}
+
+	return false, tokens, nil
+}
+
+// DeleteRateLimitBucket forgets the bucket for key
+func (pg *Postgres) DeleteRateLimitBucket(ctx context.Context, key string) error {
+	query := `DELETE FROM rate_limit_buckets WHERE key = $1`
+
+	if _, err := pg.db.Exec(ctx, query, key); err != nil {
+		return fmt.Errorf("unable to delete rate limit bucket: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteStaleRateLimitBuckets removes buckets nobody used for a while, they would be full again anyway
+func (pg *Postgres) DeleteStaleRateLimitBuckets(ctx context.Context, olderThan time.Duration) error {
+	query := `DELETE FROM rate_limit_buckets WHERE updated_at < CURRENT_TIMESTAMP - make_interval(secs => $1)`
+
+	result, err := pg.db.Exec(ctx, query, olderThan.Seconds())
+	if err != nil {
+		return fmt.Errorf("unable to delete stale rate limit buckets: %w", err)
+	}
+
+	log.Printf("✅ Deleted %d stale rate limit buckets", result.RowsAffected())
+	return nil
+}
+
+// ============================================
+// AUDIT LOG OPERATIONS
+// ============================================
+
+// CreateAuditLog creates a new audit log entry
+func (pg *Postgres) CreateAuditLog(ctx context.Context, userID *int, action, ipAddress, userAgent string, success bool, failureReason string) error {
+	return pg.appendAuditLog(ctx, auditEntry{
+		userID:        userID,
+		action:        action,
+		ipAddress:     ipAddress,
+		userAgent:     userAgent,
+		success:       success,
+		failureReason: failureReason,
+	})
+}
+
+// CreateAdminAuditLog records something one user (usually an admin) did to another user
+func (pg *Postgres) CreateAdminAuditLog(ctx context.Context, actorID, targetUserID int, action, ipAddress, userAgent, details string) error {
+	return pg.appendAuditLog(ctx, auditEntry{
+		userID:       &actorID,
+		targetUserID: &targetUserID,
+		action:       action,
+		ipAddress:    ipAddress,
+		userAgent:    userAgent,
+		success:      true,
+		details:      details,
+	})
+}
+
+// CountRecentAuditLogs counts a user's rows for one action since a point in time
+func (pg *Postgres) CountRecentAuditLogs(ctx context.Context, userID int, action string, since time.Time) (int, error) {
+	query := `SELECT COUNT(*) FROM audit_log WHERE user_id = $1 AND action = $2 AND created_at >= $3`
+
+	var count int
+	err := pg.db.QueryRow(ctx, query, userID, action, since).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("unable to count audit logs: %w", err)
+	}
+
+	return count, nil
+}
+
+// auditLogColumns is what every audit log query selects, in the order scanAuditLog reads it.
+// rows written by CreateAdminAuditLog and the cli leave some of these NULL
+const auditLogColumns = `id, user_id, target_user_id, action, COALESCE(ip_address, ''), COALESCE(user_agent, ''),
+		COALESCE(success, false), COALESCE(failure_reason, ''), COALESCE(details, ''), created_at`
+
+func scanAuditLog(row pgx.Row, log *AuditLog) error {
+	return row.Scan(
+		&log.ID,
+		&log.UserID,
+		&log.TargetUserID,
+		&log.Action,
+		&log.IPAddress,
+		&log.UserAgent,
+		&log.Success,
+		&log.FailureReason,
+		&log.Details,
+		&log.CreatedAt,
+	)
+}
+
+// GetAuditLogsByUser retrieves audit logs for a specific user
+func (pg *Postgres) GetAuditLogsByUser(ctx context.Context, userID int, limit int) ([]AuditLog, error) {
+	query := `
+		SELECT ` + auditLogColumns + `
+		FROM audit_log
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+
+	rows, err := pg.db.Query(ctx, query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get audit logs: %w", err)
+	}
+	defer rows.Close()
+
+	var logs []AuditLog
+	for rows.Next() {
+		var log AuditLog
+		if err := scanAuditLog(rows, &log); err != nil {
+			return nil, fmt.Errorf("unable to scan audit log: %w", err)
+		}
+		logs = append(logs, log)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit logs: %w", err)
+	}
+
+	return logs, nil
+}
+
+// AuditFilter narrows ListAuditLogs and StreamAuditLogs, zero values mean "dont filter on this"
+type AuditFilter struct {
+	UserID      *int // the user did it
+	AboutUserID *int // the user did it or it was done to them
+	Action      string
+	Success     *bool
+	IP          string
+	From        *time.Time
+	To          *time.Time
+	BeforeID    int // cursor, only rows older than this id
+}
+
+// where builds the WHERE clause for the filter, every value goes in as a parameter
+func (f AuditFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if f.UserID != nil {
+		add("user_id = ?", *f.UserID)
+	}
+	if f.AboutUserID != nil {
+		add("(user_id = ? OR target_user_id = ?)", *f.AboutUserID)
+	}
+	if f.Action != "" {
+		add("action = ?", f.Action)
+	}
+	if f.Success != nil {
+		add("success = ?", *f.Success)
+	}
+	if f.IP != "" {
+		add("ip_address = ?", f.IP)
+	}
+	if f.From != nil {
+		add("created_at >= ?", *f.From)
+	}
+	if f.To != nil {
+		add("created_at < ?", *f.To)
+	}
+	if f.BeforeID > 0 {
+		add("id < ?", f.BeforeID)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// ListAuditLogs returns one page of matching audit logs, newest first.
+// pages are keyed on id (pass the last id as BeforeID for the next page) so new rows never shift a page
+func (pg *Postgres) ListAuditLogs(ctx context.Context, filter AuditFilter, limit int) ([]AuditLog, error) {
+	where, args := filter.where()
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM audit_log
+		%s
+		ORDER BY id DESC
+		LIMIT $%d
+	`, auditLogColumns, where, len(args)+1)
+	args = append(args, limit)
+
+	rows, err := pg.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list audit logs: %w", err)
+	}
+	defer rows.Close()
+
+	var logs []AuditLog
+	for rows.Next() {
+		var log AuditLog
+		if err := scanAuditLog(rows, &log); err != nil {
+			return nil, fmt.Errorf("unable to scan audit log: %w", err)
+		}
+		logs = append(logs, log)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit logs: %w", err)
+	}
+
+	return logs, nil
+}
+
+// StreamAuditLogs calls fn for every matching audit log, newest first, without loading them all in memory.
+// an error from fn stops the stream and is returned
+func (pg *Postgres) StreamAuditLogs(ctx context.Context, filter AuditFilter, fn func(*AuditLog) error) error {
+	where, args := filter.where()
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM audit_log
+		%s
+		ORDER BY id DESC
+	`, auditLogColumns, where)
+
+	rows, err := pg.db.Query(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("unable to stream audit logs: %w", err)
+	}
+	defer rows.Close()
+
+	var log AuditLog
+	for rows.Next() {
+		if err := scanAuditLog(rows, &log); err != nil {
+			return fmt.Errorf("unable to scan audit log: %w", err)
+		}
+		if err := fn(&log); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating audit logs: %w", err)
+	}
+
+	return nil
+}
+
+// here we allocate how long the session would be
+// the ip and user agent are only recorded when the session is first created
+func (pg *Postgres) CreateSession(ctx context.Context, sessionID string, userID int, data string, expiresAt time.Time, ipAddress, userAgent string) error {
+	query := `
+		INSERT INTO sessions (id, user_id, data, expires_at, ip_address, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id) DO UPDATE SET data = $3, expires_at = $4, last_seen_at = CURRENT_TIMESTAMP
+	`
+
+	_, err := pg.db.Exec(ctx, query, sessionID, userID, data, expiresAt, ipAddress, userAgent)
+	if err != nil {
+		return fmt.Errorf("unable to create session: %w", err)
+	}
+
+	return nil
+}
+
+// GetSession retrieves a session by ID
+func (pg *Postgres) GetSession(ctx context.Context, sessionID string) (int, string, time.Time, error) {
+	query := `
+		SELECT user_id, data, expires_at
+		FROM sessions
+		WHERE id = $1 AND expires_at > CURRENT_TIMESTAMP
+	`
+
+	var userID int
+	var data string
+	var expiresAt time.Time
+
+	err := pg.db.QueryRow(ctx, query, sessionID).Scan(&userID, &data, &expiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, "", time.Time{}, fmt.Errorf("session not found or expired")
+		}
+		return 0, "", time.Time{}, fmt.Errorf("unable to get session: %w", err)
+	}
+
+	return userID, data, expiresAt, nil
+}
+
+// DeleteSession deletes a session
+func (pg *Postgres) DeleteSession(ctx context.Context, sessionID string) error {
+	query := `DELETE FROM sessions WHERE id = $1`
+
+	_, err := pg.db.Exec(ctx, query, sessionID)
+	if err != nil {
+		return fmt.Errorf("unable to delete session: %w", err)
+	}
+
+	return nil
+}
+
+// ListUserSessions returns the active sessions (devices) for a user, most recently used first
+func (pg *Postgres) ListUserSessions(ctx context.Context, userID int) ([]Session, error) {
+	query := `
+		SELECT id, user_id, COALESCE(ip_address, ''), COALESCE(user_agent, ''), created_at, COALESCE(last_seen_at, created_at), expires_at
+		FROM sessions
+		WHERE user_id = $1 AND expires_at > CURRENT_TIMESTAMP
+		ORDER BY last_seen_at DESC NULLS LAST
+	`
+
+	rows, err := pg.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.IPAddress,
+			&session.UserAgent,
+			&session.CreatedAt,
+			&session.LastSeenAt,
+			&session.ExpiresAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// DeleteUserSession revokes one session, the user id check stops people from revoking someone else's session
+func (pg *Postgres) DeleteUserSession(ctx context.Context, userID int, sessionID string) error {
+	query := `DELETE FROM sessions WHERE id = $1 AND user_id = $2`
+
+	result, err := pg.db.Exec(ctx, query, sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("unable to delete session: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("session not found")
+	}
+
+	return nil
+}
+
+// TouchSession slides a session's expiry forward and records that it was just used
+func (pg *Postgres) TouchSession(ctx context.Context, sessionID string, expiresAt time.Time) error {
+	query := `
+		UPDATE sessions
+		SET expires_at = $1, last_seen_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`
+
+	_, err := pg.db.Exec(ctx, query, expiresAt, sessionID)
+	if err != nil {
+		return fmt.Errorf("unable to touch session: %w", err)
 	}
 
 	return nil
 }
 
+// DeleteUserSessions signs a user out everywhere, exceptSessionID keeps one session alive (pass "" to delete them all)
+func (pg *Postgres) DeleteUserSessions(ctx context.Context, userID int, exceptSessionID string) (int64, error) {
+	query := `DELETE FROM sessions WHERE user_id = $1 AND id <> $2`
+
+	result, err := pg.db.Exec(ctx, query, userID, exceptSessionID)
+	if err != nil {
+		return 0, fmt.Errorf("unable to delete user sessions: %w", err)
+	}
+
+	log.Printf(" Deleted %d sessions for user ID: %d", result.RowsAffected(), userID)
+	return result.RowsAffected(), nil
+}
+
 // DeleteExpiredSessions deletes expired sessions (cleanup)
 func (pg *Postgres) DeleteExpiredSessions(ctx context.Context) error {
 	query := `DELETE FROM sessions WHERE expires_at < CURRENT_TIMESTAMP`
@@ -844,17 +3585,253 @@ type User struct {
 	EmailVerified bool      `json:"emailVerified"`
 	CreatedAt     time.Time `json:"createdAt"`
 	UpdatedAt     time.Time `json:"updatedAt"`
+	// set by an admin, a suspended user cant log in at all
+	SuspendedAt *time.Time `json:"suspendedAt,omitempty"`
+	// set by an admin, the user has to pick a new password through the reset email before logging in
+	PasswordResetRequired bool `json:"passwordResetRequired"`
+	// profile, filled in by the user on the profile page
+	PreferredLanguage   string     `json:"preferredLanguage"` // en, es or zh, empty until they pick one
+	Timezone            string     `json:"timezone"`
+	NativeLanguage      string     `json:"nativeLanguage"`
+	TargetInterviewDate *time.Time `json:"targetInterviewDate,omitempty"`
+	AvatarURL           string     `json:"avatarUrl"`
+	// set when the user asked to delete their account, the deletion job removes it after this time
+	DeletionScheduledFor *time.Time `json:"deletionScheduledFor,omitempty"`
+}
+
+// ProfileUpdate is a partial profile change, nil fields are left alone.
+// an empty TargetInterviewDate clears the date
+type ProfileUpdate struct {
+	FirstName           *string
+	LastName            *string
+	PreferredLanguage   *string
+	Timezone            *string
+	NativeLanguage      *string
+	TargetInterviewDate *string // YYYY-MM-DD
+	AvatarURL           *string
+}
+
+// UserIdentity is one OAuth login (google, github...) linked to a user
+type UserIdentity struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Provider   string     `json:"provider"`
+	ProviderID string     `json:"-"`
+	Email      string     `json:"email"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// UserDevice is a browser a user has logged in from, times are UTC
+type UserDevice struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"-"`
+	DeviceHash  string    `json:"-"`
+	UserAgent   string    `json:"userAgent"`
+	LastIP      string    `json:"lastIp"`
+	Latitude    *float64  `json:"-"`
+	Longitude   *float64  `json:"-"`
+	Location    string    `json:"location,omitempty"`
+	FirstSeenAt time.Time `json:"firstSeenAt"`
+	LastSeenAt  time.Time `json:"lastSeenAt"`
+}
+
+// APIToken is a personal access token, the token itself is never stored, times are UTC
+type APIToken struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"-"`
+	Name        string     `json:"name"`
+	TokenHash   string     `json:"-"`
+	TokenPrefix string     `json:"prefix"`
+	Scopes      []string   `json:"scopes"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	LastUsedAt  *time.Time `json:"lastUsedAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// OAuthClient is an app that signs users in with their VirgoAI account, public clients have no secret
+type OAuthClient struct {
+	ID               int       `json:"id"`
+	ClientID         string    `json:"clientId"`
+	ClientSecretHash string    `json:"-"`
+	Name             string    `json:"name"`
+	RedirectURIs     []string  `json:"redirectUris"`
+	FirstParty       bool      `json:"firstParty"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+// Public reports whether the client has no secret, those have to rely on pkce alone
+func (client *OAuthClient) Public() bool {
+	return client.ClientSecretHash == ""
+}
+
+// AuthorizationCode is a one time code from the authorize endpoint, swapped for tokens at the token endpoint
+type AuthorizationCode struct {
+	ID            int
+	CodeHash      string
+	ClientID      string
+	UserID        int
+	RedirectURI   string
+	Scopes        []string
+	Nonce         string
+	CodeChallenge string
+	AuthTime      time.Time
+	ExpiresAt     time.Time
+	Used          bool
+}
+
+// SigningKey is an RSA key that signs id tokens, the private key is PKCS#1 encrypted by oidc.KeySet
+type SigningKey struct {
+	ID         int
+	KID        string
+	PrivateKey string
+	CreatedAt  time.Time
+}
+
+// Organization is a school or college, its sso users have provider saml:<slug>
+type Organization struct {
+	ID   int    `json:"id"`
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+	// SCIM only provisions emails in these, sso has its own list on the connection
+	EmailDomains []string  `json:"emailDomains"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// SAMLConnection is an organization's identity provider, the attribute fields name the saml attributes we read
+type SAMLConnection struct {
+	ID                 int       `json:"id"`
+	OrganizationID     int       `json:"organizationId"`
+	IDPEntityID        string    `json:"idpEntityId"`
+	IDPMetadata        string    `json:"-"`
+	EmailDomains       []string  `json:"emailDomains"`
+	DefaultRole        string    `json:"defaultRole"`
+	EmailAttribute     string    `json:"emailAttribute"`
+	FirstNameAttribute string    `json:"firstNameAttribute"`
+	LastNameAttribute  string    `json:"lastNameAttribute"`
+	RoleAttribute      string    `json:"roleAttribute"`
+	Enabled            bool      `json:"enabled"`
+	CreatedAt          time.Time `json:"createdAt"`
+	UpdatedAt          time.Time `json:"updatedAt"`
+}
+
+// SCIMToken lets one organization's student information system call /scim/v2
+type SCIMToken struct {
+	ID             int        `json:"id"`
+	OrganizationID int        `json:"organizationId"`
+	Name           string     `json:"name"`
+	TokenHash      string     `json:"-"`
+	TokenPrefix    string     `json:"tokenPrefix"`
+	LastUsedAt     *time.Time `json:"lastUsedAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+// SCIMUser is a user on an organization's roster together with the account fields scim shows.
+// a suspended account is what scim calls inactive
+type SCIMUser struct {
+	UserID         int
+	OrganizationID int
+	UserName       string
+	ExternalID     string
+	Email          string
+	FirstName      string
+	LastName       string
+	Role           string
+	SuspendedAt    *time.Time
+	SuspendedBy    string // SuspendedByAdmin or SuspendedBySCIM, empty when not suspended
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// SCIMGroup is a class, section or cohort an organization pushed
+type SCIMGroup struct {
+	ID             int
+	OrganizationID int
+	DisplayName    string
+	ExternalID     string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// SCIMGroupMember is one user in a group
+type SCIMGroupMember struct {
+	UserID   int
+	UserName string
+}
+
+// RefreshToken is one of the mobile app's refresh tokens, tokens from the same sign in share a FamilyID
+type RefreshToken struct {
+	ID        int
+	FamilyID  string
+	UserID    int
+	TokenHash string
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// Media is one uploaded file, the keys point into the blob store and never leave the backend
+type Media struct {
+	ID           int       `json:"id"`
+	UserID       int       `json:"userId"`
+	Kind         string    `json:"kind"` // image, audio or pdf
+	ContentType  string    `json:"contentType"`
+	SizeBytes    int64     `json:"sizeBytes"`
+	ObjectKey    string    `json:"-"`
+	ThumbnailKey string    `json:"-"`
+	OriginalName string    `json:"originalName"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// Session is one logged in device, ID is the hashed session id we store (never the cookie value)
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"userId"`
+	IPAddress  string    `json:"ipAddress"`
+	UserAgent  string    `json:"userAgent"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// MFASettings is a user's TOTP enrollment
+type MFASettings struct {
+	UserID       int
+	Secret       string
+	Enabled      bool
+	LastUsedStep int64
+	ConfirmedAt  *time.Time
+}
+
+// WebAuthnCredential is one registered passkey
+type WebAuthnCredential struct {
+	ID              int        `json:"id"`
+	UserID          int        `json:"-"`
+	CredentialID    []byte     `json:"-"`
+	PublicKey       []byte     `json:"-"`
+	AttestationType string     `json:"-"`
+	AAGUID          []byte     `json:"-"`
+	SignCount       int64      `json:"-"`
+	Transports      []string   `json:"transports"`
+	BackupEligible  bool       `json:"backupEligible"`
+	BackupState     bool       `json:"synced"`
+	Name            string     `json:"name"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	LastUsedAt      *time.Time `json:"lastUsedAt,omitempty"`
 }
 
 // AuditLog represents an audit log entry
 type AuditLog struct {
 	ID            int       `json:"id"`
 	UserID        *int      `json:"userId,omitempty"` // Nullable
+	TargetUserID  *int      `json:"targetUserId,omitempty"`
 	Action        string    `json:"action"`
 	IPAddress     string    `json:"ipAddress"`
 	UserAgent     string    `json:"userAgent"`
 	Success       bool      `json:"success"`
 	FailureReason string    `json:"failureReason,omitempty"`
+	Details       string    `json:"details,omitempty"`
 	CreatedAt     time.Time `json:"createdAt"`
 }
 
@@ -0,0 +1,85 @@
+package main
+
+// the account deletion job. accounts whose grace period is over get deleted for good,
+// everything that belongs to them goes with the user row (ON DELETE CASCADE) except the audit log,
+// audit_log.user_id is ON DELETE SET NULL so the rows stay for security reviews.
+// uploaded files are not in the database so they are removed from the blob store here.
+//
+// this takes away the name, email, profile and everything else in the account, it is not full anonymization.
+// the audit rows keep the ip address and user agent of each request, and user_ref, which anyone holding
+// AUDIT_CHAIN_KEY can recompute from the old user id. those rows are hashed into the audit chain so they can't be
+// scrubbed afterwards, which is why nothing writes an email or name into audit details
+
+import (
+	"backend/database"
+	"backend/storage"
+	"context"
+	"log"
+	"time"
+)
+
+// how often the server looks for accounts to delete, and how many it deletes per run
+const (
+	deletionJobInterval = time.Hour
+	deletionJobBatch    = 100
+)
+
+// runDeletionJob purges due accounts every deletionJobInterval until ctx is cancelled
+func runDeletionJob(ctx context.Context, db *database.Postgres, store storage.BlobStore) {
+	ticker := time.NewTicker(deletionJobInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := purgeDeletedAccounts(ctx, db, store); err != nil {
+			log.Printf("❌ Account deletion job failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeDeletedAccounts deletes one batch of accounts whose grace period is over and returns how many it deleted
+func purgeDeletedAccounts(ctx context.Context, db *database.Postgres, store storage.BlobStore) (int, error) {
+	userIDs, err := db.ListUsersDueForDeletion(ctx, deletionJobBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, userID := range userIDs {
+		media, err := db.ListMediaByUser(ctx, userID)
+		if err != nil {
+			log.Printf("❌ Failed to list media of user ID %d: %v", userID, err)
+			continue
+		}
+
+		if err := db.DeleteUser(ctx, userID); err != nil {
+			log.Printf("❌ Failed to delete user ID %d: %v", userID, err)
+			continue
+		}
+
+		for _, m := range media {
+			for _, key := range []string{m.ObjectKey, m.ThumbnailKey} {
+				if key == "" {
+					continue
+				}
+				if err := store.Delete(ctx, key); err != nil {
+					log.Printf("⚠️  Failed to delete object %s: %v", key, err)
+				}
+			}
+		}
+
+		// no user id, this row shouldn't add another link back to them
+		db.CreateAuditLog(ctx, nil, "account_deleted", "", "deletion job", true, "")
+		deleted++
+	}
+
+	if deleted > 0 {
+		log.Printf("🗑️  Deleted %d accounts after their grace period", deleted)
+	}
+	return deleted, nil
+}
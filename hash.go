@@ -0,0 +1,14 @@
+// backend/utils/hash.go
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// HashToken returns the hex sha256 of a random token
+// use it for secrets we only ever need to compare (recovery codes, api tokens), never for passwords, those use bcrypt
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,325 @@
+// backend/handlers/identity_handlers.go
+package handlers
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/mailer"
+	"backend/utils"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+)
+
+// ============================================
+// LINKED ACCOUNTS (OAUTH IDENTITIES)
+// ============================================
+
+// the link request rides along in gothic's own session so it survives the trip to the provider and back,
+// it is only good for a few minutes so an abandoned link cant attach someone else's login later
+const (
+	oauthLinkKey = "link"
+	oauthLinkTTL = 10 * time.Minute
+)
+
+// IdentityResponse is one linked login on the settings page
+type IdentityResponse struct {
+	Provider   string     `json:"provider"`
+	Email      string     `json:"email"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// ListIdentitiesHandler lists the OAuth logins linked to the current user and whether they have a password
+// GET /api/auth/identities
+func (h *AuthHandler) ListIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	identities, err := h.db.ListUserIdentities(r.Context(), userID)
+	if err != nil {
+		log.Printf("❌ Failed to list identities: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to load linked accounts")
+		return
+	}
+
+	response := make([]IdentityResponse, 0, len(identities))
+	for _, identity := range identities {
+		response = append(response, IdentityResponse{
+			Provider:   identity.Provider,
+			Email:      identity.Email,
+			CreatedAt:  identity.CreatedAt,
+			LastUsedAt: identity.LastUsedAt,
+		})
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"identities":  response,
+		"hasPassword": user.PasswordHash != "",
+	})
+}
+
+// LinkIdentityHandler starts linking a provider to the logged in user
+// the frontend then sends the browser to the returned url, the callback finishes the link
+// POST /api/auth/identities/{provider}
+func (h *AuthHandler) LinkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	defer config.HoldProviders()()
+
+	provider := mux.Vars(r)["provider"]
+	if !knownProvider(w, provider) {
+		return
+	}
+
+	value := fmt.Sprintf("%d|%s|%d", userID, provider, time.Now().Add(oauthLinkTTL).Unix())
+	if err := gothic.StoreInSession(oauthLinkKey, value, r, w); err != nil {
+		log.Printf("❌ Failed to save link request: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to start linking")
+		return
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"url": "/api/auth/" + provider,
+	})
+}
+
+// UnlinkIdentityHandler removes a linked provider
+// it refuses to remove the last way the user can log in
+// DELETE /api/auth/identities/{provider}
+func (h *AuthHandler) UnlinkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+	provider := mux.Vars(r)["provider"]
+
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "User not found")
+		return
+	}
+	identities, err := h.db.ListUserIdentities(r.Context(), userID)
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	passkeys, err := h.db.GetWebAuthnCredentialsByUser(r.Context(), userID)
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	if user.PasswordHash == "" && len(passkeys) == 0 && len(identities) <= 1 {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "You can't remove your only way to log in")
+		return
+	}
+
+	if err := h.db.DeleteUserIdentity(r.Context(), userID, provider); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "This account is not linked")
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		"identity_unlinked",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		provider,
+	)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"message": "Account unlinked",
+	})
+}
+
+// oauthLinkRequest reads a pending link request for this provider, call it before gothic.CompleteUserAuth clears the session
+func oauthLinkRequest(r *http.Request, provider string) (int, bool) {
+	value, err := gothic.GetFromSession(oauthLinkKey, r)
+	if err != nil || value == "" {
+		return 0, false
+	}
+
+	parts := strings.Split(value, "|")
+	if len(parts) != 3 || parts[1] != provider {
+		return 0, false
+	}
+	userID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, false
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return 0, false
+	}
+
+	// if the auth-session cookie made it here too it has to be the same user (apple's form post wont carry it)
+	if current, ok := currentUserID(r); ok && current != userID {
+		return 0, false
+	}
+	return userID, true
+}
+
+// finishLink attaches the provider login to the user that asked for it and sends them back to their settings
+func (h *AuthHandler) finishLink(w http.ResponseWriter, r *http.Request, userID int, provider string, gothUser goth.User) {
+	settingsURL := config.GetFrontendURL() + "/Profile"
+
+	if existing, err := h.db.GetUserByProviderID(r.Context(), provider, gothUser.UserID); err == nil {
+		if existing.ID == userID {
+			http.Redirect(w, r, settingsURL+"?linked="+provider, http.StatusSeeOther)
+		} else {
+			// that google/github account already belongs to a different VirgoAI user
+			http.Redirect(w, r, settingsURL+"?error=identity_in_use", http.StatusSeeOther)
+		}
+		return
+	}
+
+	if err := h.db.CreateUserIdentity(r.Context(), userID, provider, gothUser.UserID, gothUser.Email); err != nil {
+		log.Printf("❌ Failed to link identity: %v", err)
+		http.Redirect(w, r, settingsURL+"?error=link_failed", http.StatusSeeOther)
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		"identity_linked",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		provider,
+	)
+
+	http.Redirect(w, r, settingsURL+"?linked="+provider, http.StatusSeeOther)
+}
+
+// oauthAccount finds the user an OAuth login belongs to, linking or creating one when needed
+// on failure it returns the error code the login page shows
+func (h *AuthHandler) oauthAccount(r *http.Request, provider string, gothUser goth.User) (*database.User, string) {
+	// already linked
+	if user, err := h.db.GetUserByProviderID(r.Context(), provider, gothUser.UserID); err == nil {
+		h.db.TouchUserIdentity(r.Context(), provider, gothUser.UserID)
+		return user, ""
+	}
+
+	// an account with the same email already exists, only link it automatically when both sides proved they own the address.
+	// otherwise someone could sign up with a victim's email first and get their google login attached to it
+	if gothUser.Email != "" {
+		if existing, err := h.db.GetUserByEmail(r.Context(), gothUser.Email); err == nil {
+			if !existing.EmailVerified || !providerEmailVerified(provider, gothUser) {
+				h.db.CreateAuditLog(
+					r.Context(),
+					&existing.ID,
+					"identity_link_refused",
+					utils.GetIPAddress(r),
+					r.UserAgent(),
+					false,
+					provider+" email not verified",
+				)
+				return nil, "account_exists"
+			}
+
+			if err := h.db.CreateUserIdentity(r.Context(), existing.ID, provider, gothUser.UserID, gothUser.Email); err != nil {
+				log.Printf("❌ Failed to link identity: %v", err)
+				return nil, "link_failed"
+			}
+			h.db.CreateAuditLog(
+				r.Context(),
+				&existing.ID,
+				"identity_linked",
+				utils.GetIPAddress(r),
+				r.UserAgent(),
+				true,
+				provider+" (same verified email)",
+			)
+			return existing, ""
+		}
+	}
+
+	// brand new user
+	log.Printf("👤 Creating new user from %s OAuth: %s", provider, gothUser.Email)
+
+	firstName := gothUser.FirstName
+	lastName := gothUser.LastName
+	if firstName == "" {
+		firstName = gothUser.Name
+	}
+
+	user, err := h.db.CreateUser(
+		r.Context(),
+		gothUser.Email,
+		"",
+		firstName,
+		lastName,
+		"student",       //  Default role for OAuth users
+		provider,        //  "google", "github", etc.
+		gothUser.UserID, //  OAuth provider's user ID
+	)
+	if err != nil {
+		log.Printf("❌ Failed to create OAuth user: %v", err)
+		return nil, "create_failed"
+	}
+
+	if err := h.db.CreateUserIdentity(r.Context(), user.ID, provider, gothUser.UserID, gothUser.Email); err != nil {
+		log.Printf("❌ Failed to create identity: %v", err)
+		return nil, "create_failed"
+	}
+
+	// Verify email automatically for OAuth users
+	h.db.VerifyEmail(r.Context(), user.ID)
+	h.sendEmail(r, user, mailer.TemplateWelcome, config.GetFrontendURL()+"/Courses")
+
+	// Log OAuth registration
+	h.db.CreateAuditLog(
+		r.Context(),
+		&user.ID,
+		"oauth_register",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		"",
+	)
+
+	return user, ""
+}
+
+// providerEmailVerified says if the provider vouches that the user owns the email it gave us
+func providerEmailVerified(provider string, gothUser goth.User) bool {
+	switch provider {
+	case "github":
+		// github only hands out verified addresses, both for the public profile email and the primary one
+		return true
+	case "google", "apple", "openid-connect":
+		switch verified := gothUser.RawData["email_verified"].(type) {
+		case bool:
+			return verified
+		case string:
+			return verified == "true"
+		}
+	}
+	// azure ad lets tenant admins set any email on an account, so it never counts as verified
+	return false
+}
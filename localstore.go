@@ -0,0 +1,180 @@
+// backend/storage/local.go
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// go's builtin mime table has no audio types and the server's /etc/mime.types may not either
+var audioTypes = map[string]string{
+	".mp3": "audio/mpeg",
+	".wav": "audio/wav",
+	".ogg": "audio/ogg",
+}
+
+// LocalStore keeps objects as files in one directory
+// it is also the http.Handler behind the signed urls it hands out
+type LocalStore struct {
+	dir     string
+	baseURL string
+	key     []byte
+}
+
+// NewLocalStore creates dir if needed, signingKey signs the download urls
+// without a key we make a random one, urls then stop working when the server restarts (they're short lived anyway)
+func NewLocalStore(dir, baseURL, signingKey string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("unable to create storage dir: %w", err)
+	}
+
+	key := []byte(signingKey)
+	if len(key) == 0 {
+		log.Println("⚠️  STORAGE_SIGNING_KEY is not set, media urls won't survive a restart")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("unable to generate signing key: %w", err)
+		}
+	}
+
+	return &LocalStore{dir: dir, baseURL: baseURL, key: key}, nil
+}
+
+// path turns a key into a file path, refusing anything that isn't a key we made
+func (s *LocalStore) path(key string) (string, error) {
+	if !ValidKey(key) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+// Put writes to a temp file first so a half written upload is never served
+func (s *LocalStore) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	dest, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return fmt.Errorf("unable to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write object: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write object: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return fmt.Errorf("unable to store object: %w", err)
+	}
+	return nil
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("unable to open object: %w", err)
+	}
+	return file, nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to delete object: %w", err)
+	}
+	return nil
+}
+
+// SignedURL is baseURL/key?expires=<unix>&sig=<hmac of key and expiry>
+func (s *LocalStore) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	if !ValidKey(key) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("sig", s.sign(key, expires))
+	return s.baseURL + "/" + key + "?" + query.Encode(), nil
+}
+
+func (s *LocalStore) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(key + "|" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ServeHTTP serves a file when the url's signature checks out and hasn't expired
+// the signature is the only check, whoever has the url can download the file until it expires
+func (s *LocalStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := path.Base(r.URL.Path)
+	expires := r.URL.Query().Get("expires")
+	sig := r.URL.Query().Get("sig")
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt || !hmac.Equal([]byte(sig), []byte(s.sign(key, expires))) {
+		http.Error(w, "link expired or invalid", http.StatusForbidden)
+		return
+	}
+
+	p, err := s.path(key)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	file, err := os.Open(p)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// the extension was picked from the sniffed type at upload, never from what the client claimed
+	contentType, ok := audioTypes[path.Ext(key)]
+	if !ok {
+		contentType = mime.TypeByExtension(path.Ext(key))
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	http.ServeContent(w, r, key, info.ModTime(), file)
+}
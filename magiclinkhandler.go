@@ -0,0 +1,166 @@
+// backend/handlers/magic_link_handlers.go
+package handlers
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/mailer"
+	"backend/models"
+	"backend/utils"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ============================================
+// PASSWORDLESS MAGIC LINK SIGN IN
+// ============================================
+
+// MagicLinkRequestHandler emails a single use sign in link
+// POST /api/auth/magic-link
+func (h *AuthHandler) MagicLinkRequestHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("📧 Magic link request received")
+
+	var req models.MagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+
+	if req.Email == "" {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Email is required")
+		return
+	}
+
+	// same reasoning as forgot-password, dont let anyone flood an inbox
+	if !h.throttleIP(w, r, "magic-link", magicLinkIPRate) ||
+		!h.throttleEmail(w, r, "magic-link", req.Email, magicLinkRate) {
+		return
+	}
+
+	user, err := h.db.GetUserByEmail(r.Context(), req.Email)
+	if err == nil {
+		if err := h.sendMagicLink(r, user); err != nil {
+			log.Printf("❌ Failed to create magic link token: %v", err)
+		} else {
+			log.Printf("📧 Magic link created for: %s", req.Email)
+		}
+	}
+
+	// Always return success (don't reveal if email exists)
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"message": "If an account exists with this email, you will receive a sign in link.",
+	})
+}
+
+// sendMagicLink emails the user a fresh sign in link, the login risk check uses it for step up too
+func (h *AuthHandler) sendMagicLink(r *http.Request, user *database.User) error {
+	// only the newest link works, an older email lying around in a shared inbox is useless
+	if err := h.db.InvalidateMagicLinkTokens(r.Context(), user.ID); err != nil {
+		log.Printf("❌ Failed to invalidate magic link tokens: %v", err)
+	}
+
+	// the email gets the token, the database only ever sees its hash
+	token := utils.GenerateSecureToken(32)
+	expiresAt := time.Now().Add(config.MagicLinkTTL)
+	if err := h.db.CreateMagicLinkToken(r.Context(), user.ID, utils.HashToken(token), expiresAt); err != nil {
+		return err
+	}
+
+	h.sendEmail(r, user, mailer.TemplateMagicLink, config.GetBackendURL()+"/api/auth/magic-link?token="+url.QueryEscape(token))
+	return nil
+}
+
+// MagicLinkConsumeHandler is where the emailed link points, it logs the user in and sends them to the frontend
+// it creates the same auth-session as LoginHandler
+// GET /api/auth/magic-link?token=...
+func (h *AuthHandler) MagicLinkConsumeHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("🔑 Magic link sign in received")
+
+	// this is a link clicked in an email, so every answer is a redirect to the login page instead of JSON
+	fail := func(code string) {
+		http.Redirect(w, r, config.GetFrontendURL()+"/Login?error="+code, http.StatusSeeOther)
+	}
+
+	if !h.throttleIP(w, r, "magic-link-consume", magicLinkIPRate) {
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		fail("magic_link_invalid")
+		return
+	}
+	tokenHash := utils.HashToken(token)
+
+	// Step 1: Validate token
+	userID, expiresAt, used, err := h.db.GetMagicLinkToken(r.Context(), tokenHash)
+	if err != nil || used {
+		fail("magic_link_invalid")
+		return
+	}
+
+	if time.Now().After(expiresAt) {
+		fail("magic_link_expired")
+		return
+	}
+
+	// Step 2: Burn the token before logging in so the same link cant be replayed
+	if err := h.db.MarkMagicLinkTokenAsUsed(r.Context(), tokenHash); err != nil {
+		fail("magic_link_invalid")
+		return
+	}
+
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		fail("magic_link_invalid")
+		return
+	}
+	if h.accountSuspended(r, user) {
+		fail("account_suspended")
+		return
+	}
+
+	// Step 3: Clicking the link proves they own the inbox, so the email counts as verified
+	if !user.EmailVerified {
+		if err := h.db.VerifyEmail(r.Context(), user.ID); err != nil {
+			log.Printf("⚠️  Failed to verify email: %v", err)
+		}
+		user.EmailVerified = true
+	}
+
+	// Step 4: The link replaces the password, not the second factor
+	if h.mfaEnabled(r, user.ID) {
+		if err := setMFAPending(w, r, user.ID, "magic_link"); err != nil {
+			log.Printf("❌ Failed to save mfa-pending state: %v", err)
+			fail("session_failed")
+			return
+		}
+		http.Redirect(w, r, config.GetFrontendURL()+"/Login?mfa=required", http.StatusSeeOther)
+		return
+	}
+
+	// Step 5: Create session
+	if err := h.startSession(w, r, user, "magic_link"); err != nil {
+		log.Printf("❌ Failed to save session: %v", err)
+		fail("session_failed")
+		return
+	}
+
+	// Step 6: Log successful login
+	h.db.CreateAuditLog(
+		r.Context(),
+		&user.ID,
+		"magic_link_login",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		"",
+	)
+
+	log.Printf("✅ Magic link login successful: %s", user.Email)
+
+	http.Redirect(w, r, config.GetFrontendURL()+"/auth/callback?Login=success", http.StatusSeeOther)
+}
@@ -0,0 +1,94 @@
+// backend/mailer/mailer.go
+package mailer
+
+// the mailer package is how the backend sends email. handlers only talk to the Mailer interface,
+// which one we actually use is picked from MAIL_DRIVER in the env file:
+//   smtp     -> any smtp server (gmail, mailgun, postfix...)
+//   sendgrid -> the sendgrid http api
+//   file     -> writes every email to MAIL_DIR as a .eml file, handy for local dev
+//   memory   -> keeps emails in a slice, used by tests
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+// Message is one email ready to send
+type Message struct {
+	To       string
+	Subject  string
+	TextBody string
+	HTMLBody string
+}
+
+// Mailer sends a message, every driver implements this
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewFromEnv builds the mailer chosen by MAIL_DRIVER, defaults to the file mailer so dev never sends real email
+func NewFromEnv() (Mailer, error) {
+	from := getEnv("MAIL_FROM", "VirgoAI <no-reply@virgoai.app>")
+	driver := getEnv("MAIL_DRIVER", "file")
+
+	switch driver {
+	case "smtp":
+		port, err := strconv.Atoi(getEnv("SMTP_PORT", "587"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMTP_PORT: %w", err)
+		}
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return nil, fmt.Errorf("SMTP_HOST is required for the smtp mail driver")
+		}
+		return &SMTPMailer{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}, nil
+
+	case "sendgrid":
+		apiKey := os.Getenv("SENDGRID_API_KEY")
+		if apiKey == "" {
+			return nil, fmt.Errorf("SENDGRID_API_KEY is required for the sendgrid mail driver")
+		}
+		return NewSendGridMailer(apiKey, from), nil
+
+	case "file":
+		return NewFileMailer(getEnv("MAIL_DIR", "tmp/mail"), from)
+
+	case "memory":
+		return NewMemoryMailer(), nil
+
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+	}
+}
+
+// SendTemplate renders one of the transactional templates and sends it
+func SendTemplate(ctx context.Context, m Mailer, to, name, locale string, data TemplateData) error {
+	msg, err := Render(name, locale, data)
+	if err != nil {
+		return err
+	}
+	msg.To = to
+
+	if err := m.Send(ctx, msg); err != nil {
+		return fmt.Errorf("unable to send %s email: %w", name, err)
+	}
+
+	log.Printf("📧 Sent %s email (%s) to: %s", name, locale, to)
+	return nil
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
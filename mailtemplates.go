@@ -0,0 +1,117 @@
+// backend/mailer/templates.go
+package mailer
+
+// every transactional email has a text and an html version per language:
+//   templates/<locale>/<name>.txt   -> plain text body, also defines the "subject" template
+//   templates/<locale>/<name>.html  -> html body
+// our learners mostly speak english, spanish or mandarin so those are the locales we ship
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+// template names, handlers should use these instead of typing the strings
+const (
+	TemplatePasswordReset   = "password_reset"
+	TemplateVerifyEmail     = "verify_email"
+	TemplateWelcome         = "welcome"
+	TemplateAccountLocked   = "account_locked"
+	TemplateMagicLink       = "magic_link"
+	TemplateAccountDeletion = "account_deletion"
+	TemplateNewSignIn       = "new_sign_in"
+)
+
+// DefaultLocale is used when we cant match the user's language
+const DefaultLocale = "en"
+
+// SupportedLocales are the languages we have templates for
+var SupportedLocales = []string{"en", "es", "zh"}
+
+var templateNames = []string{TemplatePasswordReset, TemplateVerifyEmail, TemplateWelcome, TemplateAccountLocked, TemplateMagicLink, TemplateAccountDeletion, TemplateNewSignIn}
+
+// TemplateData is what the templates can use
+type TemplateData struct {
+	AppName   string
+	FirstName string
+	Link      string
+	// only the new_sign_in email uses these
+	Device   string
+	Location string
+	Time     string
+}
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// parsed once at startup, a broken template should stop the server from booting instead of failing on the first reset email
+var templates = mustParseTemplates()
+
+func mustParseTemplates() map[string]emailTemplate {
+	parsed := map[string]emailTemplate{}
+	for _, locale := range SupportedLocales {
+		for _, name := range templateNames {
+			base := "templates/" + locale + "/" + name
+			text := texttemplate.Must(texttemplate.ParseFS(templateFiles, base+".txt"))
+			if text.Lookup("subject") == nil {
+				panic(fmt.Sprintf("mailer: %s.txt does not define a subject", base))
+			}
+			html := htmltemplate.Must(htmltemplate.ParseFS(templateFiles, base+".html"))
+			parsed[locale+"/"+name] = emailTemplate{text: text, html: html}
+		}
+	}
+	return parsed
+}
+
+// Render fills in a template for the given locale, falling back to english
+func Render(name, locale string, data TemplateData) (Message, error) {
+	if data.AppName == "" {
+		data.AppName = "VirgoAI"
+	}
+
+	tmpl, ok := templates[NormalizeLocale(locale)+"/"+name]
+	if !ok {
+		return Message{}, fmt.Errorf("unknown email template %q", name)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, fmt.Errorf("unable to render %s subject: %w", name, err)
+	}
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return Message{}, fmt.Errorf("unable to render %s text: %w", name, err)
+	}
+	if err := tmpl.html.Execute(&html, data); err != nil {
+		return Message{}, fmt.Errorf("unable to render %s html: %w", name, err)
+	}
+
+	return Message{
+		Subject:  strings.TrimSpace(subject.String()),
+		TextBody: strings.TrimSpace(text.String()) + "\n",
+		HTMLBody: html.String(),
+	}, nil
+}
+
+// NormalizeLocale turns things like "es-MX", "zh-Hans-CN" or a whole Accept-Language header
+// ("es-MX,es;q=0.9,en;q=0.8") into one of our supported locales
+func NormalizeLocale(value string) string {
+	for _, part := range strings.Split(value, ",") {
+		tag := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		primary := strings.ToLower(strings.SplitN(strings.ReplaceAll(tag, "_", "-"), "-", 2)[0])
+		for _, locale := range SupportedLocales {
+			if primary == locale {
+				return locale
+			}
+		}
+	}
+	return DefaultLocale
+}
@@ -5,7 +5,14 @@ import (
 	"backend/config"
 	"backend/database"
 	"backend/handlers"
+	"backend/mailer"
 	"backend/middleware"
+	"backend/models"
+	"backend/oidc"
+	"backend/ratelimit"
+	"backend/risk"
+	"backend/sso"
+	"backend/storage"
 	"context"
 	"fmt"
 	"log"
@@ -60,26 +67,77 @@ func main() {
 		log.Fatal("Failed to ping databse")
 	}
 	log.Println("Databse connection successful")
-	// here we create the tables
+	// keys the user references in the tamper evident audit log, it must never change once set
+	dbConn.SetAuditChainKey(os.Getenv("AUDIT_CHAIN_KEY"))
+	// if we were started as "server migrate ..." run the cli command and exit instead of serving
+	if len(os.Args) > 1 {
+		if err := runCommand(context.Background(), dbConn, os.Args[1:]); err != nil {
+			log.Fatalf("Command failed: %v", err)
+		}
+		return
+	}
 
-	if err := dbConn.CreateTables(context.Background()); err != nil {
-		log.Fatal("Failed to create tabels", err)
+	// here we bring the schema up to date, every replica can run this since the migrations take a lock
+	if err := dbConn.Migrate(context.Background()); err != nil {
+		log.Fatal("Failed to run migrations ", err)
 	}
 	log.Println("Databse tables are ready to go")
 
 	// here ill refer to OAuth config file here
 	// call the name of the file and function that comes with
-	config.InitAuth()
-	//config.GetSessionStore()
+	if err := config.InitAuth(); err != nil {
+		log.Fatalf("Failed to set up OAuth providers: %v", err)
+	}
+	config.InitSessionStore(dbConn)
+	if err := config.InitWebAuthn(); err != nil {
+		log.Fatalf("Failed to set up passkeys: %v", err)
+	}
 	log.Print("OAuth is ready to go")
 	// for this instance i am going to make router here, for future use ill put the routes in the routes folder
 
+	// the mailer is picked by MAIL_DRIVER (smtp, sendgrid, file or memory)
+	mail, err := mailer.NewFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to set up mailer: %v", err)
+	}
+
+	// login throttling buckets, RATE_LIMIT_STORE=postgres shares them between replicas
+	limiter, err := ratelimit.NewFromEnv(dbConn)
+	if err != nil {
+		log.Fatalf("Failed to set up rate limiter: %v", err)
+	}
+
+	// uploaded files, STORAGE_DRIVER picks local disk or an s3 bucket
+	store, err := storage.NewFromEnv(config.GetBackendURL())
+	if err != nil {
+		log.Fatalf("Failed to set up file storage: %v", err)
+	}
+
+	// new device and impossible travel checks on login, GEOIP_DB_PATH turns on the location based ones
+	riskEvaluator, err := risk.NewFromEnv(dbConn)
+	if err != nil {
+		log.Fatalf("Failed to set up login risk checks: %v", err)
+	}
+
+	// keys for the id tokens partner apps get from "Sign in with VirgoAI", created and rotated in the database,
+	// OIDC_KEY_ENCRYPTION_KEY encrypts them there
+	oidcKeys, err := oidc.NewKeySetFromEnv(dbConn)
+	if err != nil {
+		log.Fatalf("Failed to set up OIDC signing keys: %v", err)
+	}
+
+	// SAML single sign on for schools, SAML_SP_KEY_PATH and SAML_SP_CERT_PATH hold our key pair
+	samlProvider, err := sso.NewSAMLFromEnv(config.GetBackendURL())
+	if err != nil {
+		log.Fatalf("Failed to set up SAML: %v", err)
+	}
+
 	// so here we will start created the new router
 	router := mux.NewRouter()
 	// all the authhandlers are reffered through dot notation
-	AuthHandler := handlers.NewAuthHandler(dbConn)
+	AuthHandler := handlers.NewAuthHandler(dbConn, mail, limiter, store, riskEvaluator, oidcKeys, samlProvider)
 	// the setupRoutes(routes reffers to the mux router, then the handler)
-	setupRoutes(router, AuthHandler)
+	setupRoutes(router, AuthHandler, dbConn, store, oidcKeys)
 	// Middlewares can be added to a router using Router.Use():
 	// follow this strucutre routes.Use(name of file.methodname)
 	//routes.Use(middleware.LoggingMiddleware)
@@ -111,6 +169,10 @@ func main() {
 			log.Fatalf("Server failed: %v", err)
 		}
 	}()
+
+	// deletes accounts once their deletion grace period is over, stops with the server
+	jobCtx, stopJobs := context.WithCancel(context.Background())
+	go runDeletionJob(jobCtx, dbConn, store)
 	//
 	// this means control c to stop for the server to gracefully shutdown
 	c := make(chan os.Signal, 1)
@@ -122,17 +184,33 @@ func main() {
 	ctx, cancel := context.WithTimeoutCause(context.Background(), 10*time.Second, err)
 	defer cancel()
 	srv.Shutdown(ctx)
+	stopJobs()
+	riskEvaluator.Close()
 
 	// cleanup expried tokens and sessions
 	dbConn.DeleteExpiredPasswordResetTokens((context.Background()))
+	dbConn.DeleteExpiredEmailVerificationTokens(context.Background())
+	dbConn.DeleteExpiredMagicLinkTokens(context.Background())
+	dbConn.DeleteExpiredAPITokens(context.Background())
+	dbConn.DeleteExpiredRefreshTokens(context.Background())
+	dbConn.DeleteExpiredAuthorizationCodes(context.Background())
+	dbConn.DeleteExpiredSAMLRequests(context.Background())
+	dbConn.DeleteExpiredWebAuthnChallenges(context.Background())
+	oidcKeys.Cleanup(context.Background())
 	dbConn.DeleteExpiredSessions(context.Background())
+	dbConn.DeleteStaleRateLimitBuckets(context.Background(), 24*time.Hour)
 	dbConn.Close()
 }
 
 // create a subrouter function
-func setupRoutes(router *mux.Router, authHandler *handlers.AuthHandler) {
+func setupRoutes(router *mux.Router, authHandler *handlers.AuthHandler, dbConn *database.Postgres, store storage.BlobStore, keys *oidc.KeySet) {
 	// API prefix
 	api := router.PathPrefix("/api").Subrouter()
+	// cookie authenticated posts need the frontend's origin and csrf token, identity providers posting back are checked by their state instead
+	api.Use(middleware.CSRF(
+		"/api/auth/saml/{org}/acs",
+		"/api/auth/{provider}/callback",
+	))
 
 	// Health check
 	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -141,25 +219,175 @@ func setupRoutes(router *mux.Router, authHandler *handlers.AuthHandler) {
 		fmt.Fprint(w, `{"status":"healthy","service":"auth-api"}`)
 	}).Methods("GET")
 
+	// OpenID Connect provider, partner apps find everything else through discovery
+	router.HandleFunc("/.well-known/openid-configuration", authHandler.DiscoveryHandler).Methods("GET")
+	router.HandleFunc("/oauth2/jwks", authHandler.JWKSHandler).Methods("GET")
+	router.HandleFunc("/oauth2/authorize", authHandler.AuthorizeHandler).Methods("GET")
+	router.HandleFunc("/oauth2/token", authHandler.TokenHandler).Methods("POST")
+	router.HandleFunc("/oauth2/userinfo", authHandler.UserInfoHandler).Methods("GET", "POST")
+
+	// SCIM 2.0 provisioning, student information systems push their rosters with an organization's bearer token
+	scimRoutes := router.PathPrefix("/scim/v2").Subrouter()
+	scimRoutes.Use(middleware.SCIMAuth(dbConn))
+	scimRoutes.HandleFunc("/ServiceProviderConfig", authHandler.SCIMServiceProviderConfigHandler).Methods("GET")
+	scimRoutes.HandleFunc("/Users", authHandler.ListSCIMUsersHandler).Methods("GET")
+	scimRoutes.HandleFunc("/Users", authHandler.CreateSCIMUserHandler).Methods("POST")
+	scimRoutes.HandleFunc("/Users/{id}", authHandler.GetSCIMUserHandler).Methods("GET")
+	scimRoutes.HandleFunc("/Users/{id}", authHandler.ReplaceSCIMUserHandler).Methods("PUT")
+	scimRoutes.HandleFunc("/Users/{id}", authHandler.PatchSCIMUserHandler).Methods("PATCH")
+	scimRoutes.HandleFunc("/Users/{id}", authHandler.DeleteSCIMUserHandler).Methods("DELETE")
+	scimRoutes.HandleFunc("/Groups", authHandler.ListSCIMGroupsHandler).Methods("GET")
+	scimRoutes.HandleFunc("/Groups", authHandler.CreateSCIMGroupHandler).Methods("POST")
+	scimRoutes.HandleFunc("/Groups/{id}", authHandler.GetSCIMGroupHandler).Methods("GET")
+	scimRoutes.HandleFunc("/Groups/{id}", authHandler.ReplaceSCIMGroupHandler).Methods("PUT")
+	scimRoutes.HandleFunc("/Groups/{id}", authHandler.PatchSCIMGroupHandler).Methods("PATCH")
+	scimRoutes.HandleFunc("/Groups/{id}", authHandler.DeleteSCIMGroupHandler).Methods("DELETE")
+	scimRoutes.HandleFunc("/Bulk", authHandler.SCIMBulkHandler).Methods("POST")
+
+	// csrf token for the frontend, sent back in X-CSRF-Token
+	api.HandleFunc("/auth/csrf", authHandler.CSRFTokenHandler).Methods("GET")
+
 	// Auth routes - Registration & Login
 	api.HandleFunc("/auth/register", authHandler.RegisterHandler).Methods("POST")
 	api.HandleFunc("/auth/login", authHandler.LoginHandler).Methods("POST")
 
-	// OAuth routes
-	api.HandleFunc("/auth/{provider}", authHandler.BeginAuthHandler).Methods("GET")
-	api.HandleFunc("/auth/{provider}/callback", authHandler.CallbackHandler).Methods("GET")
-
 	// Password reset routes
 	api.HandleFunc("/auth/forgot-password", authHandler.ForgotPasswordHandler).Methods("POST")
 	api.HandleFunc("/auth/reset-password", authHandler.ResetPasswordHandler).Methods("POST")
 
+	// Two-factor login step, the user isn't logged in yet so this can't be protected
+	api.HandleFunc("/auth/mfa/verify", authHandler.MFAVerifyHandler).Methods("POST")
+
+	// Passkey login (public, the ceremony itself proves who you are)
+	api.HandleFunc("/auth/passkeys/login/begin", authHandler.PasskeyLoginBeginHandler).Methods("POST")
+	api.HandleFunc("/auth/passkeys/login/finish", authHandler.PasskeyLoginFinishHandler).Methods("POST")
+
+	// Passwordless sign in, the GET is the link in the email
+	api.HandleFunc("/auth/magic-link", authHandler.MagicLinkRequestHandler).Methods("POST")
+	api.HandleFunc("/auth/magic-link", authHandler.MagicLinkConsumeHandler).Methods("GET")
+
+	// SAML single sign on, one identity provider per organization. the acs is posted to by the idp
+	api.HandleFunc("/auth/saml/{org}/metadata", authHandler.SAMLMetadataHandler).Methods("GET")
+	api.HandleFunc("/auth/saml/{org}/login", authHandler.SAMLLoginHandler).Methods("GET")
+	api.HandleFunc("/auth/saml/{org}/acs", authHandler.SAMLACSHandler).Methods("POST")
+
+	// Mobile app tokens, the app signs in with the routes above and swaps its cookie for an access and refresh token
+	api.HandleFunc("/auth/token", authHandler.MobileTokenHandler).Methods("POST")
+	api.HandleFunc("/auth/token/revoke", authHandler.MobileRevokeHandler).Methods("POST")
+
+	// OAuth providers the login page should offer
+	api.HandleFunc("/auth/providers", authHandler.ProvidersHandler).Methods("GET")
+
+	// Email verification routes
+	api.HandleFunc("/auth/verify-email", authHandler.VerifyEmailHandler).Methods("POST")
+	api.HandleFunc("/auth/resend-verification", authHandler.ResendVerificationHandler).Methods("POST")
+
+	// Uploaded files, avatars are public so they work in an img tag.
+	// with local storage the backend serves the files itself, the signature in the url is the only check
+	api.HandleFunc("/avatars/{id}", authHandler.AvatarHandler).Methods("GET")
+	if local, ok := store.(*storage.LocalStore); ok {
+		api.Handle("/media/files/{key}", local).Methods("GET", "HEAD")
+	}
+
 	// Protected routes
 	protected := api.PathPrefix("").Subrouter()
-	protected.Use(middleware.AuthMiddleware)
+	// scripts send Authorization: Bearer with a personal access token instead of the cookie, the mobile app its JWT access token
+	protected.Use(middleware.BearerAuth(dbConn, keys, middleware.AuthMiddleware))
+	// puts the user and their role in the request context for the permission checks below
+	protected.Use(middleware.LoadUser(dbConn))
+
+	// an API token only gets /auth/me and the routes that check one of its scopes (RequirePermission),
+	// everything else here only works with a real sign in (the mobile app's JWT is a real sign in)
+	sessionOnly := middleware.SessionOnly
 
 	protected.HandleFunc("/auth/me", authHandler.GetCurrentUserHandler).Methods("GET")
-	protected.HandleFunc("/auth/logout", authHandler.LogoutHandler).Methods("POST")
-	protected.HandleFunc("/auth/change-password", authHandler.ChangePasswordHandler).Methods("POST")
+	protected.Handle("/auth/logout", sessionOnly(http.HandlerFunc(authHandler.LogoutHandler))).Methods("POST")
+	protected.Handle("/auth/change-password", sessionOnly(http.HandlerFunc(authHandler.ChangePasswordHandler))).Methods("POST")
+
+	// The user's own security activity (logins, password changes...)
+	protected.Handle("/auth/activity", sessionOnly(http.HandlerFunc(authHandler.ActivityHandler))).Methods("GET")
+
+	// Device / session management
+	protected.Handle("/auth/sessions", sessionOnly(http.HandlerFunc(authHandler.ListSessionsHandler))).Methods("GET")
+	protected.Handle("/auth/sessions", sessionOnly(http.HandlerFunc(authHandler.RevokeOtherSessionsHandler))).Methods("DELETE")
+	protected.Handle("/auth/sessions/{id}", sessionOnly(http.HandlerFunc(authHandler.RevokeSessionHandler))).Methods("DELETE")
+
+	// Two-factor settings
+	protected.Handle("/auth/mfa", sessionOnly(http.HandlerFunc(authHandler.MFAStatusHandler))).Methods("GET")
+	protected.Handle("/auth/mfa/setup", sessionOnly(http.HandlerFunc(authHandler.MFASetupHandler))).Methods("POST")
+	protected.Handle("/auth/mfa/confirm", sessionOnly(http.HandlerFunc(authHandler.MFAConfirmHandler))).Methods("POST")
+	protected.Handle("/auth/mfa/disable", sessionOnly(http.HandlerFunc(authHandler.MFADisableHandler))).Methods("POST")
+	protected.Handle("/auth/mfa/recovery-codes", sessionOnly(http.HandlerFunc(authHandler.MFARecoveryCodesHandler))).Methods("POST")
+
+	// Passkeys
+	protected.Handle("/auth/passkeys", sessionOnly(http.HandlerFunc(authHandler.ListPasskeysHandler))).Methods("GET")
+	protected.Handle("/auth/passkeys/register/begin", sessionOnly(http.HandlerFunc(authHandler.PasskeyRegisterBeginHandler))).Methods("POST")
+	protected.Handle("/auth/passkeys/register/finish", sessionOnly(http.HandlerFunc(authHandler.PasskeyRegisterFinishHandler))).Methods("POST")
+	protected.Handle("/auth/passkeys/{id}", sessionOnly(http.HandlerFunc(authHandler.DeletePasskeyHandler))).Methods("DELETE")
+
+	// Profile
+	protected.Handle("/users/me", sessionOnly(http.HandlerFunc(authHandler.GetProfileHandler))).Methods("GET")
+	protected.Handle("/users/me", sessionOnly(http.HandlerFunc(authHandler.UpdateProfileHandler))).Methods("PATCH")
+	protected.Handle("/users/me/avatar", sessionOnly(http.HandlerFunc(authHandler.UploadAvatarHandler))).Methods("POST")
+
+	// Personal data export and account deletion
+	protected.Handle("/users/me/export", sessionOnly(http.HandlerFunc(authHandler.ExportDataHandler))).Methods("POST")
+	protected.Handle("/users/me/deletion", sessionOnly(http.HandlerFunc(authHandler.RequestAccountDeletionHandler))).Methods("POST")
+	protected.Handle("/users/me/deletion", sessionOnly(http.HandlerFunc(authHandler.CancelAccountDeletionHandler))).Methods("DELETE")
+
+	// Uploads
+	protected.Handle("/media", sessionOnly(http.HandlerFunc(authHandler.UploadMediaHandler))).Methods("POST")
+	protected.Handle("/media", sessionOnly(http.HandlerFunc(authHandler.ListMediaHandler))).Methods("GET")
+	protected.Handle("/media/{id}", sessionOnly(http.HandlerFunc(authHandler.GetMediaHandler))).Methods("GET")
+	protected.Handle("/media/{id}", sessionOnly(http.HandlerFunc(authHandler.DeleteMediaHandler))).Methods("DELETE")
+
+	// Personal access tokens, managing them needs a real sign in
+	protected.Handle("/auth/tokens", sessionOnly(http.HandlerFunc(authHandler.ListAPITokensHandler))).Methods("GET")
+	protected.Handle("/auth/tokens", sessionOnly(http.HandlerFunc(authHandler.CreateAPITokenHandler))).Methods("POST")
+	protected.Handle("/auth/tokens/{id}", sessionOnly(http.HandlerFunc(authHandler.RevokeAPITokenHandler))).Methods("DELETE")
+
+	// Consent screen for apps using "Sign in with VirgoAI"
+	protected.Handle("/oauth2/consent", sessionOnly(http.HandlerFunc(authHandler.ConsentDetailsHandler))).Methods("GET")
+	protected.Handle("/oauth2/consent", sessionOnly(http.HandlerFunc(authHandler.ConsentHandler))).Methods("POST")
+
+	// Linked accounts (google, github...)
+	protected.Handle("/auth/identities", sessionOnly(http.HandlerFunc(authHandler.ListIdentitiesHandler))).Methods("GET")
+	protected.Handle("/auth/identities/{provider}", sessionOnly(http.HandlerFunc(authHandler.LinkIdentityHandler))).Methods("POST")
+	protected.Handle("/auth/identities/{provider}", sessionOnly(http.HandlerFunc(authHandler.UnlinkIdentityHandler))).Methods("DELETE")
+
+	// Admin routes, anyone who can see users gets in, routes that change something also need their own permission.
+	// one subrouter on purpose, two subrouters on the same /admin prefix make mux answer 405 for the second one's routes
+	admin := protected.PathPrefix("/admin").Subrouter()
+	admin.Use(middleware.RequirePermission(models.PermUsersView))
+	manage := middleware.RequirePermission(models.PermUsersManage)
+	admin.HandleFunc("/users", authHandler.ListUsersHandler).Methods("GET")
+	admin.HandleFunc("/users/{id}", authHandler.GetUserHandler).Methods("GET")
+	admin.Handle("/users/{id}", manage(http.HandlerFunc(authHandler.DeleteUserHandler))).Methods("DELETE")
+	admin.Handle("/users/{id}/suspend", manage(http.HandlerFunc(authHandler.SuspendUserHandler))).Methods("POST")
+	admin.Handle("/users/{id}/unsuspend", manage(http.HandlerFunc(authHandler.UnsuspendUserHandler))).Methods("POST")
+	admin.Handle("/users/{id}/force-password-reset", manage(http.HandlerFunc(authHandler.ForcePasswordResetHandler))).Methods("POST")
+	admin.Handle("/users/{id}/unlock", manage(http.HandlerFunc(authHandler.UnlockUserHandler))).Methods("POST")
+	admin.Handle("/users/{id}/sessions", manage(http.HandlerFunc(authHandler.ListUserSessionsHandler))).Methods("GET")
+	admin.Handle("/users/{id}/sessions", manage(http.HandlerFunc(authHandler.RevokeUserSessionsHandler))).Methods("DELETE")
+	admin.Handle("/users/{id}/role", middleware.RequirePermission(models.PermRolesAssign)(http.HandlerFunc(authHandler.ChangeUserRoleHandler))).Methods("PUT")
+	admin.Handle("/audit", middleware.RequirePermission(models.PermAuditView)(http.HandlerFunc(authHandler.AdminAuditHandler))).Methods("GET")
+	admin.Handle("/audit/verify", middleware.RequirePermission(models.PermAuditView)(http.HandlerFunc(authHandler.VerifyAuditChainHandler))).Methods("GET")
+	orgs := middleware.RequirePermission(models.PermOrgsManage)
+	admin.Handle("/organizations", orgs(http.HandlerFunc(authHandler.ListOrganizationsHandler))).Methods("GET")
+	admin.Handle("/organizations", orgs(http.HandlerFunc(authHandler.CreateOrganizationHandler))).Methods("POST")
+	admin.Handle("/organizations/{slug}/email-domains", orgs(http.HandlerFunc(authHandler.PutOrganizationEmailDomainsHandler))).Methods("PUT")
+	admin.Handle("/organizations/{slug}/saml", orgs(http.HandlerFunc(authHandler.GetSAMLConnectionHandler))).Methods("GET")
+	admin.Handle("/organizations/{slug}/saml", orgs(http.HandlerFunc(authHandler.PutSAMLConnectionHandler))).Methods("PUT")
+	admin.Handle("/organizations/{slug}/saml", orgs(http.HandlerFunc(authHandler.DeleteSAMLConnectionHandler))).Methods("DELETE")
+	admin.Handle("/organizations/{slug}/scim-tokens", orgs(http.HandlerFunc(authHandler.ListSCIMTokensHandler))).Methods("GET")
+	admin.Handle("/organizations/{slug}/scim-tokens", orgs(http.HandlerFunc(authHandler.CreateSCIMTokenHandler))).Methods("POST")
+	admin.Handle("/organizations/{slug}/scim-tokens/{id}", orgs(http.HandlerFunc(authHandler.DeleteSCIMTokenHandler))).Methods("DELETE")
+
+	// OAuth routes
+	// these go last because /auth/{provider} would otherwise swallow GET routes like /auth/me and /auth/sessions
+	// the callback also takes POST because apple sends it as a form post
+	api.HandleFunc("/auth/{provider}", authHandler.BeginAuthHandler).Methods("GET")
+	api.HandleFunc("/auth/{provider}/callback", authHandler.CallbackHandler).Methods("GET", "POST")
 
 	log.Println(" Routes configured")
 }
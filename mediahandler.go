@@ -0,0 +1,410 @@
+// backend/handlers/media_handlers.go
+package handlers
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/storage"
+	"backend/utils"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// ============================================
+// MEDIA UPLOADS
+// ============================================
+
+// mediaType is what we accept for one sniffed content type
+type mediaType struct {
+	kind    string
+	ext     string
+	maxSize int64
+}
+
+// the type comes from http.DetectContentType on the first bytes of the file, never from the filename or the browser
+var mediaTypes = map[string]mediaType{
+	"image/jpeg":      {kind: "image", ext: ".jpg", maxSize: 5 << 20},
+	"image/png":       {kind: "image", ext: ".png", maxSize: 5 << 20},
+	"image/gif":       {kind: "image", ext: ".gif", maxSize: 5 << 20},
+	"image/webp":      {kind: "image", ext: ".webp", maxSize: 5 << 20},
+	"audio/mpeg":      {kind: "audio", ext: ".mp3", maxSize: 20 << 20},
+	"audio/wave":      {kind: "audio", ext: ".wav", maxSize: 20 << 20},
+	"application/ogg": {kind: "audio", ext: ".ogg", maxSize: 20 << 20},
+	"application/pdf": {kind: "pdf", ext: ".pdf", maxSize: 10 << 20},
+}
+
+const (
+	// the biggest file we take plus room for the multipart headers, anything above is cut off before we read it
+	maxUploadBody = 21 << 20
+	// multipart parts above this go to a temp file instead of memory
+	uploadMemory  = 1 << 20
+	thumbnailSize = 256
+)
+
+// MediaResponse is an upload plus download urls that expire after config.MediaURLTTL
+type MediaResponse struct {
+	database.Media
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnailUrl,omitempty"`
+}
+
+// UploadMediaHandler takes one file in the multipart field "file"
+// POST /api/media
+func (h *AuthHandler) UploadMediaHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	media, status, message := h.storeUpload(w, r, userID, "")
+	if media == nil {
+		utils.ErrorResponseJSON(w, status, message)
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		"media_uploaded",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		fmt.Sprintf("media %d (%s, %d bytes)", media.ID, media.ContentType, media.SizeBytes),
+	)
+
+	response, err := h.mediaResponse(r.Context(), media)
+	if err != nil {
+		log.Printf("❌ Failed to sign media url: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to load upload")
+		return
+	}
+
+	utils.ResponseJSON(w, http.StatusCreated, response)
+}
+
+// ListMediaHandler lists the current user's uploads
+// GET /api/media
+func (h *AuthHandler) ListMediaHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	media, err := h.db.ListMediaByUser(r.Context(), userID)
+	if err != nil {
+		log.Printf("❌ Failed to list media: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to load uploads")
+		return
+	}
+
+	response := make([]MediaResponse, 0, len(media))
+	for i := range media {
+		item, err := h.mediaResponse(r.Context(), &media[i])
+		if err != nil {
+			log.Printf("❌ Failed to sign media url: %v", err)
+			utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to load uploads")
+			return
+		}
+		response = append(response, item)
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, response)
+}
+
+// GetMediaHandler returns one upload with fresh download urls
+// GET /api/media/{id}
+func (h *AuthHandler) GetMediaHandler(w http.ResponseWriter, r *http.Request) {
+	media, ok := h.ownMedia(w, r)
+	if !ok {
+		return
+	}
+
+	response, err := h.mediaResponse(r.Context(), media)
+	if err != nil {
+		log.Printf("❌ Failed to sign media url: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to load upload")
+		return
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, response)
+}
+
+// DeleteMediaHandler deletes an upload and its files
+// DELETE /api/media/{id}
+func (h *AuthHandler) DeleteMediaHandler(w http.ResponseWriter, r *http.Request) {
+	media, ok := h.ownMedia(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.db.DeleteMedia(r.Context(), media.ID); err != nil {
+		log.Printf("❌ Failed to delete media: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to delete upload")
+		return
+	}
+	h.deleteMediaObjects(r.Context(), media)
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&media.UserID,
+		"media_deleted",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		fmt.Sprintf("media %d", media.ID),
+	)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"message": "Upload deleted",
+	})
+}
+
+// UploadAvatarHandler uploads an image and makes it the user's avatar, the old avatar is deleted
+// POST /api/users/me/avatar
+func (h *AuthHandler) UploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	media, status, message := h.storeUpload(w, r, userID, "image")
+	if media == nil {
+		utils.ErrorResponseJSON(w, status, message)
+		return
+	}
+
+	// the avatar url never changes for a user, ?v= busts the browser cache when they upload a new one
+	avatarURL := fmt.Sprintf("%s/api/avatars/%d?v=%d", config.GetBackendURL(), userID, media.ID)
+	previous, err := h.db.SetAvatar(r.Context(), userID, media.ID, avatarURL)
+	if err != nil {
+		log.Printf("❌ Failed to set avatar: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to update avatar")
+		return
+	}
+	if previous != 0 {
+		if old, err := h.db.GetMedia(r.Context(), previous); err == nil {
+			if err := h.db.DeleteMedia(r.Context(), old.ID); err == nil {
+				h.deleteMediaObjects(r.Context(), old)
+			}
+		}
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		"profile_updated",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		"avatar",
+	)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"avatarUrl": avatarURL,
+	})
+}
+
+// AvatarHandler sends the browser to a short lived url for the user's avatar thumbnail
+// it is public so avatars work in plain img tags
+// GET /api/avatars/{id}
+func (h *AuthHandler) AvatarHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	media, err := h.db.GetAvatarMedia(r.Context(), userID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	key := media.ThumbnailKey
+	if key == "" {
+		key = media.ObjectKey
+	}
+	signed, err := h.store.SignedURL(r.Context(), key, config.MediaURLTTL)
+	if err != nil {
+		log.Printf("❌ Failed to sign avatar url: %v", err)
+		http.Error(w, "avatar unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	// cache the redirect for less time than the signed url lives
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	http.Redirect(w, r, signed, http.StatusFound)
+}
+
+// storeUpload reads the "file" part, checks it, and saves it (plus a thumbnail for images).
+// wantKind limits the upload to one kind ("image" for avatars), empty takes anything in mediaTypes.
+// on failure it returns nil, the status and a message that is safe to show the user
+func (h *AuthHandler) storeUpload(w http.ResponseWriter, r *http.Request, userID int, wantKind string) (*database.Media, int, string) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBody)
+	if err := r.ParseMultipartForm(uploadMemory); err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			return nil, http.StatusRequestEntityTooLarge, "File is too large"
+		}
+		return nil, http.StatusBadRequest, "Invalid upload"
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return nil, http.StatusBadRequest, "A file is required"
+	}
+	defer file.Close()
+
+	// Step 1: Work out what the file really is from its first bytes
+	contentType, err := sniff(file)
+	if err != nil {
+		return nil, http.StatusBadRequest, "Invalid upload"
+	}
+	kind, ok := mediaTypes[contentType]
+	if !ok || (wantKind != "" && kind.kind != wantKind) {
+		return nil, http.StatusUnsupportedMediaType, "This type of file is not allowed"
+	}
+	if header.Size > kind.maxSize {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Sprintf("%s files can be at most %d MB", kind.kind, kind.maxSize>>20)
+	}
+
+	media := &database.Media{
+		UserID:       userID,
+		Kind:         kind.kind,
+		ContentType:  contentType,
+		SizeBytes:    header.Size,
+		ObjectKey:    storage.NewKey(kind.ext),
+		OriginalName: truncate(filepath.Base(header.Filename), 255),
+	}
+
+	// Step 2: Images get a thumbnail, this is also where a file that only looks like an image gets caught
+	var thumbnail []byte
+	if kind.kind == "image" {
+		thumbnail, err = storage.Thumbnail(file, thumbnailSize)
+		if err != nil {
+			log.Printf("⚠️  Rejected image upload: %v", err)
+			return nil, http.StatusBadRequest, "This image could not be read"
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, http.StatusInternalServerError, "Failed to save upload"
+		}
+	}
+
+	// Step 3: Save the objects, then the row that points at them
+	if err := h.store.Put(r.Context(), media.ObjectKey, file, header.Size, contentType); err != nil {
+		log.Printf("❌ Failed to store upload: %v", err)
+		return nil, http.StatusInternalServerError, "Failed to save upload"
+	}
+	if thumbnail != nil {
+		media.ThumbnailKey = storage.NewKey(".thumb.png")
+		if err := h.store.Put(r.Context(), media.ThumbnailKey, bytes.NewReader(thumbnail), int64(len(thumbnail)), "image/png"); err != nil {
+			log.Printf("❌ Failed to store thumbnail: %v", err)
+			h.deleteMediaObjects(r.Context(), media)
+			return nil, http.StatusInternalServerError, "Failed to save upload"
+		}
+	}
+
+	if err := h.db.CreateMedia(r.Context(), media); err != nil {
+		log.Printf("❌ Failed to save media: %v", err)
+		h.deleteMediaObjects(r.Context(), media)
+		return nil, http.StatusInternalServerError, "Failed to save upload"
+	}
+
+	log.Printf("✅ User ID %d uploaded media %d (%s, %d bytes)", userID, media.ID, contentType, media.SizeBytes)
+	return media, 0, ""
+}
+
+// ownMedia loads the {id} upload, other people's uploads look the same as missing ones
+func (h *AuthHandler) ownMedia(w http.ResponseWriter, r *http.Request) (*database.Media, bool) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return nil, false
+	}
+
+	mediaID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid upload id")
+		return nil, false
+	}
+
+	media, err := h.db.GetMedia(r.Context(), mediaID)
+	if err != nil || media.UserID != userID {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "Upload not found")
+		return nil, false
+	}
+
+	return media, true
+}
+
+// mediaResponse adds signed download urls to an upload
+func (h *AuthHandler) mediaResponse(ctx context.Context, media *database.Media) (MediaResponse, error) {
+	response := MediaResponse{Media: *media}
+
+	signed, err := h.store.SignedURL(ctx, media.ObjectKey, config.MediaURLTTL)
+	if err != nil {
+		return response, err
+	}
+	response.URL = signed
+
+	if media.ThumbnailKey != "" {
+		signed, err := h.store.SignedURL(ctx, media.ThumbnailKey, config.MediaURLTTL)
+		if err != nil {
+			return response, err
+		}
+		response.ThumbnailURL = signed
+	}
+
+	return response, nil
+}
+
+// deleteMediaObjects removes an upload's files from the blob store, a leftover file is only logged
+func (h *AuthHandler) deleteMediaObjects(ctx context.Context, media *database.Media) {
+	for _, key := range []string{media.ObjectKey, media.ThumbnailKey} {
+		if key == "" {
+			continue
+		}
+		if err := h.store.Delete(ctx, key); err != nil {
+			log.Printf("⚠️  Failed to delete object %s: %v", key, err)
+		}
+	}
+}
+
+// sniff reads the first 512 bytes to detect the content type and rewinds the file
+func sniff(file multipart.File) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+// truncate cuts s to at most n bytes without splitting a utf-8 character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
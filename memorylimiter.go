@@ -0,0 +1,77 @@
+// backend/ratelimit/memory.go
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// buckets that nobody touched for this long are full again anyway, so we drop them to keep the map small
+const memoryIdleTimeout = time.Hour
+
+type memoryBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryLimiter keeps the buckets in a map, only good for one process
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+// NewMemoryLimiter creates an empty in memory limiter
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets:   make(map[string]*memoryBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket for key
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, rate Rate) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: float64(rate.Burst), last: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = refill(bucket.tokens, bucket.last, now, rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, retryAfter(bucket.tokens, rate), nil
+	}
+	bucket.tokens--
+	return true, 0, nil
+}
+
+// Reset drops the bucket for key
+func (l *MemoryLimiter) Reset(ctx context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.buckets, key)
+	return nil
+}
+
+// sweep removes idle buckets, at most once a minute. the caller holds the lock
+func (l *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.last) > memoryIdleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+}
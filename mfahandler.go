@@ -0,0 +1,586 @@
+// backend/handlers/mfa_handlers.go
+package handlers
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/models"
+	"backend/totp"
+	"backend/utils"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================
+// TWO FACTOR AUTHENTICATION (TOTP)
+// ============================================
+
+const (
+	// the cookie that holds a login that passed the password check but still needs a code
+	mfaPendingSession = "mfa-pending"
+	mfaPendingTTL     = 5 * time.Minute
+	// after this many wrong codes in a row the pending login is thrown away and password logins are locked,
+	// counted per user in user_mfa so a replayed mfa-pending cookie doesn't start the count over
+	maxMFAAttempts    = 5
+	recoveryCodeCount = 10
+)
+
+// mfaEnabled tells the login flows if this user has to pass two factor
+func (h *AuthHandler) mfaEnabled(r *http.Request, userID int) bool {
+	mfa, err := h.db.GetMFA(r.Context(), userID)
+	return err == nil && mfa.Enabled
+}
+
+// setMFAPending remembers who passed the first login step, it lives in the signed cookie store for 5 minutes
+func setMFAPending(w http.ResponseWriter, r *http.Request, userID int, provider string) error {
+	session, _ := config.GetStore().Get(r, mfaPendingSession)
+	session.Values["user_id"] = userID
+	session.Values["provider"] = provider
+	session.Values["expires"] = time.Now().Add(mfaPendingTTL).Unix()
+	session.Options.MaxAge = int(mfaPendingTTL.Seconds())
+	return session.Save(r, w)
+}
+
+func clearMFAPending(w http.ResponseWriter, r *http.Request) {
+	session, _ := config.GetStore().Get(r, mfaPendingSession)
+	session.Values = make(map[interface{}]interface{})
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		log.Printf("⚠️  Failed to clear mfa-pending state: %v", err)
+	}
+}
+
+// checkMFACode accepts either a TOTP code or an unused recovery code
+// returns which one was used so we can audit it
+func (h *AuthHandler) checkMFACode(r *http.Request, mfa *database.MFASettings, req models.MFACodeRequest) (string, bool) {
+	if req.Code != "" {
+		step, ok := totp.Validate(mfa.Secret, req.Code, time.Now())
+		if !ok {
+			return "totp", false
+		}
+		// the same code can't be used twice, even inside its 30 second window
+		fresh, err := h.db.UseMFAStep(r.Context(), mfa.UserID, step)
+		if err != nil {
+			log.Printf("❌ Failed to record mfa step: %v", err)
+			return "totp", false
+		}
+		return "totp", fresh
+	}
+
+	used, err := h.db.UseRecoveryCode(r.Context(), mfa.UserID, utils.HashToken(normalizeRecoveryCode(req.RecoveryCode)))
+	if err != nil {
+		log.Printf("❌ Failed to use recovery code: %v", err)
+		return "recovery_code", false
+	}
+	return "recovery_code", used
+}
+
+// generateRecoveryCodes returns the codes to show the user once and the hashes we store
+func generateRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(raw))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, utils.HashToken(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// people type recovery codes with or without the dash, in any case
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// MFAStatusHandler tells the settings page if two factor is on
+// GET /api/auth/mfa
+func (h *AuthHandler) MFAStatusHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	mfa, err := h.db.GetMFA(r.Context(), userID)
+	if err != nil || !mfa.Enabled {
+		utils.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+			"enabled": false,
+		})
+		return
+	}
+
+	remaining, _ := h.db.CountRecoveryCodes(r.Context(), userID)
+	utils.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"enabled":                true,
+		"enabledAt":              mfa.ConfirmedAt,
+		"recoveryCodesRemaining": remaining,
+	})
+}
+
+// MFASetupHandler starts enrollment and returns the secret + otpauth uri for the QR code
+// POST /api/auth/mfa/setup
+func (h *AuthHandler) MFASetupHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "User not found")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Printf("❌ Failed to generate totp secret: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	if err := h.db.SaveMFASecret(r.Context(), userID, secret); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	log.Printf("🔑 Two-factor enrollment started for user ID: %d", userID)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"secret":     secret,
+		"otpauthUrl": totp.ProvisioningURI(config.MFAIssuer(), user.Email, secret),
+		"message":    "Scan the QR code with your authenticator app, then confirm with a code",
+	})
+}
+
+// MFAConfirmHandler checks the first code from the app, turns two factor on and returns the recovery codes
+// POST /api/auth/mfa/confirm
+func (h *AuthHandler) MFAConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	var req models.MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+	if req.Code == "" {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Code is required")
+		return
+	}
+
+	mfa, err := h.db.GetMFA(r.Context(), userID)
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Start two-factor setup first")
+		return
+	}
+	if mfa.Enabled {
+		utils.ErrorResponseJSON(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	step, valid := totp.Validate(mfa.Secret, req.Code, time.Now())
+	if !valid {
+		h.db.CreateAuditLog(
+			r.Context(),
+			&userID,
+			"mfa_failed",
+			utils.GetIPAddress(r),
+			r.UserAgent(),
+			false,
+			"invalid setup code",
+		)
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid code")
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Printf("❌ Failed to generate recovery codes: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	if err := h.db.EnableMFA(r.Context(), userID, step, hashes); err != nil {
+		log.Printf("❌ Failed to enable mfa: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		"mfa_enabled",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		"",
+	)
+
+	log.Printf("✅ Two-factor enabled for user ID: %d", userID)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"message":       "Two-factor authentication is on. Save these recovery codes somewhere safe, they will not be shown again.",
+		"recoveryCodes": codes,
+	})
+}
+
+// MFAVerifyHandler is the second login step, it turns the mfa-pending state into a real session
+// POST /api/auth/mfa/verify
+func (h *AuthHandler) MFAVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("🔑 Two-factor login request received")
+
+	if !h.throttleIP(w, r, "mfa-verify", loginIPRate) {
+		return
+	}
+
+	var req models.MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Step 1: Find the pending login
+	pending, _ := config.GetStore().Get(r, mfaPendingSession)
+	userID, ok := pending.Values["user_id"].(int)
+	expires, _ := pending.Values["expires"].(int64)
+	if !ok || time.Now().Unix() > expires {
+		clearMFAPending(w, r)
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Your login has expired, please sign in again")
+		return
+	}
+	provider, _ := pending.Values["provider"].(string)
+
+	// same per account limit as passwords, no matter how many ips or cookies the codes come from
+	if !h.throttle(w, r, "mfa-verify:user:"+strconv.Itoa(userID), loginEmailRate) {
+		return
+	}
+
+	mfa, err := h.db.GetMFA(r.Context(), userID)
+	if err != nil || !mfa.Enabled {
+		clearMFAPending(w, r)
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Your login has expired, please sign in again")
+		return
+	}
+
+	// a lockout that started after the password step still counts
+	_, lockedFor, err := h.db.GetLoginLockout(r.Context(), userID)
+	if err != nil {
+		log.Printf("❌ Failed to check login lockout: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	if lockedFor > 0 {
+		clearMFAPending(w, r)
+		tooManyRequests(w, lockedFor, "Too many failed attempts. Try again later or reset your password.")
+		return
+	}
+
+	// Step 2: Check the code
+	method, valid := h.checkMFACode(r, mfa, req)
+	if !valid {
+		h.db.CreateAuditLog(
+			r.Context(),
+			&userID,
+			"mfa_failed",
+			utils.GetIPAddress(r),
+			r.UserAgent(),
+			false,
+			"invalid "+method,
+		)
+
+		if h.recordFailedMFA(r, userID) {
+			clearMFAPending(w, r)
+			utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Too many invalid codes, please sign in again")
+			return
+		}
+
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+	if err := h.db.ResetFailedMFA(r.Context(), userID); err != nil {
+		log.Printf("⚠️  Failed to reset failed mfa count: %v", err)
+	}
+
+	// Step 3: Create the real session
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "User not found")
+		return
+	}
+
+	clearMFAPending(w, r)
+	if h.accountSuspended(r, user) {
+		utils.ErrorResponseJSON(w, http.StatusForbidden, "This account has been suspended")
+		return
+	}
+	if err := h.startSession(w, r, user, provider); err != nil {
+		log.Printf("❌ Failed to save session: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to create session")
+		return
+	}
+
+	if method == "recovery_code" {
+		h.db.CreateAuditLog(
+			r.Context(),
+			&userID,
+			"mfa_recovery_code_used",
+			utils.GetIPAddress(r),
+			r.UserAgent(),
+			true,
+			"",
+		)
+	}
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		"login",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		"",
+	)
+
+	log.Printf("✅ Two-factor login successful: %s", user.Email)
+
+	user.PasswordHash = ""
+	utils.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Login successful",
+		"user":    user,
+	})
+}
+
+// throttleMFACode applies the login step's limits to the settings that need a code, a signed in session
+// gets no more guesses than someone at the second login step. false means the response is written
+func (h *AuthHandler) throttleMFACode(w http.ResponseWriter, r *http.Request, userID int) bool {
+	if !h.throttleIP(w, r, "mfa-verify", loginIPRate) ||
+		!h.throttle(w, r, "mfa-verify:user:"+strconv.Itoa(userID), loginEmailRate) {
+		return false
+	}
+
+	_, lockedFor, err := h.db.GetLoginLockout(r.Context(), userID)
+	if err != nil {
+		log.Printf("❌ Failed to check login lockout: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Server error")
+		return false
+	}
+	if lockedFor > 0 {
+		tooManyRequests(w, lockedFor, "Too many failed attempts. Try again later or reset your password.")
+		return false
+	}
+	return true
+}
+
+// recordFailedMFA counts a wrong code against the account, true when that was one too many and the account is now locked
+func (h *AuthHandler) recordFailedMFA(r *http.Request, userID int) bool {
+	attempts, err := h.db.RecordFailedMFA(r.Context(), userID)
+	if err != nil {
+		log.Printf("❌ Failed to record failed mfa: %v", err)
+		return false
+	}
+	if attempts < maxMFAAttempts {
+		return false
+	}
+	h.lockAfterFailedMFA(r, userID, attempts)
+	return true
+}
+
+// lockAfterFailedMFA locks password logins like too many wrong passwords do, whoever is guessing codes already has the password.
+// the owner gets the lockout email with a reset link
+func (h *AuthHandler) lockAfterFailedMFA(r *http.Request, userID, attempts int) {
+	if err := h.db.ResetFailedMFA(r.Context(), userID); err != nil {
+		log.Printf("⚠️  Failed to reset failed mfa count: %v", err)
+	}
+	if err := h.db.LockUser(r.Context(), userID, lockoutDuration); err != nil {
+		log.Printf("❌ Failed to lock user: %v", err)
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		"account_locked",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		fmt.Sprintf("%d invalid two-factor codes", attempts),
+	)
+	log.Printf("🔒 Account locked after %d invalid two-factor codes: user ID %d", attempts, userID)
+
+	if user, err := h.db.GetUserByID(r.Context(), userID); err == nil {
+		h.sendLockoutEmail(r, user)
+	}
+}
+
+// MFADisableHandler turns two factor off, it needs a current code so a stolen session can't do it
+// POST /api/auth/mfa/disable
+func (h *AuthHandler) MFADisableHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	var req models.MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !h.throttleMFACode(w, r, userID) {
+		return
+	}
+
+	mfa, err := h.db.GetMFA(r.Context(), userID)
+	if err != nil || !mfa.Enabled {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Two-factor authentication is not enabled")
+		return
+	}
+
+	method, valid := h.checkMFACode(r, mfa, req)
+	if !valid {
+		h.db.CreateAuditLog(
+			r.Context(),
+			&userID,
+			"mfa_failed",
+			utils.GetIPAddress(r),
+			r.UserAgent(),
+			false,
+			"invalid "+method,
+		)
+		if h.recordFailedMFA(r, userID) {
+			utils.ErrorResponseJSON(w, http.StatusTooManyRequests, "Too many invalid codes. Try again later or reset your password.")
+			return
+		}
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+	if err := h.db.ResetFailedMFA(r.Context(), userID); err != nil {
+		log.Printf("⚠️  Failed to reset failed mfa count: %v", err)
+	}
+
+	if err := h.db.DisableMFA(r.Context(), userID); err != nil {
+		log.Printf("❌ Failed to disable mfa: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		"mfa_disabled",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		"",
+	)
+
+	log.Printf("✅ Two-factor disabled for user ID: %d", userID)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"message": "Two-factor authentication has been turned off",
+	})
+}
+
+// MFARecoveryCodesHandler replaces the recovery codes, for when they used most of them or lost the list
+// POST /api/auth/mfa/recovery-codes
+func (h *AuthHandler) MFARecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	var req models.MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+	if req.Code == "" {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Code is required")
+		return
+	}
+	if !h.throttleMFACode(w, r, userID) {
+		return
+	}
+
+	mfa, err := h.db.GetMFA(r.Context(), userID)
+	if err != nil || !mfa.Enabled {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Two-factor authentication is not enabled")
+		return
+	}
+
+	if _, valid := h.checkMFACode(r, mfa, models.MFACodeRequest{Code: req.Code}); !valid {
+		h.db.CreateAuditLog(
+			r.Context(),
+			&userID,
+			"mfa_failed",
+			utils.GetIPAddress(r),
+			r.UserAgent(),
+			false,
+			"invalid totp",
+		)
+		if h.recordFailedMFA(r, userID) {
+			utils.ErrorResponseJSON(w, http.StatusTooManyRequests, "Too many invalid codes. Try again later or reset your password.")
+			return
+		}
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+	if err := h.db.ResetFailedMFA(r.Context(), userID); err != nil {
+		log.Printf("⚠️  Failed to reset failed mfa count: %v", err)
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Printf("❌ Failed to generate recovery codes: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	if err := h.db.ReplaceRecoveryCodes(r.Context(), userID, hashes); err != nil {
+		log.Printf("❌ Failed to save recovery codes: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to create recovery codes")
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		"mfa_recovery_codes_regenerated",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		"",
+	)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"message":       "New recovery codes created, the old ones no longer work",
+		"recoveryCodes": codes,
+	})
+}
@@ -0,0 +1,3 @@
+-- the sessions table now backs the auth-session cookie, the cookie only carries the session id
+-- last_seen_at lets us slide the expiry forward while the user is active
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
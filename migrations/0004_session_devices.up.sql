@@ -0,0 +1,3 @@
+-- remember where each session came from so users can recognise their devices
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip_address VARCHAR(45);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent TEXT;
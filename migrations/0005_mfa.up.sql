@@ -0,0 +1,22 @@
+-- optional TOTP two factor auth, one row per user that started enrolling
+-- enabled stays false until they confirm a first code from their authenticator app
+CREATE TABLE IF NOT EXISTS user_mfa (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    totp_secret VARCHAR(64) NOT NULL,
+    enabled BOOLEAN DEFAULT FALSE,
+    last_used_step BIGINT DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    confirmed_at TIMESTAMP
+);
+
+-- one time recovery codes, we only keep a sha256 of each code
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT unique_recovery_code UNIQUE (user_id, code_hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON mfa_recovery_codes(user_id);
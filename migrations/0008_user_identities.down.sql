@@ -0,0 +1,5 @@
+-- put back what the old schema had, local users get a NULL provider_id so the constraint can be recreated
+UPDATE users SET provider_id = NULL WHERE provider = 'local' AND provider_id = '';
+ALTER TABLE users ADD CONSTRAINT unique_provider_user UNIQUE (provider, provider_id);
+
+DROP TABLE IF EXISTS user_identities;
@@ -0,0 +1,26 @@
+-- external logins (google, github...) linked to an account, one user can have many
+-- users.provider / users.provider_id stay as a record of how the account was first created
+CREATE TABLE IF NOT EXISTS user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL,
+    provider_id VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP,
+    CONSTRAINT unique_identity UNIQUE (provider, provider_id),
+    CONSTRAINT unique_user_provider UNIQUE (user_id, provider)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities(user_id);
+
+-- every existing OAuth account gets its identity row
+INSERT INTO user_identities (user_id, provider, provider_id, email, created_at)
+SELECT id, provider, provider_id, email, created_at
+FROM users
+WHERE provider <> 'local' AND provider_id IS NOT NULL AND provider_id <> ''
+ON CONFLICT DO NOTHING;
+
+-- lookups go through user_identities now. this constraint also made every local signup after the
+-- first one fail, since they all have provider 'local' and an empty provider_id
+ALTER TABLE users DROP CONSTRAINT IF EXISTS unique_provider_user;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_audit_log_target_user_id;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS details;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS target_user_id;
+
+ALTER TABLE users ALTER COLUMN role DROP NOT NULL;
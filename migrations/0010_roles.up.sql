@@ -0,0 +1,9 @@
+-- roles are student, teacher, school_admin or platform_admin (see models/roles.go)
+UPDATE users SET role = 'student' WHERE role IS NULL;
+ALTER TABLE users ALTER COLUMN role SET NOT NULL;
+
+-- admin actions are done by one user to another, the audit row keeps both
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS target_user_id INTEGER REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS details TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_target_user_id ON audit_log(target_user_id);
@@ -0,0 +1,5 @@
+ALTER TABLE users DROP COLUMN IF EXISTS avatar_url;
+ALTER TABLE users DROP COLUMN IF EXISTS target_interview_date;
+ALTER TABLE users DROP COLUMN IF EXISTS native_language;
+ALTER TABLE users DROP COLUMN IF EXISTS timezone;
+ALTER TABLE users DROP COLUMN IF EXISTS preferred_language;
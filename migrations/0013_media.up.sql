@@ -0,0 +1,17 @@
+-- uploaded files, the bytes live in the blob store (local disk or s3), this table says who owns what
+CREATE TABLE IF NOT EXISTS media (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(10) NOT NULL,             -- image, audio or pdf
+    content_type VARCHAR(100) NOT NULL,    -- sniffed from the bytes, not what the browser claimed
+    size_bytes BIGINT NOT NULL,
+    object_key VARCHAR(64) NOT NULL UNIQUE,
+    thumbnail_key VARCHAR(64),             -- images only
+    original_name VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_media_user_id ON media(user_id);
+
+-- an uploaded avatar is a media row, users.avatar_url then points at /api/avatars/{user id}
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_media_id INTEGER REFERENCES media(id) ON DELETE SET NULL;
@@ -0,0 +1,5 @@
+-- self service account deletion waits out a grace period so the user can change their mind,
+-- the deletion job removes the account once deletion_scheduled_for has passed
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_scheduled_for TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_users_deletion_scheduled_for ON users(deletion_scheduled_for) WHERE deletion_scheduled_for IS NOT NULL;
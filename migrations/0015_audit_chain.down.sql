@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_audit_log_chained;
+
+ALTER TABLE audit_log DROP COLUMN IF EXISTS target_user_ref;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS user_ref;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS hash;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS prev_hash;
@@ -0,0 +1,10 @@
+-- every new audit row carries the hash of its own content plus the previous row's hash,
+-- editing or deleting a row in the middle breaks every hash after it. rows from before this migration stay unchained (hash IS NULL)
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64);
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS hash VARCHAR(64);
+
+-- user_id and target_user_id are nulled when a user is deleted, so the hash covers these keyed references instead
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS user_ref VARCHAR(64);
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS target_user_ref VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_chained ON audit_log(id) WHERE hash IS NOT NULL;
@@ -0,0 +1,16 @@
+-- devices (browsers) a user has logged in from, the login risk check compares new logins against these
+CREATE TABLE IF NOT EXISTS user_devices (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_hash VARCHAR(64) NOT NULL,   -- sha256 of the user agent with version numbers stripped
+    user_agent TEXT NOT NULL DEFAULT '',
+    last_ip VARCHAR(45) NOT NULL DEFAULT '',
+    latitude DOUBLE PRECISION,          -- from the geoip database, NULL when the ip couldn't be located
+    longitude DOUBLE PRECISION,
+    location VARCHAR(255) NOT NULL DEFAULT '',
+    first_seen_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL,
+    UNIQUE (user_id, device_hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_devices_user_id ON user_devices(user_id);
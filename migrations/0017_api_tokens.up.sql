@@ -0,0 +1,14 @@
+-- personal access tokens for scripts (roster syncs, gradebook exports), sent as Authorization: Bearer vgo_pat_...
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,   -- sha256 of the token, the token itself is only shown once
+    token_prefix VARCHAR(16) NOT NULL,        -- first characters of the token so the user can tell them apart
+    scopes TEXT[] NOT NULL DEFAULT '{}',      -- permissions the token may use, never more than the user's role grants
+    expires_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
@@ -0,0 +1,41 @@
+-- "Sign in with VirgoAI": apps that log their users in with our accounts (openid connect, authorization code + pkce)
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    id SERIAL PRIMARY KEY,
+    client_id VARCHAR(64) NOT NULL UNIQUE,
+    client_secret_hash VARCHAR(64),          -- sha256 of the secret, NULL for public clients (mobile and single page apps)
+    name VARCHAR(100) NOT NULL,              -- shown on the consent screen
+    redirect_uris TEXT[] NOT NULL,           -- exact matches only
+    first_party BOOLEAN NOT NULL DEFAULT false, -- our own apps skip the consent screen
+    created_at TIMESTAMP NOT NULL
+);
+
+-- a user said yes to an app once, they aren't asked again unless it wants more scopes
+CREATE TABLE IF NOT EXISTS oauth_consents (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL,
+    granted_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, client_id)
+);
+
+CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+    id SERIAL PRIMARY KEY,
+    code_hash VARCHAR(64) NOT NULL UNIQUE,
+    client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scopes TEXT[] NOT NULL,
+    nonce VARCHAR(255) NOT NULL DEFAULT '',
+    code_challenge VARCHAR(128) NOT NULL,     -- pkce, always S256
+    auth_time TIMESTAMP NOT NULL,             -- when the user actually logged in, goes in the id token
+    expires_at TIMESTAMP NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT false
+);
+
+-- RS256 keys for id tokens. the newest one signs, older ones stay in the jwks until tokens signed with them expired
+CREATE TABLE IF NOT EXISTS oauth_signing_keys (
+    id SERIAL PRIMARY KEY,
+    kid VARCHAR(64) NOT NULL UNIQUE,
+    private_key TEXT NOT NULL,                -- PKCS#1 PEM
+    created_at TIMESTAMP NOT NULL
+);
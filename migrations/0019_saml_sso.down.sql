@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS saml_requests;
+DROP TABLE IF EXISTS saml_connections;
+DROP TABLE IF EXISTS organizations;
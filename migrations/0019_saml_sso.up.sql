@@ -0,0 +1,33 @@
+-- schools and colleges that sign their users in through their own SAML identity provider
+CREATE TABLE IF NOT EXISTS organizations (
+    id SERIAL PRIMARY KEY,
+    slug VARCHAR(40) NOT NULL UNIQUE,   -- in the sso urls and in the users' provider, saml:<slug> (provider is VARCHAR(50))
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- one identity provider per organization, set up by uploading its metadata
+CREATE TABLE IF NOT EXISTS saml_connections (
+    id SERIAL PRIMARY KEY,
+    organization_id INTEGER NOT NULL UNIQUE REFERENCES organizations(id) ON DELETE CASCADE,
+    idp_entity_id TEXT NOT NULL,
+    idp_metadata TEXT NOT NULL,                     -- the uploaded xml, signing certificates included
+    email_domains TEXT[] NOT NULL DEFAULT '{}',     -- only emails in these domains are accepted from the idp
+    default_role VARCHAR(20) NOT NULL DEFAULT 'student',
+    email_attribute VARCHAR(255) NOT NULL DEFAULT 'email',
+    first_name_attribute VARCHAR(255) NOT NULL DEFAULT 'first_name',
+    last_name_attribute VARCHAR(255) NOT NULL DEFAULT 'last_name',
+    role_attribute VARCHAR(255) NOT NULL DEFAULT 'role',
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- AuthnRequests we sent and haven't seen a response for, keyed by the RelayState the idp echoes back.
+-- the acs post comes from the idp's site so a cookie wouldn't make it back, this table does the job instead
+CREATE TABLE IF NOT EXISTS saml_requests (
+    request_id VARCHAR(64) PRIMARY KEY,
+    organization_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    relay_state_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL
+);
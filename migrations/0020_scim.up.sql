@@ -0,0 +1,46 @@
+-- bearer tokens an organization's student information system sends to /scim/v2, one organization per token
+CREATE TABLE IF NOT EXISTS scim_tokens (
+    id SERIAL PRIMARY KEY,
+    organization_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,   -- sha256 of the token, the token itself is only shown once
+    token_prefix VARCHAR(20) NOT NULL,
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_scim_tokens_organization_id ON scim_tokens(organization_id);
+
+-- users an organization provisioned over scim, a user is on at most one organization's roster
+CREATE TABLE IF NOT EXISTS scim_users (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    organization_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_name VARCHAR(255) NOT NULL,   -- the SIS's unique name for the user, usually the email or a student number
+    external_id VARCHAR(255),          -- the SIS's own id for the user
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- scim says userName is case insensitive
+CREATE UNIQUE INDEX IF NOT EXISTS idx_scim_users_user_name ON scim_users(organization_id, LOWER(user_name));
+CREATE INDEX IF NOT EXISTS idx_scim_users_external_id ON scim_users(organization_id, external_id);
+
+-- classes, sections and cohorts the SIS pushes, members are users on the same roster
+CREATE TABLE IF NOT EXISTS scim_groups (
+    id SERIAL PRIMARY KEY,
+    organization_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    display_name VARCHAR(255) NOT NULL,
+    external_id VARCHAR(255),
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_scim_groups_display_name ON scim_groups(organization_id, LOWER(display_name));
+
+CREATE TABLE IF NOT EXISTS scim_group_members (
+    group_id INTEGER NOT NULL REFERENCES scim_groups(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_scim_group_members_user_id ON scim_group_members(user_id);
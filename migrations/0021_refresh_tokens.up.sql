@@ -0,0 +1,15 @@
+-- the mobile app's refresh tokens. every refresh uses up the token and hands out the next one in the same family,
+-- a family is one sign in on one device. a used token coming back means it was copied, the whole family is revoked then
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id SERIAL PRIMARY KEY,
+    family_id VARCHAR(64) NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,   -- sha256 of the token, the app keeps the token itself
+    used_at TIMESTAMP,                        -- when it was swapped for the next token
+    revoked_at TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id);
@@ -0,0 +1,6 @@
+-- a hash can't be turned back into its token, outstanding links stop working
+DELETE FROM email_verification_tokens;
+ALTER TABLE email_verification_tokens ALTER COLUMN token_hash TYPE VARCHAR(255);
+ALTER TABLE email_verification_tokens RENAME COLUMN token_hash TO token;
+
+CREATE INDEX IF NOT EXISTS idx_verification_tokens_token ON email_verification_tokens(token);
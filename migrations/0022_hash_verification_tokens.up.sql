@@ -0,0 +1,8 @@
+-- verification tokens were stored as sent, keep only a sha256 like magic_link_tokens does.
+-- links already in inboxes keep working, their rows are hashed in place
+ALTER TABLE email_verification_tokens RENAME COLUMN token TO token_hash;
+UPDATE email_verification_tokens SET token_hash = encode(sha256(convert_to(token_hash, 'UTF8')), 'hex');
+ALTER TABLE email_verification_tokens ALTER COLUMN token_hash TYPE VARCHAR(64);
+
+-- the unique constraint already indexes the column
+DROP INDEX IF EXISTS idx_verification_tokens_token;
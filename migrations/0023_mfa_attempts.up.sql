@@ -0,0 +1,3 @@
+-- wrong codes at the second login step, counted per user on the server.
+-- the count used to live in the mfa-pending cookie, and replaying an old cookie reset it
+ALTER TABLE user_mfa ADD COLUMN IF NOT EXISTS failed_attempts INTEGER NOT NULL DEFAULT 0;
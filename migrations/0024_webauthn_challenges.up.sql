@@ -0,0 +1,10 @@
+-- passkey challenges between the begin and the finish step. the webauthn-ceremony cookie only holds a random handle,
+-- finish deletes the row so a captured cookie and assertion can't be replayed (synced passkeys don't bump signCount)
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+    handle_hash VARCHAR(64) PRIMARY KEY,   -- sha256 of the handle in the cookie
+    kind VARCHAR(16) NOT NULL,             -- register or login
+    session_data TEXT NOT NULL,            -- webauthn.SessionData as json
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_challenges_expires ON webauthn_challenges(expires_at);
@@ -0,0 +1,2 @@
+-- nothing to undo, which connections had an admin default isn't kept
+SELECT 1;
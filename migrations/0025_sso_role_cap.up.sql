@@ -0,0 +1,2 @@
+-- identity providers can only hand out student and teacher now, connections that defaulted to an admin role start at student
+UPDATE saml_connections SET default_role = 'student' WHERE default_role NOT IN ('student', 'teacher');
@@ -0,0 +1,8 @@
+-- the domains an organization's accounts can be in. SCIM only provisions addresses in these, with or without sso.
+-- organizations that already had sso start with their identity provider's domains
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS email_domains TEXT[] NOT NULL DEFAULT '{}';
+
+UPDATE organizations o
+SET email_domains = c.email_domains
+FROM saml_connections c
+WHERE c.organization_id = o.id;
@@ -0,0 +1,14 @@
+-- who suspended the account, 'admin' or 'scim'. SCIM may only lift a suspension SCIM put on, an admin's stays until an admin lifts it.
+-- accounts suspended before this go by whichever suspension the audit log saw last
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_by VARCHAR(20);
+
+UPDATE users u
+SET suspended_by = CASE WHEN (
+        SELECT a.action
+        FROM audit_log a
+        WHERE (a.action = 'scim_user_deactivated' AND a.user_id = u.id)
+           OR (a.action = 'user_suspended' AND a.target_user_id = u.id)
+        ORDER BY a.created_at DESC, a.id DESC
+        LIMIT 1
+    ) = 'scim_user_deactivated' THEN 'scim' ELSE 'admin' END
+WHERE u.suspended_at IS NOT NULL;
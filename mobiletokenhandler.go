@@ -0,0 +1,228 @@
+// backend/handlers/mobile_token_handlers.go
+package handlers
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/middleware"
+	"backend/models"
+	"backend/oidc"
+	"backend/utils"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ============================================
+// MOBILE APP TOKENS
+// ============================================
+
+// the mobile app can't hold on to a cookie the way a browser does. it logs in with the normal endpoints
+// (password, magic link, two factor...) and then swaps the auth-session for a short lived JWT access token
+// and an opaque refresh token. every refresh hands out a new refresh token and burns the old one,
+// a burnt token showing up again means it was stolen, so the whole family (that one sign in) gets revoked
+
+// MobileTokenHandler issues access and refresh tokens to the mobile app
+// POST /api/auth/token
+func (h *AuthHandler) MobileTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// tokens must never end up in a cache
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if !h.throttleIP(w, r, "mobile-token", oauthTokenIPRate) {
+		return
+	}
+
+	var req models.MobileTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "the body must be json")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	if req.GrantType == "session" {
+		h.mobileSessionGrant(w, r)
+		return
+	}
+	h.mobileRefreshGrant(w, r, req.RefreshToken)
+}
+
+// mobileSessionGrant swaps the auth-session cookie for the first tokens of a new family, the cookie is logged out
+func (h *AuthHandler) mobileSessionGrant(w http.ResponseWriter, r *http.Request) {
+	user, _ := h.sessionUser(r)
+	if user == nil {
+		oauthError(w, http.StatusUnauthorized, "invalid_grant", "log in before asking for tokens")
+		return
+	}
+	if user.SuspendedAt != nil {
+		oauthError(w, http.StatusForbidden, "invalid_grant", "this account has been suspended")
+		return
+	}
+
+	tokens, err := h.issueMobileTokens(r, user.ID, utils.GenerateSecureToken(16))
+	if err != nil {
+		log.Printf("❌ Failed to issue mobile tokens: %v", err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "failed to issue tokens")
+		return
+	}
+
+	// from here on the app only uses the tokens, a cookie left behind would be a second way in
+	session, _ := config.GetSessionStore().Get(r, "auth-session")
+	session.Values = make(map[interface{}]interface{})
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		log.Printf("⚠️  Failed to clear session: %v", err)
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&user.ID,
+		"mobile_sign_in",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		"",
+	)
+
+	log.Printf("✅ Issued mobile tokens for user ID %d", user.ID)
+
+	utils.ResponseJSON(w, http.StatusOK, tokens)
+}
+
+// mobileRefreshGrant rotates a refresh token, the new one stays in the same family
+func (h *AuthHandler) mobileRefreshGrant(w http.ResponseWriter, r *http.Request, refreshToken string) {
+	tokenHash := utils.HashToken(refreshToken)
+
+	// Step 1: Burn the token, this only works once
+	token, err := h.db.ConsumeRefreshToken(r.Context(), tokenHash, time.Now().UTC().Truncate(time.Microsecond))
+	if err != nil {
+		// a token that was already used is a replay, whoever has the family now can't be trusted
+		if used, lookupErr := h.db.GetRefreshTokenByHash(r.Context(), tokenHash); lookupErr == nil && used.UsedAt != nil && used.RevokedAt == nil {
+			if _, err := h.db.RevokeRefreshTokenFamily(r.Context(), used.FamilyID); err != nil {
+				log.Printf("❌ Failed to revoke refresh token family: %v", err)
+			}
+			h.db.CreateAuditLog(
+				r.Context(),
+				&used.UserID,
+				"refresh_token_reused",
+				utils.GetIPAddress(r),
+				r.UserAgent(),
+				false,
+				"token family revoked",
+			)
+			log.Printf("🚨 Reused refresh token for user ID %d from %s, family revoked", used.UserID, utils.GetIPAddress(r))
+		}
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the refresh token is invalid, expired or was already used")
+		return
+	}
+
+	// Step 2: The account has to still be usable
+	user, err := h.db.GetUserByID(r.Context(), token.UserID)
+	if err != nil || user.SuspendedAt != nil {
+		if _, err := h.db.RevokeRefreshTokenFamily(r.Context(), token.FamilyID); err != nil {
+			log.Printf("❌ Failed to revoke refresh token family: %v", err)
+		}
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the account is no longer available")
+		return
+	}
+
+	// Step 3: Issue the next pair
+	tokens, err := h.issueMobileTokens(r, user.ID, token.FamilyID)
+	if err != nil {
+		log.Printf("❌ Failed to rotate mobile tokens: %v", err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "failed to issue tokens")
+		return
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, tokens)
+}
+
+// issueMobileTokens signs an access token and stores a new refresh token for the family, the result is the token response
+func (h *AuthHandler) issueMobileTokens(r *http.Request, userID int, familyID string) (map[string]interface{}, error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	// sid ties the access token to its family, so signing out elsewhere can tell this device apart
+	accessToken, err := h.keys.Sign(r.Context(), oidc.TypeAccessToken, oidc.Claims{
+		"iss": oidcIssuer(),
+		"sub": strconv.Itoa(userID),
+		"aud": oidc.AudienceAPI,
+		"sid": familyID,
+		"iat": now.Unix(),
+		"exp": now.Add(config.AccessTokenTTL).Unix(),
+		"jti": utils.GenerateSecureToken(16),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken := utils.GenerateSecureToken(32)
+	err = h.db.CreateRefreshToken(r.Context(), &database.RefreshToken{
+		FamilyID:  familyID,
+		UserID:    userID,
+		TokenHash: utils.HashToken(refreshToken),
+		ExpiresAt: now.Add(config.RefreshTokenTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"access_token":       accessToken,
+		"token_type":         "Bearer",
+		"expires_in":         int(config.AccessTokenTTL.Seconds()),
+		"refresh_token":      refreshToken,
+		"refresh_expires_in": int(config.RefreshTokenTTL.Seconds()),
+	}, nil
+}
+
+// MobileRevokeHandler signs the app out, the refresh token and everything rotated from it stop working.
+// unknown tokens get the same answer so this can't be used to test tokens
+// POST /api/auth/token/revoke
+func (h *AuthHandler) MobileRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.throttleIP(w, r, "mobile-token", oauthTokenIPRate) {
+		return
+	}
+
+	var req models.RevokeTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "the body must be json")
+		return
+	}
+
+	token, err := h.db.GetRefreshTokenByHash(r.Context(), utils.HashToken(req.RefreshToken))
+	if err == nil && token.RevokedAt == nil {
+		if _, err := h.db.RevokeRefreshTokenFamily(r.Context(), token.FamilyID); err != nil {
+			log.Printf("❌ Failed to revoke refresh token family: %v", err)
+			oauthError(w, http.StatusInternalServerError, "server_error", "failed to revoke the token")
+			return
+		}
+		h.db.CreateAuditLog(
+			r.Context(),
+			&token.UserID,
+			"mobile_logout",
+			utils.GetIPAddress(r),
+			r.UserAgent(),
+			true,
+			"",
+		)
+		log.Printf("✅ Mobile app signed out for user ID %d", token.UserID)
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"message": "Logged out successfully",
+	})
+}
+
+// currentRefreshFamily is the refresh token family of a request made with a mobile access token, "" for everything else
+func currentRefreshFamily(r *http.Request) string {
+	if token, ok := middleware.AccessTokenFromContext(r.Context()); ok {
+		return token.FamilyID
+	}
+	return ""
+}
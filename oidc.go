@@ -0,0 +1,72 @@
+// backend/oidc/oidc.go
+package oidc
+
+// the pieces of "Sign in with VirgoAI" that aren't http handlers: the scopes we hand out,
+// pkce checks and the RS256 keys (keys.go) that sign id tokens and access tokens (jwt.go).
+// the flow itself (authorize, consent, token, userinfo) lives in handlers/oidc_handlers.go
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+)
+
+// the scopes a client can ask for, unknown scopes are dropped from the request instead of failing it
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// SupportedScopes is what discovery advertises
+var SupportedScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+// token types, set as the jwt typ header so an id token can never be used as an access token
+const (
+	TypeIDToken     = "JWT"
+	TypeAccessToken = "at+jwt"
+)
+
+// AudienceAPI is the aud of the access tokens our own mobile app gets for /api,
+// BearerAuth only takes those so a partner app's userinfo token can't call the api
+const AudienceAPI = "virgoai-api"
+
+// FilterScopes keeps the scopes we support, in the order they were asked for and without repeats
+func FilterScopes(requested []string) []string {
+	var scopes []string
+	seen := make(map[string]bool)
+	for _, scope := range requested {
+		if seen[scope] {
+			continue
+		}
+		for _, supported := range SupportedScopes {
+			if scope == supported {
+				scopes = append(scopes, scope)
+				seen[scope] = true
+			}
+		}
+	}
+	return scopes
+}
+
+// HasScope reports whether scope is in scopes
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyPKCE checks the code_verifier from the token request against the S256 code_challenge from the authorize request.
+// plain isn't supported, it protects nothing once the challenge leaks
+func VerifyPKCE(verifier, challenge string) bool {
+	// RFC 7636: 43 to 128 characters
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
@@ -0,0 +1,623 @@
+// backend/handlers/oidc_handlers.go
+package handlers
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/oidc"
+	"backend/utils"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================
+// OPENID CONNECT PROVIDER ("Sign in with VirgoAI")
+// ============================================
+
+// partner apps send the browser to /oauth2/authorize. the user logs in through the normal login page
+// (LoginHandler, OAuth, passkeys...) which sends them back to the authorize url, approves the app on the
+// consent screen, and the app swaps the code it gets for an id token at /oauth2/token.
+// only the authorization code flow with S256 pkce is supported, for public and confidential clients alike.
+
+// authorizeRequest is an authorize request waiting for the user to consent, it sits in the auth-session meanwhile
+type authorizeRequest struct {
+	ID            string   `json:"id"`
+	ClientID      string   `json:"clientId"`
+	RedirectURI   string   `json:"redirectUri"`
+	Scopes        []string `json:"scopes"`
+	State         string   `json:"state"`
+	Nonce         string   `json:"nonce"`
+	CodeChallenge string   `json:"codeChallenge"`
+}
+
+// ConsentResponse is what the consent screen shows
+type ConsentResponse struct {
+	RequestID  string   `json:"requestId"`
+	ClientID   string   `json:"clientId"`
+	ClientName string   `json:"clientName"`
+	Scopes     []string `json:"scopes"`
+}
+
+// ConsentRequest is the user's answer on the consent screen
+type ConsentRequest struct {
+	RequestID string `json:"requestId"`
+	Approve   bool   `json:"approve"`
+}
+
+// oidcIssuer is the iss of every token, discovery has to be served right under it
+func oidcIssuer() string {
+	return strings.TrimSuffix(config.GetBackendURL(), "/")
+}
+
+// DiscoveryHandler tells client libraries where everything is
+// GET /.well-known/openid-configuration
+func (h *AuthHandler) DiscoveryHandler(w http.ResponseWriter, r *http.Request) {
+	issuer := oidcIssuer()
+	utils.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oauth2/authorize",
+		"token_endpoint":                        issuer + "/oauth2/token",
+		"userinfo_endpoint":                     issuer + "/oauth2/userinfo",
+		"jwks_uri":                              issuer + "/oauth2/jwks",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      oidc.SupportedScopes,
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"prompt_values_supported":               []string{"none", "login", "consent"},
+		"claims_supported": []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "role",
+			"email", "email_verified", "name", "given_name", "family_name", "picture", "locale", "zoneinfo",
+		},
+	})
+}
+
+// JWKSHandler publishes the public keys id tokens are signed with
+// GET /oauth2/jwks
+func (h *AuthHandler) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.keys.JWKS(r.Context())
+	if err != nil {
+		log.Printf("❌ Failed to load signing keys: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to load signing keys")
+		return
+	}
+
+	// clients cache this, a rotated key is published long before it signs anything they need to check
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.ResponseJSON(w, http.StatusOK, keys)
+}
+
+// AuthorizeHandler starts the flow, it sends the browser to login, to the consent screen or straight back to the app with a code
+// GET /oauth2/authorize?response_type=code&client_id=&redirect_uri=&scope=openid&state=&nonce=&code_challenge=&code_challenge_method=S256
+func (h *AuthHandler) AuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// Step 1: Check the client and redirect uri. until both are known good errors are shown here, never redirected
+	client, err := h.db.GetOAuthClient(r.Context(), query.Get("client_id"))
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Unknown client")
+		return
+	}
+	redirectURI := query.Get("redirect_uri")
+	if !containsString(client.RedirectURIs, redirectURI) {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "redirect_uri is not registered for this client")
+		return
+	}
+
+	state := query.Get("state")
+	fail := func(code, description string) {
+		http.Redirect(w, r, authorizeRedirect(redirectURI, url.Values{
+			"error":             {code},
+			"error_description": {description},
+			"state":             {state},
+		}), http.StatusSeeOther)
+	}
+
+	// Step 2: Validate the rest of the request
+	if query.Get("response_type") != "code" {
+		fail("unsupported_response_type", "only response_type=code is supported")
+		return
+	}
+	scopes := oidc.FilterScopes(strings.Fields(query.Get("scope")))
+	if !oidc.HasScope(scopes, oidc.ScopeOpenID) {
+		fail("invalid_scope", "the openid scope is required")
+		return
+	}
+	if query.Get("code_challenge_method") != "S256" || len(query.Get("code_challenge")) != 43 {
+		fail("invalid_request", "pkce with code_challenge_method=S256 is required")
+		return
+	}
+	if len(query.Get("nonce")) > 255 || len(state) > 1024 {
+		fail("invalid_request", "nonce or state is too long")
+		return
+	}
+
+	// Step 3: The user has to be logged in, recently enough when the app asks for it
+	prompts := strings.Fields(query.Get("prompt"))
+	user, authTime := h.sessionUser(r)
+	needLogin := user == nil || containsString(prompts, "login")
+	if maxAge := query.Get("max_age"); user != nil && maxAge != "" {
+		seconds, err := strconv.Atoi(maxAge)
+		if err != nil || seconds < 0 {
+			fail("invalid_request", "max_age must be a number of seconds")
+			return
+		}
+		if time.Since(authTime) > time.Duration(seconds)*time.Second {
+			needLogin = true
+		}
+	}
+	if needLogin {
+		if containsString(prompts, "none") {
+			fail("login_required", "the user is not logged in")
+			return
+		}
+		// the login page sends the user back here afterwards, without prompt=login and max_age so it doesn't loop
+		resume := *r.URL
+		resumeQuery := resume.Query()
+		resumeQuery.Del("prompt")
+		resumeQuery.Del("max_age")
+		resume.RawQuery = resumeQuery.Encode()
+		http.Redirect(w, r, config.GetFrontendURL()+"/Login?continue="+url.QueryEscape(oidcIssuer()+resume.RequestURI()), http.StatusSeeOther)
+		return
+	}
+	if user.SuspendedAt != nil {
+		fail("access_denied", "this account has been suspended")
+		return
+	}
+
+	pending := authorizeRequest{
+		ID:            utils.GenerateSecureToken(16),
+		ClientID:      client.ClientID,
+		RedirectURI:   redirectURI,
+		Scopes:        scopes,
+		State:         state,
+		Nonce:         query.Get("nonce"),
+		CodeChallenge: query.Get("code_challenge"),
+	}
+
+	// Step 4: Our own apps and apps the user already approved for these scopes skip the consent screen
+	granted, err := h.db.GetOAuthConsent(r.Context(), user.ID, client.ClientID)
+	if err != nil {
+		log.Printf("❌ Failed to load consent: %v", err)
+		fail("server_error", "failed to load consent")
+		return
+	}
+	needConsent := !client.FirstParty && (containsString(prompts, "consent") || !coversScopes(granted, scopes))
+	if needConsent {
+		if containsString(prompts, "none") {
+			fail("consent_required", "the user has not approved this app")
+			return
+		}
+		if err := savePendingAuthorization(w, r, pending); err != nil {
+			log.Printf("❌ Failed to save authorization request: %v", err)
+			fail("server_error", "failed to save the request")
+			return
+		}
+		http.Redirect(w, r, config.GetFrontendURL()+"/Consent?request="+url.QueryEscape(pending.ID), http.StatusSeeOther)
+		return
+	}
+
+	// Step 5: Send the code back to the app
+	target, err := h.issueAuthorizationCode(r, pending, user.ID, authTime)
+	if err != nil {
+		log.Printf("❌ Failed to create authorization code: %v", err)
+		fail("server_error", "failed to create the authorization code")
+		return
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// ConsentDetailsHandler tells the consent screen which app is asking for what
+// GET /api/oauth2/consent?request=
+func (h *AuthHandler) ConsentDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	pending, ok := loadPendingAuthorization(r, r.URL.Query().Get("request"))
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "This sign in request has expired, go back to the app and try again")
+		return
+	}
+
+	client, err := h.db.GetOAuthClient(r.Context(), pending.ClientID)
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "This app no longer exists")
+		return
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, ConsentResponse{
+		RequestID:  pending.ID,
+		ClientID:   client.ClientID,
+		ClientName: client.Name,
+		Scopes:     pending.Scopes,
+	})
+}
+
+// ConsentHandler records the user's answer and gives the frontend the url to send the browser back to the app
+// POST /api/oauth2/consent
+func (h *AuthHandler) ConsentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	var req ConsentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+
+	// Step 1: Take the request out of the session, an answer only counts once
+	pending, ok := loadPendingAuthorization(r, req.RequestID)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "This sign in request has expired, go back to the app and try again")
+		return
+	}
+	if err := clearPendingAuthorization(w, r); err != nil {
+		log.Printf("⚠️  Failed to clear authorization request: %v", err)
+	}
+
+	// Step 2: No means the app gets access_denied
+	if !req.Approve {
+		h.db.CreateAuditLog(
+			r.Context(),
+			&userID,
+			"oidc_consent_denied",
+			utils.GetIPAddress(r),
+			r.UserAgent(),
+			true,
+			pending.ClientID,
+		)
+		utils.ResponseJSON(w, http.StatusOK, map[string]string{
+			"redirectTo": authorizeRedirect(pending.RedirectURI, url.Values{
+				"error":             {"access_denied"},
+				"error_description": {"the user denied the request"},
+				"state":             {pending.State},
+			}),
+		})
+		return
+	}
+
+	// Step 3: Remember the answer so they aren't asked again, then hand out the code
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	if err := h.db.SaveOAuthConsent(r.Context(), userID, pending.ClientID, pending.Scopes, now); err != nil {
+		log.Printf("❌ Failed to save consent: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to save your answer")
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		"oidc_consent_granted",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		pending.ClientID+" "+strings.Join(pending.Scopes, " "),
+	)
+
+	_, authTime := h.sessionUser(r)
+	target, err := h.issueAuthorizationCode(r, pending, userID, authTime)
+	if err != nil {
+		log.Printf("❌ Failed to create authorization code: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to finish signing in")
+		return
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{"redirectTo": target})
+}
+
+// TokenHandler swaps an authorization code for an id token and an access token
+// POST /oauth2/token (form encoded)
+func (h *AuthHandler) TokenHandler(w http.ResponseWriter, r *http.Request) {
+	// tokens must never end up in a cache
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if !h.throttleIP(w, r, "oauth-token", oauthTokenIPRate) {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "the body must be form encoded")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		oauthError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	// Step 1: Authenticate the client, confidential clients send their secret (basic auth or form), public ones only pkce
+	clientID, clientSecret, basic := r.BasicAuth()
+	if !basic {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	client, err := h.db.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "unknown client")
+		return
+	}
+	if !client.Public() && subtle.ConstantTimeCompare([]byte(utils.HashToken(clientSecret)), []byte(client.ClientSecretHash)) != 1 {
+		log.Printf("⚠️  Wrong client secret for %s from %s", client.ClientID, utils.GetIPAddress(r))
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	// Step 2: Burn the code, then check it belongs to this client, redirect uri and pkce verifier
+	code, err := h.db.ConsumeAuthorizationCode(r.Context(), utils.HashToken(r.PostForm.Get("code")))
+	if err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the code is invalid or was already used")
+		return
+	}
+	if code.ClientID != client.ClientID ||
+		code.RedirectURI != r.PostForm.Get("redirect_uri") ||
+		!time.Now().UTC().Before(code.ExpiresAt) ||
+		!oidc.VerifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the code doesn't match this request")
+		return
+	}
+
+	user, err := h.db.GetUserByID(r.Context(), code.UserID)
+	if err != nil || user.SuspendedAt != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the account is no longer available")
+		return
+	}
+
+	// Step 3: Sign the tokens
+	now := time.Now().UTC()
+	idClaims := oidc.Claims{
+		"iss":  oidcIssuer(),
+		"sub":  strconv.Itoa(user.ID),
+		"aud":  client.ClientID,
+		"azp":  client.ClientID,
+		"iat":  now.Unix(),
+		"exp":  now.Add(config.OIDCTokenTTL).Unix(),
+		"role": user.Role,
+	}
+	if !code.AuthTime.IsZero() {
+		idClaims["auth_time"] = code.AuthTime.Unix()
+	}
+	if code.Nonce != "" {
+		idClaims["nonce"] = code.Nonce
+	}
+	for claim, value := range userClaims(user, code.Scopes) {
+		idClaims[claim] = value
+	}
+	idToken, err := h.keys.Sign(r.Context(), oidc.TypeIDToken, idClaims)
+	if err != nil {
+		log.Printf("❌ Failed to sign id token: %v", err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "failed to sign the id token")
+		return
+	}
+
+	// the access token is only good for our userinfo endpoint
+	accessToken, err := h.keys.Sign(r.Context(), oidc.TypeAccessToken, oidc.Claims{
+		"iss":       oidcIssuer(),
+		"sub":       strconv.Itoa(user.ID),
+		"aud":       oidcIssuer() + "/oauth2/userinfo",
+		"client_id": client.ClientID,
+		"scope":     strings.Join(code.Scopes, " "),
+		"iat":       now.Unix(),
+		"exp":       now.Add(config.OIDCTokenTTL).Unix(),
+		"jti":       utils.GenerateSecureToken(16),
+	})
+	if err != nil {
+		log.Printf("❌ Failed to sign access token: %v", err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "failed to sign the access token")
+		return
+	}
+
+	// Step 4: Audit
+	h.db.CreateAuditLog(
+		r.Context(),
+		&user.ID,
+		"oidc_login",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		client.ClientID,
+	)
+
+	log.Printf("✅ Issued OIDC tokens for user ID %d to %s", user.ID, client.ClientID)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(config.OIDCTokenTTL.Seconds()),
+		"id_token":     idToken,
+		"scope":        strings.Join(code.Scopes, " "),
+	})
+}
+
+// UserInfoHandler returns the claims the access token's scopes allow
+// GET or POST /oauth2/userinfo with Authorization: Bearer <access token>
+func (h *AuthHandler) UserInfoHandler(w http.ResponseWriter, r *http.Request) {
+	invalid := func() {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		oauthError(w, http.StatusUnauthorized, "invalid_token", "the access token is invalid or expired")
+	}
+
+	scheme, raw, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		invalid()
+		return
+	}
+
+	claims, err := h.keys.Verify(r.Context(), strings.TrimSpace(raw), oidc.TypeAccessToken)
+	if err != nil || claims["iss"] != oidcIssuer() || claims["aud"] != oidcIssuer()+"/oauth2/userinfo" {
+		invalid()
+		return
+	}
+	sub, _ := claims["sub"].(string)
+	userID, err := strconv.Atoi(sub)
+	if err != nil {
+		invalid()
+		return
+	}
+
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil || user.SuspendedAt != nil {
+		invalid()
+		return
+	}
+
+	scope, _ := claims["scope"].(string)
+	info := userClaims(user, strings.Fields(scope))
+	info["sub"] = sub
+	info["role"] = user.Role
+
+	utils.ResponseJSON(w, http.StatusOK, info)
+}
+
+// sessionUser reads the logged in user and when they logged in from the auth-session, nil when nobody is logged in
+func (h *AuthHandler) sessionUser(r *http.Request) (*database.User, time.Time) {
+	session, _ := config.GetSessionStore().Get(r, "auth-session")
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		return nil, time.Time{}
+	}
+
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		return nil, time.Time{}
+	}
+
+	// sessions from before auth_time was saved count as old, max_age sends those users through login again
+	var authTime time.Time
+	if loggedInAt, ok := session.Values["auth_time"].(int64); ok {
+		authTime = time.Unix(loggedInAt, 0).UTC()
+	}
+	return user, authTime
+}
+
+// issueAuthorizationCode stores a code for the request and returns the redirect back to the app
+func (h *AuthHandler) issueAuthorizationCode(r *http.Request, pending authorizeRequest, userID int, authTime time.Time) (string, error) {
+	raw := utils.GenerateSecureToken(32)
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	code := &database.AuthorizationCode{
+		CodeHash:      utils.HashToken(raw),
+		ClientID:      pending.ClientID,
+		UserID:        userID,
+		RedirectURI:   pending.RedirectURI,
+		Scopes:        pending.Scopes,
+		Nonce:         pending.Nonce,
+		CodeChallenge: pending.CodeChallenge,
+		AuthTime:      authTime.Truncate(time.Microsecond),
+		ExpiresAt:     now.Add(config.AuthorizationCodeTTL),
+	}
+	if err := h.db.CreateAuthorizationCode(r.Context(), code); err != nil {
+		return "", err
+	}
+
+	return authorizeRedirect(pending.RedirectURI, url.Values{
+		"code":  {raw},
+		"state": {pending.State},
+	}), nil
+}
+
+// userClaims are the claims about the user the scopes allow, shared by the id token and userinfo
+func userClaims(user *database.User, scopes []string) map[string]interface{} {
+	claims := make(map[string]interface{})
+	if oidc.HasScope(scopes, oidc.ScopeEmail) {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.EmailVerified
+	}
+	if oidc.HasScope(scopes, oidc.ScopeProfile) {
+		claims["name"] = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		claims["given_name"] = user.FirstName
+		claims["family_name"] = user.LastName
+		if user.AvatarURL != "" {
+			claims["picture"] = user.AvatarURL
+		}
+		if user.PreferredLanguage != "" {
+			claims["locale"] = user.PreferredLanguage
+		}
+		if user.Timezone != "" {
+			claims["zoneinfo"] = user.Timezone
+		}
+	}
+	return claims
+}
+
+// authorizeRedirect adds params to the app's redirect uri, keeping any query it already has and leaving out empty values
+func authorizeRedirect(redirectURI string, params url.Values) string {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := target.Query()
+	for key, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	target.RawQuery = query.Encode()
+	return target.String()
+}
+
+// oauthError writes an error in the shape the oauth spec wants instead of our usual {"error": message}
+func oauthError(w http.ResponseWriter, status int, code, description string) {
+	utils.ResponseJSON(w, status, map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+// savePendingAuthorization parks an authorize request in the auth-session until the user answers the consent screen,
+// only the newest request is kept
+func savePendingAuthorization(w http.ResponseWriter, r *http.Request, pending authorizeRequest) error {
+	encoded, err := json.Marshal(pending)
+	if err != nil {
+		return fmt.Errorf("unable to encode authorization request: %w", err)
+	}
+	session, _ := config.GetSessionStore().Get(r, "auth-session")
+	session.Values["oidc_request"] = string(encoded)
+	return session.Save(r, w)
+}
+
+// loadPendingAuthorization returns the parked request when its id matches
+func loadPendingAuthorization(r *http.Request, id string) (authorizeRequest, bool) {
+	var pending authorizeRequest
+	session, _ := config.GetSessionStore().Get(r, "auth-session")
+	encoded, ok := session.Values["oidc_request"].(string)
+	if !ok || id == "" {
+		return pending, false
+	}
+	if err := json.Unmarshal([]byte(encoded), &pending); err != nil {
+		return pending, false
+	}
+	return pending, subtle.ConstantTimeCompare([]byte(pending.ID), []byte(id)) == 1
+}
+
+// clearPendingAuthorization drops the parked request
+func clearPendingAuthorization(w http.ResponseWriter, r *http.Request) error {
+	session, _ := config.GetSessionStore().Get(r, "auth-session")
+	delete(session.Values, "oidc_request")
+	return session.Save(r, w)
+}
+
+// coversScopes reports whether every wanted scope was granted
+func coversScopes(granted, wanted []string) bool {
+	for _, scope := range wanted {
+		if !oidc.HasScope(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsString reports whether value is in values
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,309 @@
+// backend/oidc/keys.go
+package oidc
+
+// the signing keys live in oauth_signing_keys so every replica signs with the same key.
+// the newest key signs, a new one is created when it's older than KeyRotationInterval, and older keys
+// stay in the jwks for keyRetention after that so tokens they signed still verify.
+// two replicas rotating at the same moment just create two keys, both get published and the newest one wins.
+//
+// the private keys are encrypted with AES-GCM before they go in the table, the key for that comes from
+// OIDC_KEY_ENCRYPTION_KEY and never touches the database, so a dump or backup alone can't sign tokens.
+// rows from before encryption (plain PEM) still load and get encrypted in place the first time they're read
+
+import (
+	"backend/database"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// how long one key signs before the next one takes over
+	KeyRotationInterval = 30 * 24 * time.Hour
+	// a retired key stays published this long, way longer than any token we sign lives
+	keyRetention = 7 * 24 * time.Hour
+	// how often a replica looks for keys another replica created
+	keyCacheTTL = 5 * time.Minute
+	rsaKeyBits  = 2048
+	// marks an encrypted private_key, the rest is base64(nonce + ciphertext)
+	sealedKeyPrefix = "v1:"
+)
+
+// KeySet signs and verifies our jwts and publishes the public keys for clients
+type KeySet struct {
+	db   *database.Postgres
+	aead cipher.AEAD // encrypts the private keys at rest
+
+	mu       sync.Mutex
+	keys     []signingKey // newest first
+	loadedAt time.Time
+}
+
+type signingKey struct {
+	kid       string
+	private   *rsa.PrivateKey
+	createdAt time.Time
+}
+
+// JWK is one public key in the jwks document
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS is what /oauth2/jwks serves
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewKeySet creates a key set backed by the database, keys are loaded (and created) on first use.
+// encryptionKey encrypts the private keys in the database, any long random string works and it must not change
+func NewKeySet(db *database.Postgres, encryptionKey string) (*KeySet, error) {
+	if encryptionKey == "" {
+		return nil, errors.New("a key encryption key is required")
+	}
+
+	// sha256 turns whatever string we got into an AES-256 key
+	sum := sha256.Sum256([]byte(encryptionKey))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, fmt.Errorf("unable to create key cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create key cipher: %w", err)
+	}
+
+	return &KeySet{db: db, aead: aead}, nil
+}
+
+// NewKeySetFromEnv is NewKeySet with the key from OIDC_KEY_ENCRYPTION_KEY
+func NewKeySetFromEnv(db *database.Postgres) (*KeySet, error) {
+	ks, err := NewKeySet(db, os.Getenv("OIDC_KEY_ENCRYPTION_KEY"))
+	if err != nil {
+		return nil, fmt.Errorf("OIDC_KEY_ENCRYPTION_KEY: %w", err)
+	}
+	return ks, nil
+}
+
+// Sign signs claims with the current key, typ is TypeIDToken or TypeAccessToken
+func (ks *KeySet) Sign(ctx context.Context, typ string, claims Claims) (string, error) {
+	keys, err := ks.current(ctx)
+	if err != nil {
+		return "", err
+	}
+	return signJWT(keys[0].private, keys[0].kid, typ, claims)
+}
+
+// Verify checks a token we signed and returns its claims, the typ header has to match so tokens can't be swapped
+func (ks *KeySet) Verify(ctx context.Context, token, typ string) (Claims, error) {
+	header, parts, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" || header.Typ != typ {
+		return nil, ErrInvalidToken
+	}
+
+	keys, err := ks.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if key.kid == header.Kid {
+			return verifyJWT(&key.private.PublicKey, parts, time.Now())
+		}
+	}
+	return nil, ErrInvalidToken
+}
+
+// JWKS lists the public half of every published key
+func (ks *KeySet) JWKS(ctx context.Context) (JWKS, error) {
+	keys, err := ks.current(ctx)
+	if err != nil {
+		return JWKS{}, err
+	}
+
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range keys {
+		public := key.private.PublicKey
+		set.Keys = append(set.Keys, JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: "RS256",
+			Kid: key.kid,
+			N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		})
+	}
+	return set, nil
+}
+
+// Rotate creates a new signing key right away, for when a key may have leaked.
+// the old keys stay published, delete them from oauth_signing_keys to make their tokens stop verifying
+func (ks *KeySet) Rotate(ctx context.Context) (string, error) {
+	kid, err := ks.createKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// make the next call reload so every method sees the new key
+	ks.mu.Lock()
+	ks.loadedAt = time.Time{}
+	ks.mu.Unlock()
+
+	return kid, nil
+}
+
+// createKey generates a key and stores it
+func (ks *KeySet) createKey(ctx context.Context) (string, error) {
+	private, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	if err != nil {
+		return "", fmt.Errorf("unable to generate signing key: %w", err)
+	}
+
+	kidBytes := make([]byte, 8)
+	if _, err := rand.Read(kidBytes); err != nil {
+		return "", fmt.Errorf("unable to generate key id: %w", err)
+	}
+
+	kid := hex.EncodeToString(kidBytes)
+	sealed, err := ks.seal(kid, x509.MarshalPKCS1PrivateKey(private))
+	if err != nil {
+		return "", err
+	}
+
+	key := &database.SigningKey{
+		KID:        kid,
+		PrivateKey: sealed,
+		CreatedAt:  time.Now().UTC().Truncate(time.Microsecond),
+	}
+	if err := ks.db.CreateSigningKey(ctx, key); err != nil {
+		return "", err
+	}
+
+	return key.KID, nil
+}
+
+// Cleanup deletes keys that are no longer published
+func (ks *KeySet) Cleanup(ctx context.Context) error {
+	return ks.db.DeleteSigningKeysBefore(ctx, time.Now().UTC().Add(-KeyRotationInterval-keyRetention))
+}
+
+// current returns the published keys newest first, reloading them now and then and rotating when the newest is too old
+func (ks *KeySet) current(ctx context.Context) ([]signingKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	now := time.Now().UTC()
+	if len(ks.keys) > 0 && now.Sub(ks.loadedAt) < keyCacheTTL && now.Sub(ks.keys[0].createdAt) < KeyRotationInterval {
+		return ks.keys, nil
+	}
+
+	keys, err := ks.load(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 || now.Sub(keys[0].createdAt) >= KeyRotationInterval {
+		if _, err := ks.createKey(ctx); err != nil {
+			return nil, err
+		}
+		if keys, err = ks.load(ctx, now); err != nil {
+			return nil, err
+		}
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("no signing key after rotation")
+		}
+		log.Printf("🔑 Rotated OIDC signing key, now signing with %s", keys[0].kid)
+	}
+
+	ks.keys = keys
+	ks.loadedAt = now
+	return keys, nil
+}
+
+// load reads and parses the published keys
+func (ks *KeySet) load(ctx context.Context, now time.Time) ([]signingKey, error) {
+	rows, err := ks.db.ListSigningKeys(ctx, now.Add(-KeyRotationInterval-keyRetention))
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []signingKey
+	for _, row := range rows {
+		der, err := ks.open(ctx, row)
+		if err != nil {
+			log.Printf("⚠️  Signing key %s can't be read, skipping it: %v", row.KID, err)
+			continue
+		}
+		private, err := x509.ParsePKCS1PrivateKey(der)
+		if err != nil {
+			log.Printf("⚠️  Signing key %s can't be parsed, skipping it: %v", row.KID, err)
+			continue
+		}
+		keys = append(keys, signingKey{kid: row.KID, private: private, createdAt: row.CreatedAt})
+	}
+	return keys, nil
+}
+
+// seal encrypts a PKCS#1 key for the database, the kid goes in as additional data so a sealed key
+// copied onto another row doesn't decrypt
+func (ks *KeySet) seal(kid string, der []byte) (string, error) {
+	nonce := make([]byte, ks.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("unable to generate nonce: %w", err)
+	}
+	sealed := ks.aead.Seal(nonce, nonce, der, []byte(kid))
+	return sealedKeyPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a stored key to PKCS#1 DER. a plain PEM row from before encryption is encrypted in place
+func (ks *KeySet) open(ctx context.Context, row database.SigningKey) ([]byte, error) {
+	if !strings.HasPrefix(row.PrivateKey, sealedKeyPrefix) {
+		block, _ := pem.Decode([]byte(row.PrivateKey))
+		if block == nil {
+			return nil, errors.New("not encrypted and not valid PEM")
+		}
+		sealed, err := ks.seal(row.KID, block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if err := ks.db.UpdateSigningKey(ctx, row.KID, sealed); err != nil {
+			log.Printf("⚠️  Failed to encrypt signing key %s: %v", row.KID, err)
+		} else {
+			log.Printf("🔒 Encrypted signing key %s stored before key encryption", row.KID)
+		}
+		return block.Bytes, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(row.PrivateKey, sealedKeyPrefix))
+	if err != nil || len(sealed) < ks.aead.NonceSize() {
+		return nil, errors.New("malformed encrypted key")
+	}
+	nonce, ciphertext := sealed[:ks.aead.NonceSize()], sealed[ks.aead.NonceSize():]
+	der, err := ks.aead.Open(nil, nonce, ciphertext, []byte(row.KID))
+	if err != nil {
+		return nil, errors.New("unable to decrypt, OIDC_KEY_ENCRYPTION_KEY may have changed")
+	}
+	return der, nil
+}
@@ -0,0 +1,123 @@
+// backend/models/organizations.go
+package models
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// slugs end up in urls and in users.provider (saml:<slug>), so keep them boring
+var orgSlugRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,39}$`)
+
+// emailDomainRegex is loose on purpose, it only has to keep out things that are obviously not a domain
+var emailDomainRegex = regexp.MustCompile(`^[a-z0-9.-]+\.[a-z]{2,}$`)
+
+// used by the admin endpoint that adds a school or college, the email domains can also be set later
+type CreateOrganizationRequest struct {
+	Slug         string   `json:"slug"`
+	Name         string   `json:"name"`
+	EmailDomains []string `json:"emailDomains"`
+}
+
+// used by the admin endpoint that sets the domains an organization can provision accounts in over SCIM
+type OrganizationEmailDomainsRequest struct {
+	EmailDomains []string `json:"emailDomains"`
+}
+
+// uploading an organization's idp metadata, the attribute names default to first_name, last_name, role and email
+type SAMLConnectionRequest struct {
+	MetadataXML        string   `json:"metadataXml"`
+	EmailDomains       []string `json:"emailDomains"`
+	DefaultRole        string   `json:"defaultRole"`
+	EmailAttribute     string   `json:"emailAttribute"`
+	FirstNameAttribute string   `json:"firstNameAttribute"`
+	LastNameAttribute  string   `json:"lastNameAttribute"`
+	RoleAttribute      string   `json:"roleAttribute"`
+	Enabled            *bool    `json:"enabled"`
+}
+
+// used by the admin endpoint that creates a token for an organization's student information system
+type CreateSCIMTokenRequest struct {
+	Name string `json:"name"`
+}
+
+func (orgrequest *CreateOrganizationRequest) Validate() error {
+	orgrequest.Slug = strings.ToLower(strings.TrimSpace(orgrequest.Slug))
+	orgrequest.Name = strings.TrimSpace(orgrequest.Name)
+	if !orgSlugRegex.MatchString(orgrequest.Slug) {
+		return errors.New("slug must be 2 to 40 lowercase letters, digits or dashes")
+	}
+	if orgrequest.Name == "" || len(orgrequest.Name) > 255 {
+		return errors.New("name must be between 1 and 255 characters")
+	}
+	return normalizeEmailDomains(orgrequest.EmailDomains)
+}
+
+func (domainsrequest *OrganizationEmailDomainsRequest) Validate() error {
+	// without domains SCIM would accept any email, including other schools' addresses
+	if len(domainsrequest.EmailDomains) == 0 {
+		return errors.New("at least one email domain is required")
+	}
+	return normalizeEmailDomains(domainsrequest.EmailDomains)
+}
+
+// the metadata itself is parsed by the handler, this checks everything around it
+func (samlrequest *SAMLConnectionRequest) Validate() error {
+	if strings.TrimSpace(samlrequest.MetadataXML) == "" {
+		return errors.New("the identity provider metadata is required")
+	}
+	if len(samlrequest.MetadataXML) > 1<<20 {
+		return errors.New("the metadata must be smaller than 1 MB")
+	}
+
+	// without domains any email the idp sends would be accepted, including other schools' addresses
+	if len(samlrequest.EmailDomains) == 0 {
+		return errors.New("at least one email domain is required")
+	}
+	if err := normalizeEmailDomains(samlrequest.EmailDomains); err != nil {
+		return err
+	}
+
+	if samlrequest.DefaultRole == "" {
+		samlrequest.DefaultRole = RoleStudent
+	}
+	if !SSOAssignable(samlrequest.DefaultRole) {
+		return errors.New("default role must be student or teacher")
+	}
+
+	attributes := []*string{
+		&samlrequest.EmailAttribute, &samlrequest.FirstNameAttribute, &samlrequest.LastNameAttribute, &samlrequest.RoleAttribute,
+	}
+	defaults := []string{"email", "first_name", "last_name", "role"}
+	for i, attribute := range attributes {
+		*attribute = strings.TrimSpace(*attribute)
+		if *attribute == "" {
+			*attribute = defaults[i]
+		}
+		if len(*attribute) > 255 {
+			return errors.New("attribute names must be at most 255 characters")
+		}
+	}
+	return nil
+}
+
+func (tokenrequest *CreateSCIMTokenRequest) Validate() error {
+	tokenrequest.Name = strings.TrimSpace(tokenrequest.Name)
+	if tokenrequest.Name == "" || len(tokenrequest.Name) > 100 {
+		return errors.New("name must be between 1 and 100 characters")
+	}
+	return nil
+}
+
+// normalizeEmailDomains lowercases the domains in place and drops a leading @, "@School.edu" is school.edu
+func normalizeEmailDomains(domains []string) error {
+	for i, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+		if !emailDomainRegex.MatchString(domain) {
+			return errors.New("invalid email domain: " + domains[i])
+		}
+		domains[i] = domain
+	}
+	return nil
+}
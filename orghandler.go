@@ -0,0 +1,429 @@
+// backend/handlers/organization_handlers.go
+package handlers
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/middleware"
+	"backend/models"
+	"backend/sso"
+	"backend/utils"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ============================================
+// ORGANIZATIONS AND THEIR SSO SETTINGS (platform admins)
+// ============================================
+
+// SAMLConnectionResponse is an organization's sso settings plus the urls their it team needs
+type SAMLConnectionResponse struct {
+	*database.SAMLConnection
+	MetadataURL string `json:"metadataUrl"`
+	ACSURL      string `json:"acsUrl"`
+	LoginURL    string `json:"loginUrl"`
+}
+
+// one per SIS plus a spare for rotating, more than this is tokens nobody cleans up
+const maxSCIMTokensPerOrganization = 5
+
+// CreateSCIMTokenResponse is the only time the token itself is ever shown
+type CreateSCIMTokenResponse struct {
+	database.SCIMToken
+	Token   string `json:"token"`
+	SCIMURL string `json:"scimUrl"`
+}
+
+// ListOrganizationsHandler lists every organization
+// GET /api/admin/organizations
+func (h *AuthHandler) ListOrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+	orgs, err := h.db.ListOrganizations(r.Context())
+	if err != nil {
+		log.Printf("❌ Failed to list organizations: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to load organizations")
+		return
+	}
+	if orgs == nil {
+		orgs = []database.Organization{}
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, orgs)
+}
+
+// CreateOrganizationHandler adds a school or college
+// POST /api/admin/organizations
+func (h *AuthHandler) CreateOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	actor, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	var req models.CreateOrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := h.db.GetOrganizationBySlug(r.Context(), req.Slug); err == nil {
+		utils.ErrorResponseJSON(w, http.StatusConflict, "An organization with this slug already exists")
+		return
+	}
+
+	org := &database.Organization{
+		Slug:         req.Slug,
+		Name:         req.Name,
+		EmailDomains: req.EmailDomains,
+		CreatedAt:    time.Now().UTC().Truncate(time.Microsecond),
+	}
+	if err := h.db.CreateOrganization(r.Context(), org); err != nil {
+		log.Printf("❌ Failed to create organization: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to create organization")
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&actor.ID,
+		"organization_created",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		org.Slug,
+	)
+
+	utils.ResponseJSON(w, http.StatusCreated, org)
+}
+
+// PutOrganizationEmailDomainsHandler sets the domains an organization can provision accounts in over SCIM
+// PUT /api/admin/organizations/{slug}/email-domains
+func (h *AuthHandler) PutOrganizationEmailDomainsHandler(w http.ResponseWriter, r *http.Request) {
+	actor, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	org, err := h.db.GetOrganizationBySlug(r.Context(), mux.Vars(r)["slug"])
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "Organization not found")
+		return
+	}
+
+	var req models.OrganizationEmailDomainsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.db.SetOrganizationEmailDomains(r.Context(), org.ID, req.EmailDomains); err != nil {
+		log.Printf("❌ Failed to save organization email domains: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to save email domains")
+		return
+	}
+	org.EmailDomains = req.EmailDomains
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&actor.ID,
+		"organization_email_domains_changed",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		org.Slug+" "+strings.Join(org.EmailDomains, ","),
+	)
+
+	utils.ResponseJSON(w, http.StatusOK, org)
+}
+
+// GetSAMLConnectionHandler shows an organization's sso settings
+// GET /api/admin/organizations/{slug}/saml
+func (h *AuthHandler) GetSAMLConnectionHandler(w http.ResponseWriter, r *http.Request) {
+	org, err := h.db.GetOrganizationBySlug(r.Context(), mux.Vars(r)["slug"])
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "Organization not found")
+		return
+	}
+
+	conn, err := h.db.GetSAMLConnection(r.Context(), org.ID)
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "Single sign on is not set up for this organization")
+		return
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, samlConnectionResponse(org, conn))
+}
+
+// PutSAMLConnectionHandler uploads an organization's idp metadata and attribute mapping, replacing what was there
+// PUT /api/admin/organizations/{slug}/saml
+func (h *AuthHandler) PutSAMLConnectionHandler(w http.ResponseWriter, r *http.Request) {
+	actor, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	org, err := h.db.GetOrganizationBySlug(r.Context(), mux.Vars(r)["slug"])
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "Organization not found")
+		return
+	}
+
+	// Step 1: Parse and validate, metadata is capped at 1 MB plus room for the rest of the json
+	var req models.SAMLConnectionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2<<20)).Decode(&req); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entityID, err := sso.ParseIDPMetadata([]byte(req.MetadataXML))
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Step 2: Save
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+	conn := &database.SAMLConnection{
+		OrganizationID:     org.ID,
+		IDPEntityID:        entityID,
+		IDPMetadata:        req.MetadataXML,
+		EmailDomains:       req.EmailDomains,
+		DefaultRole:        req.DefaultRole,
+		EmailAttribute:     req.EmailAttribute,
+		FirstNameAttribute: req.FirstNameAttribute,
+		LastNameAttribute:  req.LastNameAttribute,
+		RoleAttribute:      req.RoleAttribute,
+		Enabled:            enabled,
+		UpdatedAt:          time.Now().UTC().Truncate(time.Microsecond),
+	}
+	if err := h.db.SaveSAMLConnection(r.Context(), conn); err != nil {
+		log.Printf("❌ Failed to save SAML connection: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to save single sign on settings")
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&actor.ID,
+		"saml_connection_saved",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		org.Slug+" idp "+entityID+" domains "+strings.Join(conn.EmailDomains, ","),
+	)
+
+	log.Printf("✅ SAML connection for %s saved (idp %s)", org.Slug, entityID)
+
+	utils.ResponseJSON(w, http.StatusOK, samlConnectionResponse(org, conn))
+}
+
+// DeleteSAMLConnectionHandler turns sso off for an organization, its users keep their accounts
+// DELETE /api/admin/organizations/{slug}/saml
+func (h *AuthHandler) DeleteSAMLConnectionHandler(w http.ResponseWriter, r *http.Request) {
+	actor, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	org, err := h.db.GetOrganizationBySlug(r.Context(), mux.Vars(r)["slug"])
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "Organization not found")
+		return
+	}
+
+	if err := h.db.DeleteSAMLConnection(r.Context(), org.ID); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "Single sign on is not set up for this organization")
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&actor.ID,
+		"saml_connection_deleted",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		org.Slug,
+	)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"message": "Single sign on turned off",
+	})
+}
+
+// ListSCIMTokensHandler lists an organization's provisioning tokens, without the tokens themselves
+// GET /api/admin/organizations/{slug}/scim-tokens
+func (h *AuthHandler) ListSCIMTokensHandler(w http.ResponseWriter, r *http.Request) {
+	org, err := h.db.GetOrganizationBySlug(r.Context(), mux.Vars(r)["slug"])
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "Organization not found")
+		return
+	}
+
+	tokens, err := h.db.ListSCIMTokens(r.Context(), org.ID)
+	if err != nil {
+		log.Printf("❌ Failed to list SCIM tokens: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to load SCIM tokens")
+		return
+	}
+	if tokens == nil {
+		tokens = []database.SCIMToken{}
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, tokens)
+}
+
+// CreateSCIMTokenHandler creates the bearer token an organization's student information system provisions users with
+// POST /api/admin/organizations/{slug}/scim-tokens
+func (h *AuthHandler) CreateSCIMTokenHandler(w http.ResponseWriter, r *http.Request) {
+	actor, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	org, err := h.db.GetOrganizationBySlug(r.Context(), mux.Vars(r)["slug"])
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "Organization not found")
+		return
+	}
+
+	// SCIM only provisions emails in the organization's domains, a token before they're set could do nothing
+	if len(org.EmailDomains) == 0 {
+		utils.ErrorResponseJSON(w, http.StatusConflict, "Set the organization's email domains before creating a SCIM token")
+		return
+	}
+
+	// Step 1: Parse and validate
+	var req models.CreateSCIMTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tokens, err := h.db.ListSCIMTokens(r.Context(), org.ID)
+	if err != nil {
+		log.Printf("❌ Failed to list SCIM tokens: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to create SCIM token")
+		return
+	}
+	if len(tokens) >= maxSCIMTokensPerOrganization {
+		utils.ErrorResponseJSON(w, http.StatusConflict, fmt.Sprintf("An organization can have at most %d SCIM tokens, revoke one first", maxSCIMTokensPerOrganization))
+		return
+	}
+
+	// Step 2: Create the token, the database only ever sees its hash
+	raw := middleware.SCIMTokenPrefix + utils.GenerateSecureToken(32)
+	token := &database.SCIMToken{
+		OrganizationID: org.ID,
+		Name:           req.Name,
+		TokenHash:      utils.HashToken(raw),
+		TokenPrefix:    raw[:len(middleware.SCIMTokenPrefix)+4],
+		CreatedAt:      time.Now().UTC().Truncate(time.Microsecond),
+	}
+	if err := h.db.CreateSCIMToken(r.Context(), token); err != nil {
+		log.Printf("❌ Failed to create SCIM token: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to create SCIM token")
+		return
+	}
+
+	// Step 3: Audit
+	h.db.CreateAuditLog(
+		r.Context(),
+		&actor.ID,
+		"scim_token_created",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		fmt.Sprintf("%s token %d %q", org.Slug, token.ID, token.Name),
+	)
+
+	log.Printf("🔑 User ID %d created SCIM token %d for %s", actor.ID, token.ID, org.Slug)
+
+	utils.ResponseJSON(w, http.StatusCreated, CreateSCIMTokenResponse{
+		SCIMToken: *token,
+		Token:     raw,
+		SCIMURL:   strings.TrimSuffix(config.GetBackendURL(), "/") + "/scim/v2",
+	})
+}
+
+// DeleteSCIMTokenHandler revokes a provisioning token, the SIS gets a 401 from its next request on
+// DELETE /api/admin/organizations/{slug}/scim-tokens/{id}
+func (h *AuthHandler) DeleteSCIMTokenHandler(w http.ResponseWriter, r *http.Request) {
+	actor, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	org, err := h.db.GetOrganizationBySlug(r.Context(), mux.Vars(r)["slug"])
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "Organization not found")
+		return
+	}
+
+	tokenID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid token id")
+		return
+	}
+
+	if err := h.db.DeleteSCIMToken(r.Context(), org.ID, tokenID); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "SCIM token not found")
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&actor.ID,
+		"scim_token_revoked",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		fmt.Sprintf("%s token %d", org.Slug, tokenID),
+	)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"message": "SCIM token revoked",
+	})
+}
+
+// samlConnectionResponse adds the sp urls to a connection
+func samlConnectionResponse(org *database.Organization, conn *database.SAMLConnection) SAMLConnectionResponse {
+	base := strings.TrimSuffix(config.GetBackendURL(), "/") + "/api/auth/saml/" + url.PathEscape(org.Slug)
+	return SAMLConnectionResponse{
+		SAMLConnection: conn,
+		MetadataURL:    base + "/metadata",
+		ACSURL:         base + "/acs",
+		LoginURL:       base + "/login",
+	}
+}
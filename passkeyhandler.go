@@ -0,0 +1,446 @@
+// backend/handlers/passkey_handlers.go
+package handlers
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/utils"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gorilla/mux"
+)
+
+// ============================================
+// PASSKEYS (WEBAUTHN)
+// ============================================
+
+// the challenge from the begin step has to survive until the finish step for 5 minutes,
+// it's stored server side and the signed cookie only points at it
+const (
+	passkeyCeremonySession = "webauthn-ceremony"
+	passkeyCeremonyTTL     = 5 * time.Minute
+)
+
+// passkeyUser adapts our user + their passkeys to the webauthn.User interface
+type passkeyUser struct {
+	user        *database.User
+	credentials []database.WebAuthnCredential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	return passkeyUserHandle(u.user.ID)
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	if name := strings.TrimSpace(u.user.FirstName + " " + u.user.LastName); name != "" {
+		return name
+	}
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, cred := range u.credentials {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(cred.Transports))
+		for _, transport := range cred.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+		credentials = append(credentials, webauthn.Credential{
+			ID:              cred.CredentialID,
+			PublicKey:       cred.PublicKey,
+			AttestationType: cred.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: cred.BackupEligible,
+				BackupState:    cred.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    cred.AAGUID,
+				SignCount: uint32(cred.SignCount),
+			},
+		})
+	}
+	return credentials
+}
+
+// the user handle stored on the authenticator is our user id as 8 bytes, it never contains the email
+func passkeyUserHandle(userID int) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return handle
+}
+
+// loadPasskeyUser fetches the user and the passkeys they already have
+func (h *AuthHandler) loadPasskeyUser(r *http.Request, userID int) (*passkeyUser, error) {
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := h.db.GetWebAuthnCredentialsByUser(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	return &passkeyUser{user: user, credentials: credentials}, nil
+}
+
+// savePasskeyCeremony keeps the begin step's session data in webauthn_challenges until the finish step,
+// the cookie only gets a random handle pointing at the row
+func (h *AuthHandler) savePasskeyCeremony(w http.ResponseWriter, r *http.Request, kind string, data *webauthn.SessionData) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	handle := utils.GenerateSecureToken(32)
+	expiresAt := time.Now().UTC().Add(passkeyCeremonyTTL)
+	if err := h.db.CreateWebAuthnChallenge(r.Context(), utils.HashToken(handle), kind, string(raw), expiresAt); err != nil {
+		return err
+	}
+
+	session, _ := config.GetStore().Get(r, passkeyCeremonySession)
+	session.Values["handle"] = handle
+	session.Options.MaxAge = int(passkeyCeremonyTTL.Seconds())
+	return session.Save(r, w)
+}
+
+// loadPasskeyCeremony reads the begin step's data and deletes it, so every challenge is only good once
+// even when the same cookie is sent again
+func (h *AuthHandler) loadPasskeyCeremony(w http.ResponseWriter, r *http.Request, kind string) (*webauthn.SessionData, error) {
+	session, _ := config.GetStore().Get(r, passkeyCeremonySession)
+	handle, _ := session.Values["handle"].(string)
+
+	session.Values = make(map[interface{}]interface{})
+	session.Options.MaxAge = -1
+	session.Save(r, w)
+
+	if handle == "" {
+		return nil, errors.New("no passkey ceremony in progress")
+	}
+	raw, err := h.db.ConsumeWebAuthnChallenge(r.Context(), utils.HashToken(handle), kind)
+	if err != nil {
+		return nil, err
+	}
+
+	var data webauthn.SessionData
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// PasskeyRegisterBeginHandler returns the options navigator.credentials.create() needs
+// POST /api/auth/passkeys/register/begin
+func (h *AuthHandler) PasskeyRegisterBeginHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	pu, err := h.loadPasskeyUser(r, userID)
+	if err != nil {
+		log.Printf("❌ Failed to load passkey user: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	// dont let the same authenticator register twice, and ask for a discoverable credential
+	// so the login page can offer passkeys without the user typing their email
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(pu.credentials))
+	for _, cred := range pu.WebAuthnCredentials() {
+		exclusions = append(exclusions, cred.Descriptor())
+	}
+
+	options, sessionData, err := config.GetWebAuthn().BeginRegistration(
+		pu,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		log.Printf("❌ Failed to begin passkey registration: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to start passkey registration")
+		return
+	}
+
+	if err := h.savePasskeyCeremony(w, r, "register", sessionData); err != nil {
+		log.Printf("❌ Failed to save passkey ceremony: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to start passkey registration")
+		return
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, options)
+}
+
+// PasskeyRegisterFinishHandler verifies the browser's attestation and saves the passkey
+// POST /api/auth/passkeys/register/finish?name=My+Laptop
+func (h *AuthHandler) PasskeyRegisterFinishHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	sessionData, err := h.loadPasskeyCeremony(w, r, "register")
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Passkey registration expired, please try again")
+		return
+	}
+
+	pu, err := h.loadPasskeyUser(r, userID)
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	credential, err := config.GetWebAuthn().FinishRegistration(pu, *sessionData, r)
+	if err != nil {
+		log.Printf("❌ Passkey registration failed: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Passkey registration failed")
+		return
+	}
+
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		name = describeDevice(r.UserAgent())
+	}
+	if len(name) > 100 {
+		name = name[:100]
+	}
+
+	transports := make([]string, 0, len(credential.Transport))
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+
+	cred := &database.WebAuthnCredential{
+		UserID:          userID,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       int64(credential.Authenticator.SignCount),
+		Transports:      transports,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		Name:            name,
+	}
+	if err := h.db.CreateWebAuthnCredential(r.Context(), cred); err != nil {
+		log.Printf("❌ Failed to save passkey: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusConflict, "This passkey is already registered")
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		"passkey_registered",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		"",
+	)
+
+	log.Printf("✅ Passkey registered for user ID: %d", userID)
+
+	utils.ResponseJSON(w, http.StatusCreated, map[string]interface{}{
+		"message": "Passkey added",
+		"passkey": cred,
+	})
+}
+
+// PasskeyLoginBeginHandler returns the options navigator.credentials.get() needs
+// we use discoverable login so the browser lets the user pick their account
+// POST /api/auth/passkeys/login/begin
+func (h *AuthHandler) PasskeyLoginBeginHandler(w http.ResponseWriter, r *http.Request) {
+	options, sessionData, err := config.GetWebAuthn().BeginDiscoverableLogin()
+	if err != nil {
+		log.Printf("❌ Failed to begin passkey login: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to start passkey login")
+		return
+	}
+
+	if err := h.savePasskeyCeremony(w, r, "login", sessionData); err != nil {
+		log.Printf("❌ Failed to save passkey ceremony: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to start passkey login")
+		return
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, options)
+}
+
+// PasskeyLoginFinishHandler verifies the assertion and creates the same auth-session as LoginHandler
+// POST /api/auth/passkeys/login/finish
+func (h *AuthHandler) PasskeyLoginFinishHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("🔑 Passkey login request received")
+
+	// passkeys cant be guessed, but every finish call costs a signature check so it shares the login ip bucket
+	if !h.throttleIP(w, r, "login", loginIPRate) {
+		return
+	}
+
+	sessionData, err := h.loadPasskeyCeremony(w, r, "login")
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Passkey login expired, please try again")
+		return
+	}
+
+	// Step 1: The authenticator tells us which user it belongs to through the user handle
+	var pu *passkeyUser
+	findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+		if len(userHandle) != 8 {
+			return nil, errors.New("invalid user handle")
+		}
+		found, err := h.loadPasskeyUser(r, int(binary.BigEndian.Uint64(userHandle)))
+		if err != nil {
+			return nil, err
+		}
+		pu = found
+		return found, nil
+	}
+
+	// Step 2: Verify the signature against the stored public key
+	credential, err := config.GetWebAuthn().FinishDiscoverableLogin(findUser, *sessionData, r)
+	if err != nil || pu == nil {
+		log.Printf("❌ Passkey login failed: %v", err)
+		var userID *int
+		if pu != nil {
+			userID = &pu.user.ID
+		}
+		h.db.CreateAuditLog(
+			r.Context(),
+			userID,
+			"login_failed",
+			utils.GetIPAddress(r),
+			r.UserAgent(),
+			false,
+			"invalid passkey",
+		)
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Passkey login failed")
+		return
+	}
+	user := pu.user
+
+	// Step 3: A counter that went backwards means the authenticator may have been cloned
+	if credential.Authenticator.CloneWarning {
+		h.db.CreateAuditLog(
+			r.Context(),
+			&user.ID,
+			"login_failed",
+			utils.GetIPAddress(r),
+			r.UserAgent(),
+			false,
+			"passkey clone warning",
+		)
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Passkey login failed")
+		return
+	}
+	if err := h.db.UpdateWebAuthnCredentialUsage(r.Context(), credential.ID, int64(credential.Authenticator.SignCount), credential.Flags.BackupState); err != nil {
+		log.Printf("⚠️  Failed to update passkey usage: %v", err)
+	}
+
+	if config.RequireEmailVerification() && !user.EmailVerified {
+		utils.ErrorResponseJSON(w, http.StatusForbidden, "Please verify your email before logging in")
+		return
+	}
+	if h.accountSuspended(r, user) {
+		utils.ErrorResponseJSON(w, http.StatusForbidden, "This account has been suspended")
+		return
+	}
+
+	// Step 4: Create session, a passkey already proves possession so there is no second factor step
+	if err := h.startSession(w, r, user, "passkey"); err != nil {
+		log.Printf("❌ Failed to save session: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to create session")
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&user.ID,
+		"passkey_login",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		"",
+	)
+
+	log.Printf("✅ Passkey login successful: %s", user.Email)
+
+	user.PasswordHash = ""
+	utils.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Login successful",
+		"user":    user,
+	})
+}
+
+// ListPasskeysHandler lists the user's passkeys for the settings page
+// GET /api/auth/passkeys
+func (h *AuthHandler) ListPasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	credentials, err := h.db.GetWebAuthnCredentialsByUser(r.Context(), userID)
+	if err != nil {
+		log.Printf("❌ Failed to list passkeys: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to load passkeys")
+		return
+	}
+	if credentials == nil {
+		credentials = []database.WebAuthnCredential{}
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"passkeys": credentials,
+	})
+}
+
+// DeletePasskeyHandler removes one passkey
+// DELETE /api/auth/passkeys/{id}
+func (h *AuthHandler) DeletePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid passkey id")
+		return
+	}
+
+	if err := h.db.DeleteWebAuthnCredential(r.Context(), userID, id); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "Passkey not found")
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		"passkey_removed",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		"",
+	)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"message": "Passkey removed",
+	})
+}
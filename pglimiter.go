@@ -0,0 +1,35 @@
+// backend/ratelimit/postgres.go
+package ratelimit
+
+import (
+	"backend/database"
+	"context"
+	"time"
+)
+
+// PostgresLimiter keeps the buckets in the rate_limit_buckets table so every replica shares them
+type PostgresLimiter struct {
+	db *database.Postgres
+}
+
+// NewPostgresLimiter creates a limiter on top of the shared database
+func NewPostgresLimiter(db *database.Postgres) *PostgresLimiter {
+	return &PostgresLimiter{db: db}
+}
+
+// Allow takes a token from the bucket for key, the refill and the take happen in one statement
+func (l *PostgresLimiter) Allow(ctx context.Context, key string, rate Rate) (bool, time.Duration, error) {
+	allowed, tokens, err := l.db.TakeRateLimitToken(ctx, key, float64(rate.Burst), rate.perSecond())
+	if err != nil {
+		return false, 0, err
+	}
+	if !allowed {
+		return false, retryAfter(tokens, rate), nil
+	}
+	return true, 0, nil
+}
+
+// Reset deletes the bucket for key
+func (l *PostgresLimiter) Reset(ctx context.Context, key string) error {
+	return l.db.DeleteRateLimitBucket(ctx, key)
+}
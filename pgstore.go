@@ -0,0 +1,178 @@
+// backend/config/pgstore.go
+package config
+
+// PostgresStore is a gorilla/sessions store that keeps session data in the sessions table.
+// the cookie only carries a signed random session id, so deleting the row (logout, password change,
+// an admin revoking it) kills the session even if someone stole the cookie.
+
+import (
+	"backend/database"
+	"backend/utils"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// only push expires_at forward once a minute so every request isnt a db write
+const sessionTouchInterval = time.Minute
+
+// sessionTouchedKey marks a session New slid forward so RefreshCookie re-sends its cookie, it's never saved
+const sessionTouchedKey = "_pgstore_touched"
+
+// PostgresStore implements sessions.Store on top of database.Postgres
+type PostgresStore struct {
+	db      *database.Postgres
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+}
+
+// NewPostgresStore creates a store, keyPairs are the same hash/encryption keys NewCookieStore takes
+func NewPostgresStore(db *database.Postgres, keyPairs ...[]byte) *PostgresStore {
+	return &PostgresStore{
+		db:     db,
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:     "/",
+			MaxAge:   86400 * 7,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		},
+	}
+}
+
+// Get returns the session for this request, cached per request by the gorilla registry
+func (s *PostgresStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session from the database or returns a fresh empty one
+func (s *PostgresStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	options := *s.Options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		// no cookie means they just dont have a session yet
+		return session, nil
+	}
+
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.Codecs...); err != nil {
+		return session, err
+	}
+
+	// a missing row means the session expired or was revoked, treat it like a new session
+	key := SessionRecordID(id)
+	_, data, expiresAt, err := s.db.GetSession(r.Context(), key)
+	if err != nil {
+		return session, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return session, err
+	}
+	if err := (securecookie.GobEncoder{}).Deserialize(raw, &session.Values); err != nil {
+		return session, err
+	}
+	session.ID = id
+	session.IsNew = false
+
+	// sliding expiry, every time the session is used it gets another MaxAge
+	newExpiry := time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second)
+	if newExpiry.Sub(expiresAt) > sessionTouchInterval {
+		if err := s.db.TouchSession(r.Context(), key, newExpiry); err != nil {
+			log.Printf("⚠️  Failed to touch session: %v", err)
+		} else {
+			session.Values[sessionTouchedKey] = true
+		}
+	}
+
+	return session, nil
+}
+
+// Save writes the session to the database and sets the cookie
+// a negative MaxAge means logout, so the row gets deleted and the cookie cleared
+func (s *PostgresStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.db.DeleteSession(r.Context(), SessionRecordID(session.ID)); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	// the sessions table needs an owner, so only logged in sessions can be saved
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		return errors.New("pgstore: session has no user_id")
+	}
+
+	if session.ID == "" {
+		session.ID = newSessionID()
+	}
+	delete(session.Values, sessionTouchedKey)
+
+	raw, err := (securecookie.GobEncoder{}).Serialize(session.Values)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second)
+	data := base64.StdEncoding.EncodeToString(raw)
+	if err := s.db.CreateSession(r.Context(), SessionRecordID(session.ID), userID, data, expiresAt, utils.GetIPAddress(r), r.UserAgent()); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// RefreshCookie re-sends the cookie of a session New just slid forward. the cookie's MaxAge is only set by Save,
+// so without this the browser drops it MaxAge after login however active the user is
+func (s *PostgresStore) RefreshCookie(w http.ResponseWriter, r *http.Request, name string) {
+	session, err := s.Get(r, name)
+	if err != nil || session.IsNew {
+		return
+	}
+	if touched, _ := session.Values[sessionTouchedKey].(bool); !touched {
+		return
+	}
+	delete(session.Values, sessionTouchedKey)
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		log.Printf("⚠️  Failed to refresh session cookie: %v", err)
+		return
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+}
+
+// SessionRecordID is the primary key we store for a session id
+// we keep a sha256 of the id instead of the id itself so a leaked sessions table cant be replayed as cookies
+func SessionRecordID(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:])
+}
+
+// same id format gorilla's FilesystemStore uses
+func newSessionID() string {
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+}
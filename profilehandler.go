@@ -0,0 +1,110 @@
+// backend/handlers/profile_handlers.go
+package handlers
+
+import (
+	"backend/database"
+	"backend/models"
+	"backend/utils"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ============================================
+// PROFILE
+// ============================================
+
+// GetProfileHandler returns the logged in user's profile
+// GET /api/users/me
+func (h *AuthHandler) GetProfileHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, user)
+}
+
+// UpdateProfileHandler saves the fields the user changed and returns the whole profile
+// PATCH /api/users/me
+func (h *AuthHandler) UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	// Step 1: Parse and validate
+	var req models.UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	update := database.ProfileUpdate{
+		FirstName:           req.FirstName,
+		LastName:            req.LastName,
+		PreferredLanguage:   req.PreferredLanguage,
+		Timezone:            req.Timezone,
+		NativeLanguage:      req.NativeLanguage,
+		TargetInterviewDate: req.TargetInterviewDate,
+		AvatarURL:           req.AvatarURL,
+	}
+
+	// the audit entry lists which fields changed, not their values
+	var changed []string
+	for field, value := range map[string]*string{
+		"firstName":           req.FirstName,
+		"lastName":            req.LastName,
+		"preferredLanguage":   req.PreferredLanguage,
+		"timezone":            req.Timezone,
+		"nativeLanguage":      req.NativeLanguage,
+		"targetInterviewDate": req.TargetInterviewDate,
+		"avatarUrl":           req.AvatarURL,
+	} {
+		if value != nil {
+			changed = append(changed, field)
+		}
+	}
+
+	// Step 2: Save, an empty body just returns the profile as it is
+	if len(changed) > 0 {
+		if err := h.db.UpdateUser(r.Context(), userID, update); err != nil {
+			log.Printf("❌ Failed to update profile: %v", err)
+			utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to update profile")
+			return
+		}
+
+		sort.Strings(changed)
+		h.db.CreateAuditLog(
+			r.Context(),
+			&userID,
+			"profile_updated",
+			utils.GetIPAddress(r),
+			r.UserAgent(),
+			true,
+			strings.Join(changed, ","),
+		)
+	}
+
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, user)
+}
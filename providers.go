@@ -0,0 +1,167 @@
+// backend/config/providers.go
+package config
+
+// which OAuth providers show up on the login page is decided by the env file, a provider is on when its client id is set:
+//   GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET
+//   GITHUB_CLIENT_ID, GITHUB_CLIENT_SECRET
+//   AZUREAD_CLIENT_ID, AZUREAD_CLIENT_SECRET, AZUREAD_TENANT (defaults to "common")
+//   APPLE_CLIENT_ID, APPLE_TEAM_ID, APPLE_KEY_ID, APPLE_PRIVATE_KEY (the .p8 key, PEM)
+//   OIDC_CLIENT_ID, OIDC_CLIENT_SECRET, OIDC_DISCOVERY_URL, OIDC_DISPLAY_NAME
+// the callback url registered with each provider is BACKEND_URL/api/auth/<name>/callback
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/apple"
+	"github.com/markbates/goth/providers/azureadv2"
+	"github.com/markbates/goth/providers/github"
+	"github.com/markbates/goth/providers/google"
+	"github.com/markbates/goth/providers/openidConnect"
+)
+
+// OAuthProvider is what the login page needs to draw a "Continue with ..." button
+type OAuthProvider struct {
+	Name        string `json:"name"`        // goes in /api/auth/{provider}
+	DisplayName string `json:"displayName"` // what the button says
+}
+
+// oauthProviders is filled by InitAuth in the order the buttons should appear
+var oauthProviders []OAuthProvider
+
+// EnabledProviders lists the OAuth providers turned on in the env file
+func EnabledProviders() []OAuthProvider {
+	return oauthProviders
+}
+
+// apple doesnt use a fixed client secret, it is a JWT we sign with our key and it can live at most 6 months.
+// a long running server swaps in a freshly signed one a month before the old one runs out
+const (
+	appleSecretTTL         = 180 * 24 * time.Hour
+	appleSecretRenewBefore = 30 * 24 * time.Hour
+)
+
+var (
+	// providersMu keeps goth's provider map still while a request uses it, goth stores providers in a plain map
+	// so replacing the apple provider has to wait for requests reading it (and the other way around)
+	providersMu sync.RWMutex
+	// appleSecretExpires is when the current apple client secret runs out, zero when apple isn't configured
+	appleSecretExpires time.Time
+)
+
+// newAppleProvider signs a new client secret and builds the apple provider with it
+func newAppleProvider(clientID, callbackURL string) (*apple.Provider, error) {
+	now := time.Now()
+	secret, err := apple.MakeSecret(apple.SecretParams{
+		PKCS8PrivateKey: os.Getenv("APPLE_PRIVATE_KEY"),
+		TeamId:          os.Getenv("APPLE_TEAM_ID"),
+		KeyId:           os.Getenv("APPLE_KEY_ID"),
+		ClientId:        clientID,
+		Iat:             int(now.Unix()),
+		Exp:             int(now.Add(appleSecretTTL).Unix()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create apple client secret: %w", err)
+	}
+
+	providersMu.Lock()
+	appleSecretExpires = now.Add(appleSecretTTL)
+	providersMu.Unlock()
+
+	return apple.New(clientID, *secret, callbackURL, http.DefaultClient, apple.ScopeName, apple.ScopeEmail), nil
+}
+
+// HoldProviders is called before a request uses goth or gothic, it renews the apple secret when it's close to running out
+// and keeps the providers from changing until the returned func is called
+func HoldProviders() func() {
+	providersMu.RLock()
+	renew := !appleSecretExpires.IsZero() && time.Until(appleSecretExpires) < appleSecretRenewBefore
+	providersMu.RUnlock()
+
+	if renew {
+		renewAppleSecret()
+	}
+
+	providersMu.RLock()
+	return providersMu.RUnlock
+}
+
+// renewAppleSecret registers a new apple provider with a fresh secret, a failure keeps the old one and is retried on the next request
+func renewAppleSecret() {
+	p, err := newAppleProvider(os.Getenv("APPLE_CLIENT_ID"), GetBackendURL()+"/api/auth/apple/callback")
+	if err != nil {
+		log.Printf("❌ Failed to renew apple client secret: %v", err)
+		return
+	}
+
+	providersMu.Lock()
+	goth.UseProviders(p)
+	providersMu.Unlock()
+
+	log.Println("🔑 Renewed apple client secret")
+}
+
+// buildProviders creates a goth provider for everything that is configured
+// usesFormPost is true when one of them sends its callback as a POST (apple does)
+func buildProviders(backendURL string) (providers []goth.Provider, enabled []OAuthProvider, usesFormPost bool, err error) {
+	callback := func(name string) string {
+		return backendURL + "/api/auth/" + name + "/callback"
+	}
+
+	if id := os.Getenv("GOOGLE_CLIENT_ID"); id != "" {
+		p := google.New(id, os.Getenv("GOOGLE_CLIENT_SECRET"), callback("google"), "email", "profile")
+		providers = append(providers, p)
+		enabled = append(enabled, OAuthProvider{Name: p.Name(), DisplayName: "Google"})
+	}
+
+	if id := os.Getenv("GITHUB_CLIENT_ID"); id != "" {
+		// user:email lets goth read the primary email even when it is private on the profile
+		p := github.New(id, os.Getenv("GITHUB_CLIENT_SECRET"), callback("github"), "read:user", "user:email")
+		providers = append(providers, p)
+		enabled = append(enabled, OAuthProvider{Name: p.Name(), DisplayName: "GitHub"})
+	}
+
+	if id := os.Getenv("AZUREAD_CLIENT_ID"); id != "" {
+		tenant := os.Getenv("AZUREAD_TENANT")
+		if tenant == "" {
+			tenant = string(azureadv2.CommonTenant)
+		}
+		p := azureadv2.New(id, os.Getenv("AZUREAD_CLIENT_SECRET"), callback("azureadv2"), azureadv2.ProviderOptions{
+			Tenant: azureadv2.TenantType(tenant),
+			Scopes: []azureadv2.ScopeType{azureadv2.OpenIDScope, azureadv2.ProfileScope, azureadv2.EmailScope, azureadv2.UserReadScope},
+		})
+		providers = append(providers, p)
+		enabled = append(enabled, OAuthProvider{Name: p.Name(), DisplayName: "Microsoft"})
+	}
+
+	if id := os.Getenv("APPLE_CLIENT_ID"); id != "" {
+		p, err := newAppleProvider(id, callback("apple"))
+		if err != nil {
+			return nil, nil, false, err
+		}
+		providers = append(providers, p)
+		enabled = append(enabled, OAuthProvider{Name: p.Name(), DisplayName: "Apple"})
+		usesFormPost = true
+	}
+
+	if id := os.Getenv("OIDC_CLIENT_ID"); id != "" {
+		// any standard OpenID Connect server (a school's Keycloak, Okta, Auth0...) found through its discovery document
+		p, err := openidConnect.New(id, os.Getenv("OIDC_CLIENT_SECRET"), callback("openid-connect"), os.Getenv("OIDC_DISCOVERY_URL"), "openid", "email", "profile")
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("unable to set up openid connect: %w", err)
+		}
+		displayName := os.Getenv("OIDC_DISPLAY_NAME")
+		if displayName == "" {
+			displayName = "Single sign-on"
+		}
+		providers = append(providers, p)
+		enabled = append(enabled, OAuthProvider{Name: p.Name(), DisplayName: displayName})
+	}
+
+	return providers, enabled, usesFormPost, nil
+}
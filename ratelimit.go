@@ -0,0 +1,72 @@
+// backend/ratelimit/ratelimit.go
+package ratelimit
+
+// token bucket rate limiting for the auth endpoints.
+// every key (an ip address, an email...) gets a bucket that holds up to Burst tokens and gets one token back every Every.
+// a request takes a token, when the bucket is empty the request is refused and the caller gets told how long to wait.
+// there are two stores:
+//   memory   -> fine for local dev or a single server, buckets are lost on restart
+//   postgres -> buckets are shared by every replica behind the load balancer
+
+import (
+	"backend/database"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// Rate describes one bucket, Burst requests right away and then one more every Every
+type Rate struct {
+	Burst int
+	Every time.Duration
+}
+
+// perSecond is how many tokens the bucket gets back each second
+func (r Rate) perSecond() float64 {
+	return 1 / r.Every.Seconds()
+}
+
+// Limiter is implemented by every bucket store
+type Limiter interface {
+	// Allow takes one token from the bucket for key
+	// when the bucket is empty it returns false and how long until the next token is available
+	Allow(ctx context.Context, key string, rate Rate) (bool, time.Duration, error)
+	// Reset forgets the bucket for key so it starts full again
+	Reset(ctx context.Context, key string) error
+}
+
+// NewFromEnv picks the store from RATE_LIMIT_STORE (memory or postgres)
+func NewFromEnv(db *database.Postgres) (Limiter, error) {
+	switch store := getEnv("RATE_LIMIT_STORE", "memory"); store {
+	case "memory":
+		return NewMemoryLimiter(), nil
+	case "postgres":
+		return NewPostgresLimiter(db), nil
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_STORE %q", store)
+	}
+}
+
+// refill works out how many tokens a bucket has now, given what it had at last
+func refill(tokens float64, last, now time.Time, rate Rate) float64 {
+	tokens += now.Sub(last).Seconds() * rate.perSecond()
+	return math.Min(tokens, float64(rate.Burst))
+}
+
+// retryAfter is how long until a bucket holding tokens has a whole token again
+func retryAfter(tokens float64, rate Rate) time.Duration {
+	missing := 1 - tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / rate.perSecond() * float64(time.Second))
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
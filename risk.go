@@ -0,0 +1,243 @@
+// backend/risk/risk.go
+package risk
+
+// login risk checks. after the password (or the oauth provider) says yes we compare the login with the user's history:
+//   new device        -> a browser we have never seen this user log in from
+//   impossible travel -> the ip is further from the last login than anyone could have travelled since
+//   failure burst     -> a pile of wrong passwords just before this one worked
+// a new device alone only gets the user an email, the other two make the login suspicious.
+// locations come from an offline maxmind GeoLite2/GeoIP2 City file (GEOIP_DB_PATH), without it impossible travel is skipped
+
+import (
+	"backend/database"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+const (
+	// faster than a plane once you count getting to the airport
+	maxTravelSpeedKmh = 1000
+	// geoip is only accurate to a city or so, ignore short hops
+	minTravelDistanceKm = 500
+	// this many wrong passwords in the window before the right one is a burst
+	failureBurstCount  = 5
+	failureBurstWindow = 15 * time.Minute
+)
+
+// Assessment is what Evaluate found out about one login
+type Assessment struct {
+	NewDevice        bool
+	ImpossibleTravel bool
+	FailureBurst     bool
+	Device           string   // a short description of the browser for the email
+	Location         string   // "Madrid, ES", empty when the ip couldn't be located
+	Reasons          []string // for the audit log
+}
+
+// Suspicious is true when the login looks like someone else, not just a new laptop
+func (a *Assessment) Suspicious() bool {
+	return a.ImpossibleTravel || a.FailureBurst
+}
+
+// Alert is true when the user should get an email about this login
+func (a *Assessment) Alert() bool {
+	return a.NewDevice || a.Suspicious()
+}
+
+// Evaluator runs the checks, it is safe for concurrent use
+type Evaluator struct {
+	db  *database.Postgres
+	geo *geoip2.Reader
+	// StepUp makes suspicious logins prove themselves again (RISK_STEP_UP=true)
+	StepUp bool
+}
+
+// NewFromEnv opens the geoip database from GEOIP_DB_PATH if there is one
+func NewFromEnv(db *database.Postgres) (*Evaluator, error) {
+	evaluator := &Evaluator{
+		db:     db,
+		StepUp: os.Getenv("RISK_STEP_UP") == "true",
+	}
+
+	if path := os.Getenv("GEOIP_DB_PATH"); path != "" {
+		geo, err := geoip2.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open geoip database: %w", err)
+		}
+		evaluator.geo = geo
+	} else {
+		log.Println("⚠️  GEOIP_DB_PATH is not set, impossible travel checks are off")
+	}
+
+	return evaluator, nil
+}
+
+// Close releases the geoip database
+func (e *Evaluator) Close() error {
+	if e.geo != nil {
+		return e.geo.Close()
+	}
+	return nil
+}
+
+// Evaluate checks a login that already passed its password or oauth step, it doesn't record anything
+func (e *Evaluator) Evaluate(ctx context.Context, userID int, ip, userAgent string) (*Assessment, error) {
+	devices, err := e.db.ListUserDevices(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	assessment := &Assessment{Device: describeDevice(userAgent)}
+	here := e.locate(ip)
+	if here != nil {
+		assessment.Location = here.name
+	}
+
+	// the very first login has no history to compare with
+	if len(devices) > 0 {
+		hash := DeviceHash(userAgent)
+		assessment.NewDevice = true
+		for _, device := range devices {
+			if device.DeviceHash == hash {
+				assessment.NewDevice = false
+				break
+			}
+		}
+		if assessment.NewDevice {
+			assessment.Reasons = append(assessment.Reasons, "new device")
+		}
+
+		// devices are sorted by last use, the first one is the previous login
+		last := devices[0]
+		if here != nil && last.Latitude != nil && last.Longitude != nil {
+			km := distanceKm(*last.Latitude, *last.Longitude, here.latitude, here.longitude)
+			hours := time.Now().UTC().Sub(last.LastSeenAt).Hours()
+			if km >= minTravelDistanceKm && (hours <= 0 || km/hours > maxTravelSpeedKmh) {
+				assessment.ImpossibleTravel = true
+				assessment.Reasons = append(assessment.Reasons, fmt.Sprintf("impossible travel: %.0f km from %s in %.1f h", km, last.Location, hours))
+			}
+		}
+	}
+
+	failures, err := e.db.CountRecentAuditLogs(ctx, userID, "login_failed", time.Now().UTC().Add(-failureBurstWindow))
+	if err != nil {
+		return nil, err
+	}
+	if failures >= failureBurstCount {
+		assessment.FailureBurst = true
+		assessment.Reasons = append(assessment.Reasons, fmt.Sprintf("%d failed logins in the last %s", failures, failureBurstWindow))
+	}
+
+	return assessment, nil
+}
+
+// RecordLogin remembers the device and where it was, call it once the login succeeded
+func (e *Evaluator) RecordLogin(ctx context.Context, userID int, ip, userAgent string) error {
+	device := &database.UserDevice{
+		UserID:     userID,
+		DeviceHash: DeviceHash(userAgent),
+		UserAgent:  userAgent,
+		LastIP:     ip,
+		LastSeenAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	if here := e.locate(ip); here != nil {
+		device.Latitude = &here.latitude
+		device.Longitude = &here.longitude
+		device.Location = here.name
+	}
+	return e.db.UpsertUserDevice(ctx, device)
+}
+
+// version numbers change with every browser update, the same browser should keep the same hash
+var versionNumbers = regexp.MustCompile(`[0-9]+([._][0-9]+)*`)
+
+// DeviceHash identifies a browser by its user agent without the version numbers
+func DeviceHash(userAgent string) string {
+	normalized := versionNumbers.ReplaceAllString(strings.ToLower(userAgent), "")
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+type location struct {
+	name                string
+	latitude, longitude float64
+}
+
+// locate looks the ip up in the geoip database, nil when we can't tell (no database, private ip, not found)
+func (e *Evaluator) locate(ip string) *location {
+	if e.geo == nil {
+		return nil
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.IsPrivate() || parsed.IsLoopback() {
+		return nil
+	}
+
+	record, err := e.geo.City(parsed)
+	if err != nil || (record.Location.Latitude == 0 && record.Location.Longitude == 0) {
+		return nil
+	}
+
+	name := record.Country.IsoCode
+	if city := record.City.Names["en"]; city != "" {
+		name = city + ", " + name
+	}
+	return &location{name: name, latitude: record.Location.Latitude, longitude: record.Location.Longitude}
+}
+
+// distanceKm is the great circle distance between two points (haversine)
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return earthRadiusKm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// describeDevice turns a user agent into something like "Chrome on Windows" for the alert email
+func describeDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(ua, "edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "opr/") || strings.Contains(ua, "opera"):
+		browser = "Opera"
+	case strings.Contains(ua, "firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "chrome/") || strings.Contains(ua, "crios/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "safari/"):
+		browser = "Safari"
+	}
+
+	system := "an unknown system"
+	switch {
+	case strings.Contains(ua, "android"):
+		system = "Android"
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad"):
+		system = "iOS"
+	case strings.Contains(ua, "windows"):
+		system = "Windows"
+	case strings.Contains(ua, "mac os"):
+		system = "macOS"
+	case strings.Contains(ua, "linux"):
+		system = "Linux"
+	}
+
+	return browser + " on " + system
+}
@@ -0,0 +1,82 @@
+// backend/handlers/risk_handlers.go
+package handlers
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/mailer"
+	"backend/utils"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ============================================
+// LOGIN RISK CHECKS
+// ============================================
+
+// checkLoginRisk runs the risk checks on a login that got past its password or oauth step.
+// it emails the user about new devices and suspicious logins and audits the suspicious ones.
+// it returns true when the login has to step up: RISK_STEP_UP is on, the login is suspicious and the user has no
+// second factor to ask for, so we email them a magic link instead and the caller refuses this login.
+// a broken risk check never blocks a login, it is only logged
+func (h *AuthHandler) checkLoginRisk(r *http.Request, user *database.User, hasMFA bool) bool {
+	ip := utils.GetIPAddress(r)
+	assessment, err := h.risk.Evaluate(r.Context(), user.ID, ip, r.UserAgent())
+	if err != nil {
+		log.Printf("⚠️  Login risk check failed for user ID %d: %v", user.ID, err)
+		return false
+	}
+	if !assessment.Alert() {
+		return false
+	}
+
+	action := "new_device_login"
+	if assessment.Suspicious() {
+		action = "suspicious_login"
+		log.Printf("🚨 Suspicious login for user ID %d: %s", user.ID, strings.Join(assessment.Reasons, "; "))
+	}
+	h.db.CreateAuditLog(
+		r.Context(),
+		&user.ID,
+		action,
+		ip,
+		r.UserAgent(),
+		true,
+		strings.Join(assessment.Reasons, "; "),
+	)
+
+	h.sendEmailData(r, user, mailer.TemplateNewSignIn, mailer.TemplateData{
+		Link:     config.GetFrontendURL() + "/Profile",
+		Device:   assessment.Device,
+		Location: assessment.Location,
+		Time:     time.Now().UTC().Format("2006-01-02 15:04 UTC"),
+	})
+
+	// two factor users get asked for their code anyway, that is the step up
+	if !h.risk.StepUp || !assessment.Suspicious() || hasMFA {
+		return false
+	}
+
+	if err := h.sendMagicLink(r, user); err != nil {
+		log.Printf("❌ Failed to send step up link: %v", err)
+	}
+	h.db.CreateAuditLog(
+		r.Context(),
+		&user.ID,
+		"login_step_up",
+		ip,
+		r.UserAgent(),
+		false,
+		"sign in link emailed",
+	)
+	return true
+}
+
+// recordLogin remembers the device a login came from, startSession calls it for every kind of login
+func (h *AuthHandler) recordLogin(r *http.Request, userID int) {
+	if err := h.risk.RecordLogin(r.Context(), userID, utils.GetIPAddress(r), r.UserAgent()); err != nil {
+		log.Printf("⚠️  Failed to record login device: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+// backend/models/roles.go
+package models
+
+import (
+	"errors"
+	"strings"
+)
+
+// every user has exactly one role and every role is a fixed set of permissions.
+// routes check permissions (middleware.RequirePermission), never role names, so a new role only has to be added here.
+
+// the roles a user can have, stored in users.role
+const (
+	RoleStudent       = "student"
+	RoleTeacher       = "teacher"
+	RoleSchoolAdmin   = "school_admin"
+	RolePlatformAdmin = "platform_admin"
+)
+
+// Permission is one thing a role is allowed to do
+type Permission string
+
+const (
+	PermCoursesView   Permission = "courses:view"
+	PermCoursesManage Permission = "courses:manage"
+	PermStudentsView  Permission = "students:view"
+	PermUsersView     Permission = "users:view"
+	PermUsersManage   Permission = "users:manage"
+	PermRolesAssign   Permission = "roles:assign"
+	PermAuditView     Permission = "audit:view"
+	PermOrgsManage    Permission = "organizations:manage"
+)
+
+// rolePermissions is what each role can do, every role includes everything the role below it can do
+var rolePermissions = map[string][]Permission{
+	RoleStudent: {
+		PermCoursesView,
+	},
+	RoleTeacher: {
+		PermCoursesView, PermCoursesManage, PermStudentsView,
+	},
+	// the admin user, role and audit endpoints aren't scoped to an organization yet, they see every user on the platform.
+	// school admins get users:view, users:manage, roles:assign and audit:view back once those queries only return their own school
+	RoleSchoolAdmin: {
+		PermCoursesView, PermCoursesManage, PermStudentsView,
+	},
+	RolePlatformAdmin: {
+		PermCoursesView, PermCoursesManage, PermStudentsView,
+		PermUsersView, PermUsersManage, PermRolesAssign, PermAuditView,
+		PermOrgsManage,
+	},
+}
+
+// roleRank orders the roles so an admin can't hand out (or take away) a role above their own
+var roleRank = map[string]int{
+	RoleStudent:       1,
+	RoleTeacher:       2,
+	RoleSchoolAdmin:   3,
+	RolePlatformAdmin: 4,
+}
+
+// ValidRole checks the role is one we know about
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// AssignableRole checks the role is one an admin can hand out right now.
+// school_admin stays a valid role so existing rows keep working, but until the admin endpoints are scoped to an
+// organization it's no more than a teacher, giving it to someone would only look like it did something
+func AssignableRole(role string) bool {
+	return ValidRole(role) && role != RoleSchoolAdmin
+}
+
+// HasPermission reports whether the role grants perm, unknown roles get nothing
+func HasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidPermission checks perm is one some role can have, api token scopes are permissions
+func ValidPermission(perm Permission) bool {
+	for _, p := range rolePermissions[RolePlatformAdmin] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionsFor lists what a role can do, the frontend uses it to hide buttons
+func PermissionsFor(role string) []Permission {
+	return rolePermissions[role]
+}
+
+// CanAssignRole says if someone with actorRole may move a user from currentRole to newRole.
+// the user has to be ranked below the actor (so admins can't demote each other) and the new role can't be above the actor's own
+func CanAssignRole(actorRole, currentRole, newRole string) bool {
+	if !HasPermission(actorRole, PermRolesAssign) || !AssignableRole(newRole) {
+		return false
+	}
+	actor := roleRank[actorRole]
+	return roleRank[currentRole] < actor && roleRank[newRole] <= actor
+}
+
+// CanManageUser says if someone with actorRole may suspend, unlock or delete a user with targetRole,
+// the same rule as roles: only users ranked below the actor, so an admin can't lock out or delete another admin of the same rank
+func CanManageUser(actorRole, targetRole string) bool {
+	if !HasPermission(actorRole, PermUsersManage) {
+		return false
+	}
+	return roleRank[targetRole] < roleRank[actorRole]
+}
+
+// SSOAssignable reports whether an identity provider (SAML or SCIM) may hand out role.
+// an idp can make teachers at most, admins are only ever made by our own admins
+func SSOAssignable(role string) bool {
+	return ValidRole(role) && roleRank[role] <= roleRank[RoleTeacher]
+}
+
+// SSORole turns the role an identity provider sent into one of ours, anything unknown or above teacher gets fallback.
+// a fallback an idp couldn't assign either (an old connection's default) becomes student
+func SSORole(value, fallback string) string {
+	if !SSOAssignable(fallback) {
+		fallback = RoleStudent
+	}
+	value = strings.ToLower(strings.TrimSpace(value))
+	if !SSOAssignable(value) {
+		return fallback
+	}
+	return value
+}
+
+// used by the admin endpoint that changes a user's role
+type ChangeRoleRequest struct {
+	Role string `json:"role"`
+}
+
+func (request *ChangeRoleRequest) Validate() error {
+	if request.Role == RoleSchoolAdmin {
+		return errors.New("school_admin can't be assigned yet, school admins don't have their own admin permissions")
+	}
+	if !AssignableRole(request.Role) {
+		return errors.New("invalid role: must be student, teacher or platform_admin")
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+// backend/sso/saml.go
+package sso
+
+// we are the SAML service provider, every organization's identity provider is one saml_connections row.
+// one SP key and certificate (SAML_SP_KEY_PATH, SAML_SP_CERT_PATH) is shared by every organization,
+// the entity id and acs url are per organization:
+//
+//	entity id / metadata  {BACKEND_URL}/api/auth/saml/{org}/metadata
+//	acs                   {BACKEND_URL}/api/auth/saml/{org}/acs
+//
+// crewjam/saml checks the response: the idp signature against the certificates in the uploaded metadata,
+// audience, destination, the time window and InResponseTo against the request we sent.
+
+import (
+	"backend/database"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"log"
+	"math/big"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+)
+
+// SAMLProvider builds the service provider for each organization
+type SAMLProvider struct {
+	key         *rsa.PrivateKey
+	certificate *x509.Certificate
+	baseURL     string
+}
+
+// Attributes is what we take from an assertion
+type Attributes struct {
+	NameID    string
+	Email     string
+	FirstName string
+	LastName  string
+	Role      string
+}
+
+// NewSAMLFromEnv loads the SP key pair. without one a throwaway pair is generated, fine for local dev
+// but idps that encrypt assertions or check request signatures break on every restart
+func NewSAMLFromEnv(baseURL string) (*SAMLProvider, error) {
+	provider := &SAMLProvider{baseURL: strings.TrimSuffix(baseURL, "/")}
+
+	keyPath, certPath := os.Getenv("SAML_SP_KEY_PATH"), os.Getenv("SAML_SP_CERT_PATH")
+	if keyPath == "" || certPath == "" {
+		log.Println("⚠️  SAML_SP_KEY_PATH or SAML_SP_CERT_PATH is not set, using a throwaway SAML key pair")
+		key, certificate, err := generateKeyPair()
+		if err != nil {
+			return nil, err
+		}
+		provider.key, provider.certificate = key, certificate
+		return provider, nil
+	}
+
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the saml key pair: %w", err)
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the saml key must be an rsa key")
+	}
+	certificate, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the saml certificate: %w", err)
+	}
+
+	provider.key, provider.certificate = key, certificate
+	return provider, nil
+}
+
+// ParseIDPMetadata checks uploaded metadata describes an identity provider and returns its entity id
+func ParseIDPMetadata(metadata []byte) (string, error) {
+	descriptor, err := samlsp.ParseMetadata(metadata)
+	if err != nil {
+		return "", fmt.Errorf("the metadata is not valid: %w", err)
+	}
+	if len(descriptor.IDPSSODescriptors) == 0 {
+		return "", fmt.Errorf("the metadata doesn't describe an identity provider")
+	}
+	if descriptor.EntityID == "" {
+		return "", fmt.Errorf("the metadata has no entity id")
+	}
+	return descriptor.EntityID, nil
+}
+
+// ServiceProvider builds the SP for one organization's connection
+func (p *SAMLProvider) ServiceProvider(orgSlug string, conn *database.SAMLConnection) (*saml.ServiceProvider, error) {
+	descriptor, err := samlsp.ParseMetadata([]byte(conn.IDPMetadata))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse idp metadata: %w", err)
+	}
+
+	base := p.baseURL + "/api/auth/saml/" + url.PathEscape(orgSlug)
+	metadataURL, err := url.Parse(base + "/metadata")
+	if err != nil {
+		return nil, err
+	}
+	acsURL, err := url.Parse(base + "/acs")
+	if err != nil {
+		return nil, err
+	}
+
+	return &saml.ServiceProvider{
+		EntityID:          metadataURL.String(),
+		Key:               p.key,
+		Certificate:       p.certificate,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       descriptor,
+		AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+		// every login starts with us, an unsolicited response could be replayed from anywhere
+		AllowIDPInitiated: false,
+	}, nil
+}
+
+// MapAttributes reads the user out of a validated assertion using the connection's attribute names.
+// attributes match on Name or FriendlyName, the email falls back to the NameID when that looks like an email
+func MapAttributes(assertion *saml.Assertion, conn *database.SAMLConnection) Attributes {
+	var attrs Attributes
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		attrs.NameID = strings.TrimSpace(assertion.Subject.NameID.Value)
+	}
+
+	values := make(map[string]string)
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			if len(attribute.Values) == 0 {
+				continue
+			}
+			value := strings.TrimSpace(attribute.Values[0].Value)
+			for _, name := range []string{attribute.Name, attribute.FriendlyName} {
+				if name != "" {
+					if _, seen := values[name]; !seen {
+						values[name] = value
+					}
+				}
+			}
+		}
+	}
+
+	attrs.Email = strings.ToLower(values[conn.EmailAttribute])
+	if attrs.Email == "" && strings.Contains(attrs.NameID, "@") {
+		attrs.Email = strings.ToLower(attrs.NameID)
+	}
+	attrs.FirstName = values[conn.FirstNameAttribute]
+	attrs.LastName = values[conn.LastNameAttribute]
+	attrs.Role = values[conn.RoleAttribute]
+	return attrs
+}
+
+// EmailAllowed reports whether email is in one of the connection's domains
+func EmailAllowed(email string, conn *database.SAMLConnection) bool {
+	return EmailInDomains(email, conn.EmailDomains)
+}
+
+// EmailInDomains reports whether email is in one of domains, subdomains don't count
+func EmailInDomains(email string, domains []string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return false
+	}
+	domain := email[at+1:]
+	for _, allowed := range domains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// generateKeyPair makes a self signed pair for development
+func generateKeyPair() (*rsa.PrivateKey, *x509.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to generate saml key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to generate certificate serial: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "VirgoAI SAML SP (development)"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create saml certificate: %w", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, certificate, nil
+}
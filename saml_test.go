@@ -0,0 +1,297 @@
+// backend/sso/saml_test.go
+package sso
+
+import (
+	"backend/database"
+	"backend/models"
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+)
+
+// the tests play the identity provider: a generated key signs responses the way a real idp would,
+// and the connection trusts that key through the idp metadata, same as an uploaded metadata file
+
+const (
+	testIDPURL    = "https://idp.school.edu"
+	testBackend   = "https://api.example.com"
+	testOrgSlug   = "school"
+	testRequestID = "id-4a5b6c7d8e9f"
+)
+
+type testIDP struct {
+	idp  *saml.IdentityProvider
+	sp   *saml.ServiceProvider
+	conn *database.SAMLConnection
+}
+
+// newTestIDP sets up an idp with its own key and our SP for a connection that trusts it
+func newTestIDP(t *testing.T) *testIDP {
+	t.Helper()
+
+	idp := newIdentityProvider(t)
+	metadata, err := xml.Marshal(idp.Metadata())
+	if err != nil {
+		t.Fatalf("unable to marshal idp metadata: %v", err)
+	}
+
+	conn := &database.SAMLConnection{
+		IDPEntityID:        testIDPURL + "/metadata",
+		IDPMetadata:        string(metadata),
+		EmailDomains:       []string{"school.edu"},
+		DefaultRole:        models.RoleStudent,
+		EmailAttribute:     "email",
+		FirstNameAttribute: "first_name",
+		LastNameAttribute:  "last_name",
+		RoleAttribute:      "role",
+		Enabled:            true,
+	}
+
+	key, certificate, err := generateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := &SAMLProvider{key: key, certificate: certificate, baseURL: testBackend}
+	sp, err := provider.ServiceProvider(testOrgSlug, conn)
+	if err != nil {
+		t.Fatalf("unable to build service provider: %v", err)
+	}
+
+	return &testIDP{idp: idp, sp: sp, conn: conn}
+}
+
+func newIdentityProvider(t *testing.T) *saml.IdentityProvider {
+	t.Helper()
+
+	key, certificate, err := generateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadataURL, _ := url.Parse(testIDPURL + "/metadata")
+	ssoURL, _ := url.Parse(testIDPURL + "/sso")
+	return &saml.IdentityProvider{
+		Key:         key,
+		Certificate: certificate,
+		MetadataURL: *metadataURL,
+		SSOURL:      *ssoURL,
+	}
+}
+
+// response builds a signed response from signer to requestID, audience is who the assertion is for
+func (ti *testIDP) response(t *testing.T, signer *saml.IdentityProvider, audience string, attributes map[string]string) string {
+	t.Helper()
+
+	now := saml.TimeNow()
+	spMetadata := ti.sp.Metadata()
+	req := &saml.IdpAuthnRequest{
+		IDP:                     signer,
+		HTTPRequest:             httptest.NewRequest(http.MethodGet, testIDPURL+"/sso", nil),
+		Request:                 saml.AuthnRequest{ID: testRequestID, IssueInstant: now},
+		ServiceProviderMetadata: spMetadata,
+		SPSSODescriptor:         &spMetadata.SPSSODescriptors[0],
+		ACSEndpoint:             &saml.IndexedEndpoint{Binding: saml.HTTPPostBinding, Location: ti.sp.AcsURL.String()},
+		Now:                     now,
+	}
+
+	var attrs []saml.Attribute
+	for name, value := range attributes {
+		attrs = append(attrs, saml.Attribute{
+			Name:   name,
+			Values: []saml.AttributeValue{{Type: "xs:string", Value: value}},
+		})
+	}
+
+	req.Assertion = &saml.Assertion{
+		ID:           "id-assertion-" + testRequestID,
+		IssueInstant: now,
+		Version:      "2.0",
+		Issuer:       saml.Issuer{Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity", Value: testIDPURL + "/metadata"},
+		Subject: &saml.Subject{
+			NameID: &saml.NameID{Format: string(saml.UnspecifiedNameIDFormat), Value: "s12345"},
+			SubjectConfirmations: []saml.SubjectConfirmation{{
+				Method: "urn:oasis:names:tc:SAML:2.0:cm:bearer",
+				SubjectConfirmationData: &saml.SubjectConfirmationData{
+					InResponseTo: testRequestID,
+					NotOnOrAfter: now.Add(saml.MaxIssueDelay),
+					Recipient:    ti.sp.AcsURL.String(),
+				},
+			}},
+		},
+		Conditions: &saml.Conditions{
+			NotBefore:            now.Add(-time.Minute),
+			NotOnOrAfter:         now.Add(saml.MaxIssueDelay),
+			AudienceRestrictions: []saml.AudienceRestriction{{Audience: saml.Audience{Value: audience}}},
+		},
+		AuthnStatements: []saml.AuthnStatement{{
+			AuthnInstant: now,
+			AuthnContext: saml.AuthnContext{
+				AuthnContextClassRef: &saml.AuthnContextClassRef{Value: "urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport"},
+			},
+		}},
+		AttributeStatements: []saml.AttributeStatement{{Attributes: attrs}},
+	}
+
+	if err := req.MakeResponse(); err != nil {
+		t.Fatalf("unable to sign response: %v", err)
+	}
+	doc := etree.NewDocument()
+	doc.SetRoot(req.ResponseEl)
+	body, err := doc.WriteToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(body)
+}
+
+// parse posts the response to our acs the way the browser would and validates it
+func (ti *testIDP) parse(response string, outstanding []string) (*saml.Assertion, error) {
+	form := url.Values{"SAMLResponse": {response}, "RelayState": {"relay"}}
+	r := httptest.NewRequest(http.MethodPost, ti.sp.AcsURL.String(), strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	return ti.sp.ParseResponse(r, outstanding)
+}
+
+var studentAttributes = map[string]string{
+	"email":      "Ana.Diaz@school.edu",
+	"first_name": "Ana",
+	"last_name":  "Diaz",
+	"role":       "student",
+}
+
+func TestParseResponseValid(t *testing.T) {
+	ti := newTestIDP(t)
+
+	assertion, err := ti.parse(ti.response(t, ti.idp, ti.sp.EntityID, studentAttributes), []string{testRequestID})
+	if err != nil {
+		t.Fatalf("valid response refused: %v", err)
+	}
+
+	attrs := MapAttributes(assertion, ti.conn)
+	want := Attributes{NameID: "s12345", Email: "ana.diaz@school.edu", FirstName: "Ana", LastName: "Diaz", Role: "student"}
+	if attrs != want {
+		t.Errorf("MapAttributes = %+v, want %+v", attrs, want)
+	}
+	if !EmailAllowed(attrs.Email, ti.conn) {
+		t.Errorf("EmailAllowed(%q) = false, want true", attrs.Email)
+	}
+}
+
+func TestParseResponseBadSignature(t *testing.T) {
+	ti := newTestIDP(t)
+
+	// same entity id, but a key that isn't in the metadata the school uploaded
+	impostor := newIdentityProvider(t)
+	if _, err := ti.parse(ti.response(t, impostor, ti.sp.EntityID, studentAttributes), []string{testRequestID}); err == nil {
+		t.Fatal("response signed with an unknown key was accepted")
+	}
+}
+
+func TestParseResponseWrongAudience(t *testing.T) {
+	ti := newTestIDP(t)
+
+	// an assertion the idp issued to another organization's SP can't be used here
+	other := testBackend + "/api/auth/saml/other-school/metadata"
+	if _, err := ti.parse(ti.response(t, ti.idp, other, studentAttributes), []string{testRequestID}); err == nil {
+		t.Fatal("response for another audience was accepted")
+	}
+}
+
+func TestParseResponseReplayed(t *testing.T) {
+	ti := newTestIDP(t)
+	response := ti.response(t, ti.idp, ti.sp.EntityID, studentAttributes)
+
+	if _, err := ti.parse(response, []string{testRequestID}); err != nil {
+		t.Fatalf("first use refused: %v", err)
+	}
+
+	// the acs handler burns the RelayState (ConsumeSAMLRequest) before parsing, so a replay comes in
+	// with no outstanding request and InResponseTo no longer matches anything
+	if _, err := ti.parse(response, nil); err == nil {
+		t.Fatal("replayed response was accepted")
+	}
+	if _, err := ti.parse(response, []string{"id-some-other-request"}); err == nil {
+		t.Fatal("response answering a different request was accepted")
+	}
+}
+
+func TestEmailAllowed(t *testing.T) {
+	conn := &database.SAMLConnection{EmailDomains: []string{"school.edu"}}
+
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{"ana@school.edu", true},
+		{"ana@other.edu", false},
+		{"ana@mail.school.edu", false},
+		{"ana@school.edu.evil.com", false},
+		{"ana@evilschool.edu", false},
+		{"school.edu", false},
+		{"@school.edu", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := EmailAllowed(tt.email, conn); got != tt.want {
+			t.Errorf("EmailAllowed(%q) = %v, want %v", tt.email, got, tt.want)
+		}
+	}
+}
+
+func TestParseResponseOutOfDomainEmail(t *testing.T) {
+	ti := newTestIDP(t)
+
+	// a validly signed assertion still can't speak for an address outside the organization's domains
+	attributes := map[string]string{"email": "principal@other-school.edu", "role": "teacher"}
+	assertion, err := ti.parse(ti.response(t, ti.idp, ti.sp.EntityID, attributes), []string{testRequestID})
+	if err != nil {
+		t.Fatalf("valid response refused: %v", err)
+	}
+
+	attrs := MapAttributes(assertion, ti.conn)
+	if EmailAllowed(attrs.Email, ti.conn) {
+		t.Errorf("EmailAllowed(%q) = true, want false", attrs.Email)
+	}
+}
+
+func TestAssertedRoleCapped(t *testing.T) {
+	ti := newTestIDP(t)
+
+	tests := []struct {
+		asserted string
+		want     string
+	}{
+		{"student", models.RoleStudent},
+		{"Teacher", models.RoleTeacher},
+		{"school_admin", models.RoleStudent},
+		{"platform_admin", models.RoleStudent},
+		{"superuser", models.RoleStudent},
+	}
+	for _, tt := range tests {
+		attributes := map[string]string{"email": "ana@school.edu", "role": tt.asserted}
+		assertion, err := ti.parse(ti.response(t, ti.idp, ti.sp.EntityID, attributes), []string{testRequestID})
+		if err != nil {
+			t.Fatalf("valid response refused: %v", err)
+		}
+
+		attrs := MapAttributes(assertion, ti.conn)
+		if got := models.SSORole(attrs.Role, ti.conn.DefaultRole); got != tt.want {
+			t.Errorf("asserted role %q became %q, want %q", tt.asserted, got, tt.want)
+		}
+	}
+
+	// a connection saved before the cap with an admin default doesn't hand that out either
+	if got := models.SSORole("", models.RoleSchoolAdmin); got != models.RoleStudent {
+		t.Errorf("SSORole with an admin default = %q, want %q", got, models.RoleStudent)
+	}
+}
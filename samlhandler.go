@@ -0,0 +1,366 @@
+// backend/handlers/saml_handlers.go
+package handlers
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/mailer"
+	"backend/models"
+	"backend/sso"
+	"backend/utils"
+	"encoding/xml"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/gorilla/mux"
+)
+
+// ============================================
+// SAML SSO FOR ORGANIZATIONS
+// ============================================
+
+// samlProvider is the provider (and identity provider name) of an organization's sso users
+func samlProvider(org *database.Organization) string {
+	return "saml:" + org.Slug
+}
+
+// samlConnection loads the {org} organization and its identity provider, a missing or turned off connection is a 404
+func (h *AuthHandler) samlConnection(r *http.Request) (*database.Organization, *database.SAMLConnection, *saml.ServiceProvider, bool) {
+	org, err := h.db.GetOrganizationBySlug(r.Context(), mux.Vars(r)["org"])
+	if err != nil {
+		return nil, nil, nil, false
+	}
+	conn, err := h.db.GetSAMLConnection(r.Context(), org.ID)
+	if err != nil || !conn.Enabled {
+		return nil, nil, nil, false
+	}
+	sp, err := h.saml.ServiceProvider(org.Slug, conn)
+	if err != nil {
+		log.Printf("❌ SAML connection for %s is broken: %v", org.Slug, err)
+		return nil, nil, nil, false
+	}
+	return org, conn, sp, true
+}
+
+// SAMLMetadataHandler is the SP metadata the organization's it team uploads to their identity provider
+// GET /api/auth/saml/{org}/metadata
+func (h *AuthHandler) SAMLMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	_, _, sp, ok := h.samlConnection(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "Single sign on is not set up for this organization")
+		return
+	}
+
+	metadata, err := xml.MarshalIndent(sp.Metadata(), "", "  ")
+	if err != nil {
+		log.Printf("❌ Failed to build SAML metadata: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to build metadata")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(metadata)
+}
+
+// SAMLLoginHandler sends the browser to the organization's identity provider
+// GET /api/auth/saml/{org}/login
+func (h *AuthHandler) SAMLLoginHandler(w http.ResponseWriter, r *http.Request) {
+	fail := func(code string) {
+		http.Redirect(w, r, config.GetFrontendURL()+"/Login?error="+code, http.StatusSeeOther)
+	}
+
+	org, _, sp, ok := h.samlConnection(r)
+	if !ok {
+		fail("sso_not_configured")
+		return
+	}
+	log.Printf("🔑 Starting SAML login for %s", org.Slug)
+
+	ssoURL := sp.GetSSOBindingLocation(saml.HTTPRedirectBinding)
+	if ssoURL == "" {
+		log.Printf("❌ SAML metadata for %s has no redirect binding", org.Slug)
+		fail("sso_not_configured")
+		return
+	}
+
+	authnRequest, err := sp.MakeAuthenticationRequest(ssoURL, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		log.Printf("❌ Failed to build AuthnRequest: %v", err)
+		fail("sso_failed")
+		return
+	}
+
+	// the relay state comes back with the response and tells us which request it answers
+	relayState := utils.GenerateSecureToken(24)
+	expiresAt := time.Now().UTC().Add(config.SAMLRequestTTL)
+	if err := h.db.CreateSAMLRequest(r.Context(), authnRequest.ID, org.ID, utils.HashToken(relayState), expiresAt); err != nil {
+		log.Printf("❌ Failed to save AuthnRequest: %v", err)
+		fail("sso_failed")
+		return
+	}
+
+	target, err := authnRequest.Redirect(relayState, sp)
+	if err != nil {
+		log.Printf("❌ Failed to build SAML redirect: %v", err)
+		fail("sso_failed")
+		return
+	}
+
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+}
+
+// SAMLACSHandler is where the identity provider posts its response, it logs the user in (creating them the first time)
+// POST /api/auth/saml/{org}/acs
+func (h *AuthHandler) SAMLACSHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("🔑 SAML response received")
+
+	fail := func(code string) {
+		http.Redirect(w, r, config.GetFrontendURL()+"/Login?error="+code, http.StatusSeeOther)
+	}
+
+	if !h.throttleIP(w, r, "saml-acs", loginIPRate) {
+		return
+	}
+
+	org, conn, sp, ok := h.samlConnection(r)
+	if !ok {
+		fail("sso_not_configured")
+		return
+	}
+
+	// Step 1: Find the request this answers, which also makes sure each one is only answered once
+	if err := r.ParseForm(); err != nil {
+		fail("sso_failed")
+		return
+	}
+	requestID, err := h.db.ConsumeSAMLRequest(r.Context(), utils.HashToken(r.PostForm.Get("RelayState")), org.ID)
+	if err != nil {
+		log.Printf("⚠️  SAML response for %s without a matching request: %v", org.Slug, err)
+		fail("sso_expired")
+		return
+	}
+
+	// Step 2: Validate the signed assertion
+	assertion, err := sp.ParseResponse(r, []string{requestID})
+	if err != nil {
+		// the public error is deliberately vague, the real reason is in PrivateErr
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			err = invalid.PrivateErr
+		}
+		log.Printf("❌ Invalid SAML response for %s: %v", org.Slug, err)
+		h.db.CreateAuditLog(
+			r.Context(),
+			nil,
+			"login_failed",
+			utils.GetIPAddress(r),
+			r.UserAgent(),
+			false,
+			samlProvider(org)+" invalid response",
+		)
+		fail("sso_failed")
+		return
+	}
+
+	attrs := sso.MapAttributes(assertion, conn)
+	if attrs.NameID == "" {
+		log.Printf("❌ SAML assertion for %s has no NameID", org.Slug)
+		fail("sso_failed")
+		return
+	}
+
+	// Step 3: Find, link or create the user
+	user, errorCode := h.samlAccount(r, org, conn, attrs)
+	if user == nil {
+		fail(errorCode)
+		return
+	}
+	if h.accountSuspended(r, user) {
+		fail("account_suspended")
+		return
+	}
+	hasMFA := h.mfaEnabled(r, user.ID)
+	if h.checkLoginRisk(r, user, hasMFA) {
+		fail("step_up_required")
+		return
+	}
+
+	// Step 4: Two factor users still need their code, same as OAuth
+	if hasMFA {
+		if err := setMFAPending(w, r, user.ID, samlProvider(org)); err != nil {
+			log.Printf("❌ Failed to save mfa-pending state: %v", err)
+			fail("session_failed")
+			return
+		}
+		http.Redirect(w, r, config.GetFrontendURL()+"/Login?mfa=required", http.StatusSeeOther)
+		return
+	}
+
+	// Step 5: Create session
+	if err := h.startSession(w, r, user, samlProvider(org)); err != nil {
+		log.Printf("❌ Failed to save SAML session: %v", err)
+		fail("session_failed")
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&user.ID,
+		"saml_login",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		samlProvider(org),
+	)
+
+	log.Printf("✅ SAML login successful: %s via %s", user.Email, org.Slug)
+
+	http.Redirect(w, r, config.GetFrontendURL()+"/auth/callback?Login=success", http.StatusSeeOther)
+}
+
+// samlAccount finds the user for an assertion. returns the user, or nil and an error code for the login page.
+// users the organization created just in time follow the idp, names and role are synced on every login
+func (h *AuthHandler) samlAccount(r *http.Request, org *database.Organization, conn *database.SAMLConnection, attrs sso.Attributes) (*database.User, string) {
+	provider := samlProvider(org)
+
+	// already linked
+	if user, err := h.db.GetUserByProviderID(r.Context(), provider, attrs.NameID); err == nil {
+		h.db.TouchUserIdentity(r.Context(), provider, attrs.NameID)
+		if user.Provider == provider {
+			h.syncSAMLUser(r, user, conn, attrs)
+		}
+		return user, ""
+	}
+
+	// the idp can only speak for the organization's own domains
+	if attrs.Email == "" || !sso.EmailAllowed(attrs.Email, conn) {
+		log.Printf("⚠️  SAML login for %s refused, email %q is outside the allowed domains", org.Slug, attrs.Email)
+		h.db.CreateAuditLog(
+			r.Context(),
+			nil,
+			"login_failed",
+			utils.GetIPAddress(r),
+			r.UserAgent(),
+			false,
+			provider+" email outside allowed domains",
+		)
+		return nil, "sso_email_not_allowed"
+	}
+
+	// an existing account with the same email, same rule as OAuth: only link when the account proved it owns the address.
+	// the role of an existing account is left alone
+	if existing, err := h.db.GetUserByEmail(r.Context(), attrs.Email); err == nil {
+		if !existing.EmailVerified {
+			h.db.CreateAuditLog(
+				r.Context(),
+				&existing.ID,
+				"identity_link_refused",
+				utils.GetIPAddress(r),
+				r.UserAgent(),
+				false,
+				provider+" account email not verified",
+			)
+			return nil, "account_exists"
+		}
+
+		if err := h.db.CreateUserIdentity(r.Context(), existing.ID, provider, attrs.NameID, attrs.Email); err != nil {
+			log.Printf("❌ Failed to link identity: %v", err)
+			return nil, "link_failed"
+		}
+		h.db.CreateAuditLog(
+			r.Context(),
+			&existing.ID,
+			"identity_linked",
+			utils.GetIPAddress(r),
+			r.UserAgent(),
+			true,
+			provider+" (same verified email)",
+		)
+		return existing, ""
+	}
+
+	// brand new user, provisioned just in time
+	log.Printf("👤 Creating new user from %s SAML: %s", org.Slug, attrs.Email)
+
+	user, err := h.db.CreateUser(
+		r.Context(),
+		attrs.Email,
+		"",
+		attrs.FirstName,
+		attrs.LastName,
+		models.SSORole(attrs.Role, conn.DefaultRole),
+		provider,     //  "saml:<org>"
+		attrs.NameID, //  the idp's NameID
+	)
+	if err != nil {
+		log.Printf("❌ Failed to create SAML user: %v", err)
+		return nil, "create_failed"
+	}
+
+	if err := h.db.CreateUserIdentity(r.Context(), user.ID, provider, attrs.NameID, attrs.Email); err != nil {
+		log.Printf("❌ Failed to create identity: %v", err)
+		return nil, "create_failed"
+	}
+
+	// the idp vouches for the address and it's in one of the organization's domains
+	h.db.VerifyEmail(r.Context(), user.ID)
+	user.EmailVerified = true
+	h.sendEmail(r, user, mailer.TemplateWelcome, config.GetFrontendURL()+"/Courses")
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&user.ID,
+		"saml_register",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		provider+" as "+user.Role,
+	)
+
+	return user, ""
+}
+
+// syncSAMLUser copies changed names and role from the idp onto a user the organization provisioned.
+// a failed sync doesn't stop the login, it only gets logged
+func (h *AuthHandler) syncSAMLUser(r *http.Request, user *database.User, conn *database.SAMLConnection, attrs sso.Attributes) {
+	var update database.ProfileUpdate
+	if attrs.FirstName != "" && attrs.FirstName != user.FirstName {
+		update.FirstName = &attrs.FirstName
+	}
+	if attrs.LastName != "" && attrs.LastName != user.LastName {
+		update.LastName = &attrs.LastName
+	}
+	if update.FirstName != nil || update.LastName != nil {
+		if err := h.db.UpdateUser(r.Context(), user.ID, update); err != nil {
+			log.Printf("⚠️  Failed to sync SAML names for user ID %d: %v", user.ID, err)
+		}
+	}
+
+	// no role attribute means the idp doesn't manage roles, keep whatever the user has.
+	// an admin one of our admins made stays one, the idp can't hand that role out so it doesn't take it away either
+	if attrs.Role == "" || !models.SSOAssignable(user.Role) {
+		return
+	}
+	role := models.SSORole(attrs.Role, conn.DefaultRole)
+	if role == user.Role {
+		return
+	}
+	if err := h.db.UpdateUserRole(r.Context(), user.ID, role); err != nil {
+		log.Printf("⚠️  Failed to sync SAML role for user ID %d: %v", user.ID, err)
+		return
+	}
+	h.db.CreateAuditLog(
+		r.Context(),
+		&user.ID,
+		"role_changed",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		user.Role+" -> "+role+" (from "+user.Provider+")",
+	)
+	user.Role = role
+}
@@ -0,0 +1,486 @@
+// backend/scim/scim.go
+package scim
+
+// SCIM 2.0 (RFC 7643 and 7644), as much of it as student information systems use to push rosters:
+// users and groups, filtering on one attribute with eq, PATCH and bulk creation of users.
+// resources are always scoped to the organization the bearer token belongs to, the handlers take care of that
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaBulkResponse          = "urn:ietf:params:scim:api:messages:2.0:BulkResponse"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+	ContentType = "application/scim+json"
+
+	// a page of users or groups, a SIS that wants more asks for the next page
+	DefaultCount = 100
+	MaxCount     = 200
+
+	// a whole school year's intake fits in one bulk request
+	MaxBulkOperations = 1000
+	MaxBulkPayload    = 1 << 20
+)
+
+// scimType values of error responses (RFC 7644 section 3.12)
+const (
+	ErrInvalidFilter = "invalidFilter"
+	ErrInvalidSyntax = "invalidSyntax"
+	ErrInvalidValue  = "invalidValue"
+	ErrInvalidPath   = "invalidPath"
+	ErrNoTarget      = "noTarget"
+	ErrUniqueness    = "uniqueness"
+	ErrMutability    = "mutability"
+	ErrTooMany       = "tooMany"
+)
+
+// same check as registration
+var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
+
+// Meta is the common resource metadata
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+// Name is the part of the user's name we keep
+type Name struct {
+	GivenName  string `json:"givenName"`
+	FamilyName string `json:"familyName"`
+	Formatted  string `json:"formatted,omitempty"`
+}
+
+// Value is one entry of a multi valued attribute like emails or roles
+type Value struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+// Member is a user in a group (or a group a user is in), Value is the resource id
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// Bool is a boolean that also accepts "True" and "False", some identity providers send active as a string
+type Bool bool
+
+func (b *Bool) UnmarshalJSON(data []byte) error {
+	var value bool
+	if err := json.Unmarshal(data, &value); err == nil {
+		*b = Bool(value)
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	value, err := strconv.ParseBool(text)
+	if err != nil {
+		return err
+	}
+	*b = Bool(value)
+	return nil
+}
+
+// User is the scim view of an account on an organization's roster
+type User struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	UserName    string   `json:"userName"`
+	Name        Name     `json:"name"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Emails      []Value  `json:"emails,omitempty"`
+	Roles       []Value  `json:"roles,omitempty"`
+	Active      *Bool    `json:"active,omitempty"`
+	Groups      []Member `json:"groups,omitempty"` // read only
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// Group is a class, section or cohort
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// ListResponse is one page of a query
+type ListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// PatchRequest is the body of a PATCH
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is one add, replace or remove
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// BulkRequest is the body of a POST to /Bulk
+type BulkRequest struct {
+	Schemas      []string        `json:"schemas"`
+	FailOnErrors int             `json:"failOnErrors"`
+	Operations   []BulkOperation `json:"Operations"`
+}
+
+// BulkOperation is one request inside a bulk request
+type BulkOperation struct {
+	Method string          `json:"method"`
+	BulkID string          `json:"bulkId"`
+	Path   string          `json:"path"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// BulkResponse answers a bulk request, one result per operation in the same order
+type BulkResponse struct {
+	Schemas    []string     `json:"schemas"`
+	Operations []BulkResult `json:"Operations"`
+}
+
+// BulkResult is the outcome of one bulk operation
+type BulkResult struct {
+	Method   string `json:"method"`
+	BulkID   string `json:"bulkId,omitempty"`
+	Location string `json:"location,omitempty"`
+	Status   string `json:"status"`
+	Response *Error `json:"response,omitempty"`
+}
+
+// Error is a scim error response, it is also an error so helpers can hand it back to the handler as is
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+// HTTPStatus is the error's status as a number
+func (e *Error) HTTPStatus() int {
+	status, err := strconv.Atoi(e.Status)
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	return status
+}
+
+// NewError builds an error response, scimType can be empty
+func NewError(status int, scimType, detail string) *Error {
+	return &Error{
+		Schemas:  []string{SchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+// Respond writes a scim json response
+func Respond(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// WriteError writes an error response
+func WriteError(w http.ResponseWriter, e *Error) {
+	Respond(w, e.HTTPStatus(), e)
+}
+
+// Filter is a parsed `attribute eq "value"` filter, the attribute is lowercased since scim attribute names aren't case sensitive
+type Filter struct {
+	Attribute string
+	Value     string
+}
+
+// ParseFilter parses the filter query parameter. only a single eq comparison on a string is supported,
+// it is what identity providers use to look a user or group up before creating it. an empty filter is the zero Filter
+func ParseFilter(raw string) (Filter, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return Filter{}, nil
+	}
+
+	attribute, rest, _ := strings.Cut(raw, " ")
+	operator, value, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	if !strings.EqualFold(operator, "eq") {
+		return Filter{}, NewError(http.StatusBadRequest, ErrInvalidFilter, "only filters like userName eq \"value\" are supported")
+	}
+
+	var text string
+	if err := json.Unmarshal([]byte(strings.TrimSpace(value)), &text); err != nil {
+		return Filter{}, NewError(http.StatusBadRequest, ErrInvalidFilter, "the filter value must be a quoted string")
+	}
+
+	return Filter{Attribute: strings.ToLower(attribute), Value: text}, nil
+}
+
+// Pagination reads startIndex (1 based) and count from the query string
+func Pagination(query url.Values) (startIndex, count int) {
+	startIndex, err := strconv.Atoi(query.Get("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+
+	count, err = strconv.Atoi(query.Get("count"))
+	if err != nil || count < 0 {
+		count = DefaultCount
+	}
+	if count > MaxCount {
+		count = MaxCount
+	}
+
+	return startIndex, count
+}
+
+// Excluded reports whether attribute is in the excludedAttributes query parameter
+func Excluded(query url.Values, attribute string) bool {
+	for _, excluded := range strings.Split(query.Get("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(excluded), attribute) {
+			return true
+		}
+	}
+	return false
+}
+
+// PrimaryEmail is the email the account uses: the primary one, else the first, else the userName when it is an email
+func (u *User) PrimaryEmail() string {
+	for _, email := range u.Emails {
+		if email.Primary {
+			return strings.ToLower(strings.TrimSpace(email.Value))
+		}
+	}
+	if len(u.Emails) > 0 {
+		return strings.ToLower(strings.TrimSpace(u.Emails[0].Value))
+	}
+	if strings.Contains(u.UserName, "@") {
+		return strings.ToLower(strings.TrimSpace(u.UserName))
+	}
+	return ""
+}
+
+// Role is the first role the SIS sent, empty when it doesn't manage roles
+func (u *User) Role() string {
+	for _, role := range u.Roles {
+		if role.Primary {
+			return role.Value
+		}
+	}
+	if len(u.Roles) > 0 {
+		return u.Roles[0].Value
+	}
+	return ""
+}
+
+// Validate checks a user sent in a POST, PUT or bulk operation
+func (u *User) Validate() error {
+	u.UserName = strings.TrimSpace(u.UserName)
+	if u.UserName == "" || len(u.UserName) > 255 {
+		return NewError(http.StatusBadRequest, ErrInvalidValue, "userName must be between 1 and 255 characters")
+	}
+	if len(u.ExternalID) > 255 {
+		return NewError(http.StatusBadRequest, ErrInvalidValue, "externalId must be at most 255 characters")
+	}
+
+	u.Name.GivenName = strings.TrimSpace(u.Name.GivenName)
+	u.Name.FamilyName = strings.TrimSpace(u.Name.FamilyName)
+	if len(u.Name.GivenName) > 255 || len(u.Name.FamilyName) > 255 {
+		return NewError(http.StatusBadRequest, ErrInvalidValue, "names must be at most 255 characters")
+	}
+
+	if !emailRegex.MatchString(u.PrimaryEmail()) {
+		return NewError(http.StatusBadRequest, ErrInvalidValue, "a valid email is required, in emails or as the userName")
+	}
+	return nil
+}
+
+// Apply makes one PATCH operation on the user. attributes we don't store (displayName, phone numbers,
+// enterprise extension fields...) are accepted and dropped, identity providers send plenty of them
+func (u *User) Apply(op PatchOperation) error {
+	switch strings.ToLower(op.Op) {
+	case "add", "replace":
+		if op.Path != "" {
+			return u.set(op.Path, op.Value)
+		}
+
+		// no path, the value is an object of attribute: value
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &values); err != nil {
+			return NewError(http.StatusBadRequest, ErrInvalidValue, "without a path the value must be an object")
+		}
+		for path, value := range values {
+			if err := u.set(path, value); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "remove":
+		switch strings.ToLower(op.Path) {
+		case "":
+			return NewError(http.StatusBadRequest, ErrNoTarget, "remove needs a path")
+		case "externalid":
+			u.ExternalID = ""
+		case "roles":
+			u.Roles = nil
+		}
+		return nil
+	}
+
+	return NewError(http.StatusBadRequest, ErrInvalidSyntax, "unknown patch op: "+op.Op)
+}
+
+// set replaces one attribute, path is case insensitive
+func (u *User) set(path string, value json.RawMessage) error {
+	var err error
+	switch strings.ToLower(path) {
+	case "active":
+		var active Bool
+		if err = json.Unmarshal(value, &active); err == nil {
+			u.Active = &active
+		}
+	case "username":
+		err = json.Unmarshal(value, &u.UserName)
+	case "externalid":
+		err = json.Unmarshal(value, &u.ExternalID)
+	case "name":
+		err = json.Unmarshal(value, &u.Name)
+	case "name.givenname":
+		err = json.Unmarshal(value, &u.Name.GivenName)
+	case "name.familyname":
+		err = json.Unmarshal(value, &u.Name.FamilyName)
+	case "emails":
+		err = json.Unmarshal(value, &u.Emails)
+	case "emails.value", `emails[type eq "work"].value`, "emails[primary eq true].value":
+		var email string
+		if err = json.Unmarshal(value, &email); err == nil {
+			u.Emails = []Value{{Value: email, Type: "work", Primary: true}}
+		}
+	case "roles":
+		err = json.Unmarshal(value, &u.Roles)
+	}
+
+	if err != nil {
+		return NewError(http.StatusBadRequest, ErrInvalidValue, "invalid value for "+path)
+	}
+	return nil
+}
+
+// GroupChange is what one PATCH operation does to a group. MemberOp is add, remove or replace when the
+// operation touches members, a remove without Members takes everyone out
+type GroupChange struct {
+	DisplayName *string
+	ExternalID  *string
+	MemberOp    string
+	Members     []Member
+}
+
+// ParseGroupOperation reads one PATCH operation on a group
+func ParseGroupOperation(op PatchOperation) (GroupChange, error) {
+	var change GroupChange
+	kind := strings.ToLower(op.Op)
+	path := strings.ToLower(strings.TrimSpace(op.Path))
+
+	// members[value eq "42"], how Azure AD and others remove a single member
+	if strings.HasPrefix(path, "members[") && strings.HasSuffix(path, "]") {
+		filter, err := ParseFilter(strings.TrimSuffix(op.Path[len("members["):], "]"))
+		if err != nil || filter.Attribute != "value" || kind != "remove" {
+			return change, NewError(http.StatusBadRequest, ErrInvalidPath, "unsupported path: "+op.Path)
+		}
+		change.MemberOp = "remove"
+		change.Members = []Member{{Value: filter.Value}}
+		return change, nil
+	}
+
+	switch kind {
+	case "add", "replace", "remove":
+	default:
+		return change, NewError(http.StatusBadRequest, ErrInvalidSyntax, "unknown patch op: "+op.Op)
+	}
+
+	switch path {
+	case "members":
+		change.MemberOp = kind
+		if len(op.Value) > 0 {
+			if err := json.Unmarshal(op.Value, &change.Members); err != nil {
+				return change, NewError(http.StatusBadRequest, ErrInvalidValue, "members must be a list of {\"value\": id}")
+			}
+		}
+		return change, nil
+
+	case "displayname", "externalid":
+		if kind == "remove" {
+			if path == "displayname" {
+				return change, NewError(http.StatusBadRequest, ErrMutability, "displayName is required")
+			}
+			empty := ""
+			change.ExternalID = &empty
+			return change, nil
+		}
+		var text string
+		if err := json.Unmarshal(op.Value, &text); err != nil {
+			return change, NewError(http.StatusBadRequest, ErrInvalidValue, "invalid value for "+op.Path)
+		}
+		if path == "displayname" {
+			change.DisplayName = &text
+		} else {
+			change.ExternalID = &text
+		}
+		return change, nil
+
+	case "":
+		if kind == "remove" {
+			return change, NewError(http.StatusBadRequest, ErrNoTarget, "remove needs a path")
+		}
+		var values struct {
+			DisplayName *string  `json:"displayName"`
+			ExternalID  *string  `json:"externalId"`
+			Members     []Member `json:"members"`
+		}
+		if err := json.Unmarshal(op.Value, &values); err != nil {
+			return change, NewError(http.StatusBadRequest, ErrInvalidValue, "without a path the value must be an object")
+		}
+		change.DisplayName, change.ExternalID = values.DisplayName, values.ExternalID
+		if values.Members != nil {
+			change.MemberOp, change.Members = kind, values.Members
+		}
+		return change, nil
+	}
+
+	return change, NewError(http.StatusBadRequest, ErrInvalidPath, "unsupported path: "+op.Path)
+}
@@ -0,0 +1,65 @@
+// backend/middleware/scim_auth.go
+package middleware
+
+// SCIMAuth guards /scim/v2. a student information system sends Authorization: Bearer vgo_scim_...,
+// every token belongs to one organization and the handlers only ever see that organization's roster
+
+import (
+	"backend/database"
+	"backend/scim"
+	"backend/utils"
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// SCIMTokenPrefix starts every provisioning token, different from APITokenPrefix so neither works in the other's place
+const SCIMTokenPrefix = "vgo_scim_"
+
+const scimOrganizationContextKey contextKey = "scim_organization"
+
+// SCIMAuth checks the provisioning token and stores its organization in the context
+func SCIMAuth(db *database.Postgres) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, raw, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			raw = strings.TrimSpace(raw)
+			if !strings.EqualFold(scheme, "Bearer") || !strings.HasPrefix(raw, SCIMTokenPrefix) {
+				scim.WriteError(w, scim.NewError(http.StatusUnauthorized, "", "A SCIM token is required"))
+				return
+			}
+
+			// only the hash is stored, so the lookup is by hash too
+			token, err := db.GetSCIMTokenByHash(r.Context(), utils.HashToken(raw))
+			if err != nil {
+				log.Printf("⚠️  Rejected SCIM token from %s: %v", utils.GetIPAddress(r), err)
+				scim.WriteError(w, scim.NewError(http.StatusUnauthorized, "", "Invalid SCIM token"))
+				return
+			}
+
+			org, err := db.GetOrganizationByID(r.Context(), token.OrganizationID)
+			if err != nil {
+				log.Printf("❌ Failed to load organization of SCIM token %d: %v", token.ID, err)
+				scim.WriteError(w, scim.NewError(http.StatusUnauthorized, "", "Invalid SCIM token"))
+				return
+			}
+
+			if err := db.TouchSCIMToken(r.Context(), token.ID, time.Now().UTC().Truncate(time.Microsecond)); err != nil {
+				log.Printf("⚠️  Failed to update SCIM token last use: %v", err)
+			}
+
+			ctx := context.WithValue(r.Context(), scimOrganizationContextKey, org)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// SCIMOrganizationFromContext returns the organization SCIMAuth stored, ok is false outside /scim/v2
+func SCIMOrganizationFromContext(ctx context.Context) (*database.Organization, bool) {
+	org, ok := ctx.Value(scimOrganizationContextKey).(*database.Organization)
+	return org, ok
+}
@@ -0,0 +1,1138 @@
+// backend/handlers/scim_handlers.go
+package handlers
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/middleware"
+	"backend/models"
+	"backend/scim"
+	"backend/sso"
+	"backend/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ============================================
+// SCIM 2.0 PROVISIONING (student information systems)
+// ============================================
+
+// scimProvision is a user a SIS asked for, checked and ready to be created
+type scimProvision struct {
+	email string
+	role  string
+	// an account the organization's own sso already created on a first login, it goes on the roster instead of a new one
+	existing *database.User
+}
+
+// SCIMServiceProviderConfigHandler tells the SIS what we support
+// GET /scim/v2/ServiceProviderConfig
+func (h *AuthHandler) SCIMServiceProviderConfigHandler(w http.ResponseWriter, r *http.Request) {
+	scim.Respond(w, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{scim.SchemaServiceProviderConfig},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]interface{}{"supported": true, "maxOperations": scim.MaxBulkOperations, "maxPayloadSize": scim.MaxBulkPayload},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": scim.MaxCount},
+		"changePassword": map[string]bool{"supported": false},
+		"sort":           map[string]bool{"supported": false},
+		"etag":           map[string]bool{"supported": false},
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "A " + middleware.SCIMTokenPrefix + " token a VirgoAI admin created for your organization",
+			"primary":     true,
+		}},
+		"meta": map[string]string{
+			"resourceType": "ServiceProviderConfig",
+			"location":     strings.TrimSuffix(config.GetBackendURL(), "/") + "/scim/v2/ServiceProviderConfig",
+		},
+	})
+}
+
+// ============================================
+// USERS
+// ============================================
+
+// ListSCIMUsersHandler pages through the organization's roster, identity providers filter on userName to find a user
+// GET /scim/v2/Users?filter=userName eq "..."&startIndex=1&count=100
+func (h *AuthHandler) ListSCIMUsersHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := scimOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	filter, err := scim.ParseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		scimFail(w, err)
+		return
+	}
+	var userFilter database.SCIMUserFilter
+	switch filter.Attribute {
+	case "":
+	case "username":
+		userFilter.UserName = filter.Value
+	case "externalid":
+		userFilter.ExternalID = filter.Value
+	case "emails", "emails.value":
+		userFilter.Email = filter.Value
+	default:
+		scim.WriteError(w, scim.NewError(http.StatusBadRequest, scim.ErrInvalidFilter, "users can be filtered on userName, externalId or emails.value"))
+		return
+	}
+
+	startIndex, count := scim.Pagination(r.URL.Query())
+	total, err := h.db.CountSCIMUsers(r.Context(), org.ID, userFilter)
+	if err != nil {
+		log.Printf("❌ Failed to count SCIM users: %v", err)
+		scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to load users"))
+		return
+	}
+
+	// groups are left out of the list, a SIS that wants them reads the user or the group
+	users := []scim.User{}
+	if count > 0 && total >= startIndex {
+		rows, err := h.db.ListSCIMUsers(r.Context(), org.ID, userFilter, count, startIndex-1)
+		if err != nil {
+			log.Printf("❌ Failed to list SCIM users: %v", err)
+			scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to load users"))
+			return
+		}
+		for i := range rows {
+			users = append(users, scimUserResource(&rows[i], nil))
+		}
+	}
+
+	scim.Respond(w, http.StatusOK, scim.ListResponse{
+		Schemas:      []string{scim.SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(users),
+		Resources:    users,
+	})
+}
+
+// GetSCIMUserHandler returns one user on the roster
+// GET /scim/v2/Users/{id}
+func (h *AuthHandler) GetSCIMUserHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := scimOrganization(w, r)
+	if !ok {
+		return
+	}
+	user, ok := h.scimUserFromPath(w, r, org)
+	if !ok {
+		return
+	}
+
+	h.respondSCIMUser(w, r, http.StatusOK, user)
+}
+
+// CreateSCIMUserHandler provisions a user, the account is created with the organization's sso provider
+// so the first SAML login finds it
+// POST /scim/v2/Users
+func (h *AuthHandler) CreateSCIMUserHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := scimOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	// Step 1: Parse and check
+	var req scim.User
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		scim.WriteError(w, scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, "Invalid request format"))
+		return
+	}
+
+	conn := h.scimSAMLConnection(r, org)
+	provision, err := h.checkSCIMUser(r, org, conn, &req)
+	if err != nil {
+		scimFail(w, err)
+		return
+	}
+
+	// Step 2: Create the account, unless the organization's sso already did
+	account := provision.existing
+	if account == nil {
+		account, err = h.db.CreateUser(
+			r.Context(),
+			provision.email,
+			"",
+			req.Name.GivenName,
+			req.Name.FamilyName,
+			provision.role,
+			samlProvider(org), //  "saml:<org>"
+			req.UserName,
+		)
+		if err != nil {
+			log.Printf("❌ Failed to create SCIM user: %v", err)
+			scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to create user"))
+			return
+		}
+	}
+
+	// Step 3: Put it on the roster
+	user, err := h.finishSCIMUser(r, org, conn, account, &req, provision)
+	if err != nil {
+		scimFail(w, err)
+		return
+	}
+
+	h.respondSCIMUser(w, r, http.StatusCreated, user)
+}
+
+// ReplaceSCIMUserHandler replaces a user with what the SIS sent, active=false deprovisions them
+// PUT /scim/v2/Users/{id}
+func (h *AuthHandler) ReplaceSCIMUserHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := scimOrganization(w, r)
+	if !ok {
+		return
+	}
+	user, ok := h.scimUserFromPath(w, r, org)
+	if !ok {
+		return
+	}
+
+	var req scim.User
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		scim.WriteError(w, scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, "Invalid request format"))
+		return
+	}
+
+	if err := h.applySCIMUser(r, org, user, &req); err != nil {
+		scimFail(w, err)
+		return
+	}
+
+	h.reloadSCIMUser(w, r, org, user.UserID)
+}
+
+// PatchSCIMUserHandler changes part of a user, this is how most identity providers deprovision:
+// {"op": "replace", "path": "active", "value": false}
+// PATCH /scim/v2/Users/{id}
+func (h *AuthHandler) PatchSCIMUserHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := scimOrganization(w, r)
+	if !ok {
+		return
+	}
+	user, ok := h.scimUserFromPath(w, r, org)
+	if !ok {
+		return
+	}
+
+	var req scim.PatchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		scim.WriteError(w, scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, "Invalid request format"))
+		return
+	}
+	if len(req.Operations) == 0 {
+		scim.WriteError(w, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "Operations is required"))
+		return
+	}
+
+	// the operations apply to the user as it is now, then the result is saved like a PUT
+	desired := scimUserResource(user, nil)
+	for _, op := range req.Operations {
+		if err := desired.Apply(op); err != nil {
+			scimFail(w, err)
+			return
+		}
+	}
+
+	if err := h.applySCIMUser(r, org, user, &desired); err != nil {
+		scimFail(w, err)
+		return
+	}
+
+	h.reloadSCIMUser(w, r, org, user.UserID)
+}
+
+// DeleteSCIMUserHandler takes the user off the roster. the account is deactivated right away and deleted
+// after the same grace period as a user deleting their own account, so a SIS mistake can still be undone
+// DELETE /scim/v2/Users/{id}
+func (h *AuthHandler) DeleteSCIMUserHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := scimOrganization(w, r)
+	if !ok {
+		return
+	}
+	user, ok := h.scimUserFromPath(w, r, org)
+	if !ok {
+		return
+	}
+
+	// Step 1: Deactivate, signs them out everywhere
+	if user.SuspendedAt == nil {
+		if err := h.setSCIMActive(r, org, user.UserID, false); err != nil {
+			log.Printf("❌ Failed to deactivate SCIM user: %v", err)
+			scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to delete user"))
+			return
+		}
+	}
+
+	// Step 2: Schedule the deletion, the deletion job does the rest
+	deleteAt, err := h.db.ScheduleUserDeletion(r.Context(), user.UserID, time.Now().Add(config.AccountDeletionGrace))
+	if err != nil {
+		log.Printf("❌ Failed to schedule deletion of SCIM user: %v", err)
+		scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to delete user"))
+		return
+	}
+
+	// Step 3: Off the roster, to the SIS the user is gone
+	if err := h.db.DeleteSCIMUser(r.Context(), org.ID, user.UserID); err != nil {
+		log.Printf("❌ Failed to take user off the %s roster: %v", org.Slug, err)
+		scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to delete user"))
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&user.UserID,
+		"scim_user_deleted",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		fmt.Sprintf("%s, deletion scheduled for %s", samlProvider(org), deleteAt.Format(time.RFC3339)),
+	)
+
+	log.Printf("🗑️  %s deleted user ID %d over SCIM, account deletion scheduled for %s", org.Slug, user.UserID, deleteAt.Format(time.RFC3339))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SCIMBulkHandler provisions many users in one request, only POST /Users operations are supported.
+// the new accounts go in with one BulkInsertUsers batch so a whole intake isn't thousands of round trips.
+// failOnErrors isn't supported, every operation is tried and gets its own status
+// POST /scim/v2/Bulk
+func (h *AuthHandler) SCIMBulkHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := scimOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	var req scim.BulkRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, scim.MaxBulkPayload)).Decode(&req); err != nil {
+		scim.WriteError(w, scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, "Invalid request format, bulk requests can be at most 1 MB"))
+		return
+	}
+	if len(req.Operations) > scim.MaxBulkOperations {
+		scim.WriteError(w, scim.NewError(http.StatusRequestEntityTooLarge, "", fmt.Sprintf("At most %d operations per bulk request", scim.MaxBulkOperations)))
+		return
+	}
+
+	conn := h.scimSAMLConnection(r, org)
+	provider := samlProvider(org)
+
+	results := make([]scim.BulkResult, len(req.Operations))
+	fail := func(i int, err error) {
+		scimErr := toSCIMError(err)
+		results[i].Status, results[i].Response = scimErr.Status, scimErr
+	}
+
+	// Step 1: Check every operation, the ones that pass are queued for the insert
+	users := make([]*scim.User, len(req.Operations))
+	provisions := make([]*scimProvision, len(req.Operations))
+	seen := make(map[string]bool)
+	var inserts []database.User
+	for i, op := range req.Operations {
+		results[i] = scim.BulkResult{Method: op.Method, BulkID: op.BulkID}
+		if !strings.EqualFold(op.Method, http.MethodPost) || strings.TrimSuffix(op.Path, "/") != "/Users" {
+			fail(i, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "only POST /Users is supported in bulk"))
+			continue
+		}
+
+		var user scim.User
+		if err := json.Unmarshal(op.Data, &user); err != nil {
+			fail(i, scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, "Invalid user"))
+			continue
+		}
+		provision, err := h.checkSCIMUser(r, org, conn, &user)
+		if err != nil {
+			fail(i, err)
+			continue
+		}
+
+		// the checks above only see the database, not the rest of this request
+		emailKey, nameKey := "email:"+provision.email, "userName:"+strings.ToLower(user.UserName)
+		if seen[emailKey] || seen[nameKey] {
+			fail(i, scim.NewError(http.StatusConflict, scim.ErrUniqueness, "this user is in the request twice"))
+			continue
+		}
+		seen[emailKey], seen[nameKey] = true, true
+
+		users[i], provisions[i] = &user, provision
+		if provision.existing == nil {
+			inserts = append(inserts, database.User{
+				Email:      provision.email,
+				FirstName:  user.Name.GivenName,
+				LastName:   user.Name.FamilyName,
+				Provider:   provider,
+				ProviderID: user.UserName,
+			})
+		}
+	}
+
+	// Step 2: Create the accounts in one batch
+	if len(inserts) > 0 {
+		if err := h.db.BulkInsertUsers(r.Context(), inserts); err != nil {
+			log.Printf("❌ Failed to bulk insert SCIM users: %v", err)
+			scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to create users"))
+			return
+		}
+	}
+
+	// Step 3: Put them on the roster. BulkInsertUsers skips emails someone took in the meantime,
+	// so an account only counts as ours when it has our provider and the userName
+	for i := range results {
+		if users[i] == nil {
+			continue
+		}
+
+		account := provisions[i].existing
+		if account == nil {
+			var err error
+			account, err = h.db.GetUserByEmail(r.Context(), provisions[i].email)
+			if err != nil || account.Provider != provider || account.ProviderID != users[i].UserName {
+				fail(i, scim.NewError(http.StatusConflict, scim.ErrUniqueness, "an account with this email already exists"))
+				continue
+			}
+		}
+
+		user, err := h.finishSCIMUser(r, org, conn, account, users[i], provisions[i])
+		if err != nil {
+			fail(i, err)
+			continue
+		}
+		results[i].Status = strconv.Itoa(http.StatusCreated)
+		results[i].Location = scimLocation("Users", user.UserID)
+	}
+
+	scim.Respond(w, http.StatusOK, scim.BulkResponse{
+		Schemas:    []string{scim.SchemaBulkResponse},
+		Operations: results,
+	})
+}
+
+// checkSCIMUser validates a user the SIS wants created
+func (h *AuthHandler) checkSCIMUser(r *http.Request, org *database.Organization, conn *database.SAMLConnection, req *scim.User) (*scimProvision, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	// the organization can only speak for its own domains, with sso set up the idp's domains have to match too
+	email := req.PrimaryEmail()
+	if len(org.EmailDomains) == 0 {
+		return nil, scim.NewError(http.StatusForbidden, "", "the organization has no email domains set up yet, users can't be provisioned until it does")
+	}
+	if !sso.EmailInDomains(email, org.EmailDomains) || (conn != nil && !sso.EmailAllowed(email, conn)) {
+		return nil, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "the email is outside the organization's email domains")
+	}
+
+	taken, err := h.db.CountSCIMUsers(r.Context(), org.ID, database.SCIMUserFilter{UserName: req.UserName})
+	if err != nil {
+		return nil, err
+	}
+	if taken > 0 {
+		return nil, scim.NewError(http.StatusConflict, scim.ErrUniqueness, "userName is already taken")
+	}
+
+	defaultRole := models.RoleStudent
+	if conn != nil {
+		defaultRole = conn.DefaultRole
+	}
+	// like an idp, the SIS can hand out teacher at most
+	provision := &scimProvision{
+		email: email,
+		role:  models.SSORole(req.Role(), defaultRole),
+	}
+
+	// somebody else's account with the same email isn't the SIS's to manage
+	if existing, err := h.db.GetUserByEmail(r.Context(), email); err == nil {
+		if existing.Provider != samlProvider(org) {
+			return nil, scim.NewError(http.StatusConflict, scim.ErrUniqueness, "an account with this email already exists")
+		}
+		provision.existing = existing
+	}
+
+	return provision, nil
+}
+
+// finishSCIMUser puts a new (or the organization's own sso) account on the roster and applies the rest of what the SIS sent.
+// no welcome email, the school tells its students and a roster sync shouldn't flood a thousand inboxes
+func (h *AuthHandler) finishSCIMUser(r *http.Request, org *database.Organization, conn *database.SAMLConnection, account *database.User, req *scim.User, provision *scimProvision) (*database.SCIMUser, error) {
+	created := provision.existing == nil
+
+	entry := &database.SCIMUser{
+		UserID:         account.ID,
+		OrganizationID: org.ID,
+		UserName:       req.UserName,
+		ExternalID:     req.ExternalID,
+		CreatedAt:      time.Now().UTC().Truncate(time.Microsecond),
+	}
+	if err := h.db.CreateSCIMUser(r.Context(), entry); err != nil {
+		log.Printf("❌ Failed to put user ID %d on the %s roster: %v", account.ID, org.Slug, err)
+		// an account nobody manages would only be in the way of the retry
+		if created {
+			h.db.DeleteUser(r.Context(), account.ID)
+		}
+		return nil, scim.NewError(http.StatusConflict, scim.ErrUniqueness, "this user is already provisioned")
+	}
+
+	if created {
+		// the saml NameID is usually the userName, so the first sso login finds the account straight away
+		if err := h.db.CreateUserIdentity(r.Context(), account.ID, samlProvider(org), req.UserName, account.Email); err != nil {
+			log.Printf("⚠️  Failed to create identity for SCIM user: %v", err)
+		}
+		// BulkInsertUsers leaves the role at its default
+		if account.Role != provision.role {
+			if err := h.db.UpdateUserRole(r.Context(), account.ID, provision.role); err != nil {
+				log.Printf("⚠️  Failed to set role of SCIM user: %v", err)
+			}
+		}
+		// the idp vouches for addresses in the organization's domains. without sso nobody has, the student verifies it
+		// themselves when they first sign in with a magic link or ask for a verification email (ResendVerificationHandler)
+		if conn != nil {
+			h.db.VerifyEmail(r.Context(), account.ID)
+		}
+	}
+
+	// provisioned again during the grace period after a SCIM delete, the account stays after all
+	if !created && account.DeletionScheduledFor != nil {
+		if err := h.db.CancelUserDeletion(r.Context(), account.ID); err != nil {
+			log.Printf("⚠️  Failed to cancel deletion of re-provisioned user ID %d: %v", account.ID, err)
+		} else {
+			h.db.CreateAuditLog(
+				r.Context(),
+				&account.ID,
+				"account_deletion_cancelled",
+				utils.GetIPAddress(r),
+				r.UserAgent(),
+				true,
+				samlProvider(org)+" provisioned again",
+			)
+		}
+	}
+
+	user, err := h.db.GetSCIMUser(r.Context(), org.ID, account.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.applySCIMUser(r, org, user, req); err != nil {
+		return nil, err
+	}
+
+	details := samlProvider(org) + " as " + provision.role
+	if !created {
+		details = samlProvider(org) + " existing account"
+	}
+	h.db.CreateAuditLog(
+		r.Context(),
+		&account.ID,
+		"scim_user_provisioned",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		details,
+	)
+
+	log.Printf("👤 %s provisioned user ID %d over SCIM", org.Slug, account.ID)
+
+	return h.db.GetSCIMUser(r.Context(), org.ID, account.ID)
+}
+
+// applySCIMUser saves the differences between a roster entry and what the SIS sent
+func (h *AuthHandler) applySCIMUser(r *http.Request, org *database.Organization, user *database.SCIMUser, desired *scim.User) error {
+	if err := desired.Validate(); err != nil {
+		return err
+	}
+
+	// the email is how the account signs in and gets recovered, the SIS can't move it somewhere else
+	if desired.PrimaryEmail() != strings.ToLower(user.Email) {
+		return scim.NewError(http.StatusBadRequest, scim.ErrMutability, "emails can't be changed over SCIM")
+	}
+
+	// Step 1: userName and externalId
+	if !strings.EqualFold(desired.UserName, user.UserName) {
+		taken, err := h.db.CountSCIMUsers(r.Context(), org.ID, database.SCIMUserFilter{UserName: desired.UserName})
+		if err != nil {
+			return err
+		}
+		if taken > 0 {
+			return scim.NewError(http.StatusConflict, scim.ErrUniqueness, "userName is already taken")
+		}
+	}
+	if desired.UserName != user.UserName || desired.ExternalID != user.ExternalID {
+		user.UserName, user.ExternalID = desired.UserName, desired.ExternalID
+		user.UpdatedAt = time.Now().UTC().Truncate(time.Microsecond)
+		if err := h.db.UpdateSCIMUser(r.Context(), user); err != nil {
+			return err
+		}
+	}
+
+	// Step 2: Names
+	var update database.ProfileUpdate
+	if desired.Name.GivenName != user.FirstName {
+		update.FirstName = &desired.Name.GivenName
+	}
+	if desired.Name.FamilyName != user.LastName {
+		update.LastName = &desired.Name.FamilyName
+	}
+	if update.FirstName != nil || update.LastName != nil {
+		if err := h.db.UpdateUser(r.Context(), user.UserID, update); err != nil {
+			return err
+		}
+	}
+
+	// Step 3: Role, no roles means the SIS doesn't manage them and the role stays.
+	// the SIS can make teachers at most (SSORole), and an admin one of our admins made stays one
+	if value := desired.Role(); value != "" && models.SSOAssignable(user.Role) {
+		role := models.SSORole(value, user.Role)
+		if role != user.Role {
+			if err := h.db.UpdateUserRole(r.Context(), user.UserID, role); err != nil {
+				return err
+			}
+			h.db.CreateAuditLog(
+				r.Context(),
+				&user.UserID,
+				"role_changed",
+				utils.GetIPAddress(r),
+				r.UserAgent(),
+				true,
+				user.Role+" -> "+role+" (from SCIM)",
+			)
+			user.Role = role
+		}
+	}
+
+	// Step 4: Active
+	if desired.Active != nil && bool(*desired.Active) != (user.SuspendedAt == nil) {
+		// a suspension an admin put on isn't the SIS's to lift. the steps above are saved, the account stays suspended
+		if bool(*desired.Active) && user.SuspendedBy != database.SuspendedBySCIM {
+			return scim.NewError(http.StatusConflict, "", "the account was suspended by an administrator, only they can reactivate it")
+		}
+		if err := h.setSCIMActive(r, org, user.UserID, bool(*desired.Active)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setSCIMActive is deprovisioning. an inactive user is a suspended one: their sessions end now and
+// LoadUser, personal access tokens and the oauth endpoints all refuse the account from the next request on.
+// the suspension is recorded as SCIM's, applySCIMUser only reactivates those
+func (h *AuthHandler) setSCIMActive(r *http.Request, org *database.Organization, userID int, active bool) error {
+	if err := h.db.SetUserSuspended(r.Context(), userID, !active, database.SuspendedBySCIM); err != nil {
+		return err
+	}
+
+	action := "scim_user_reactivated"
+	if !active {
+		if _, err := h.db.DeleteUserSessions(r.Context(), userID, ""); err != nil {
+			log.Printf("⚠️  Failed to revoke sessions of deprovisioned user: %v", err)
+		}
+		if _, err := h.db.RevokeUserRefreshTokens(r.Context(), userID, ""); err != nil {
+			log.Printf("⚠️  Failed to revoke refresh tokens of deprovisioned user: %v", err)
+		}
+		action = "scim_user_deactivated"
+		log.Printf("⛔ %s deprovisioned user ID %d over SCIM", org.Slug, userID)
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		action,
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		samlProvider(org),
+	)
+	return nil
+}
+
+// scimUserFromPath loads the {id} user, users on another organization's roster are a 404 like missing ones
+func (h *AuthHandler) scimUserFromPath(w http.ResponseWriter, r *http.Request, org *database.Organization) (*database.SCIMUser, bool) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		scim.WriteError(w, scim.NewError(http.StatusNotFound, "", "User not found"))
+		return nil, false
+	}
+
+	user, err := h.db.GetSCIMUser(r.Context(), org.ID, userID)
+	if err != nil {
+		scim.WriteError(w, scim.NewError(http.StatusNotFound, "", "User not found"))
+		return nil, false
+	}
+	return user, true
+}
+
+// reloadSCIMUser answers a PUT or PATCH with the user as it is saved now
+func (h *AuthHandler) reloadSCIMUser(w http.ResponseWriter, r *http.Request, org *database.Organization, userID int) {
+	user, err := h.db.GetSCIMUser(r.Context(), org.ID, userID)
+	if err != nil {
+		log.Printf("❌ Failed to reload SCIM user: %v", err)
+		scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to load user"))
+		return
+	}
+	h.respondSCIMUser(w, r, http.StatusOK, user)
+}
+
+// respondSCIMUser writes one user with their groups
+func (h *AuthHandler) respondSCIMUser(w http.ResponseWriter, r *http.Request, status int, user *database.SCIMUser) {
+	groups, err := h.db.ListSCIMUserGroups(r.Context(), user.UserID)
+	if err != nil {
+		log.Printf("⚠️  Failed to load groups of SCIM user: %v", err)
+	}
+
+	resource := scimUserResource(user, groups)
+	w.Header().Set("Location", resource.Meta.Location)
+	scim.Respond(w, status, resource)
+}
+
+// scimUserResource is what the SIS sees of a roster entry, a suspended account is inactive
+func scimUserResource(user *database.SCIMUser, groups []database.SCIMGroup) scim.User {
+	active := scim.Bool(user.SuspendedAt == nil)
+	resource := scim.User{
+		Schemas:     []string{scim.SchemaUser},
+		ID:          strconv.Itoa(user.UserID),
+		ExternalID:  user.ExternalID,
+		UserName:    user.UserName,
+		Name:        scim.Name{GivenName: user.FirstName, FamilyName: user.LastName},
+		DisplayName: strings.TrimSpace(user.FirstName + " " + user.LastName),
+		Emails:      []scim.Value{{Value: user.Email, Type: "work", Primary: true}},
+		Roles:       []scim.Value{{Value: user.Role, Primary: true}},
+		Active:      &active,
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     scimLocation("Users", user.UserID),
+		},
+	}
+	for _, group := range groups {
+		resource.Groups = append(resource.Groups, scim.Member{
+			Value:   strconv.Itoa(group.ID),
+			Display: group.DisplayName,
+			Ref:     scimLocation("Groups", group.ID),
+		})
+	}
+	return resource
+}
+
+// ============================================
+// GROUPS
+// ============================================
+
+// ListSCIMGroupsHandler pages through the organization's groups. excludedAttributes=members
+// skips loading the members, identity providers ask for that when they only look a group up
+// GET /scim/v2/Groups?filter=displayName eq "..."
+func (h *AuthHandler) ListSCIMGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := scimOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	filter, err := scim.ParseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		scimFail(w, err)
+		return
+	}
+	var groupFilter database.SCIMGroupFilter
+	switch filter.Attribute {
+	case "":
+	case "displayname":
+		groupFilter.DisplayName = filter.Value
+	case "externalid":
+		groupFilter.ExternalID = filter.Value
+	default:
+		scim.WriteError(w, scim.NewError(http.StatusBadRequest, scim.ErrInvalidFilter, "groups can be filtered on displayName or externalId"))
+		return
+	}
+
+	startIndex, count := scim.Pagination(r.URL.Query())
+	total, err := h.db.CountSCIMGroups(r.Context(), org.ID, groupFilter)
+	if err != nil {
+		log.Printf("❌ Failed to count SCIM groups: %v", err)
+		scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to load groups"))
+		return
+	}
+
+	groups := []scim.Group{}
+	if count > 0 && total >= startIndex {
+		rows, err := h.db.ListSCIMGroups(r.Context(), org.ID, groupFilter, count, startIndex-1)
+		if err != nil {
+			log.Printf("❌ Failed to list SCIM groups: %v", err)
+			scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to load groups"))
+			return
+		}
+
+		withMembers := !scim.Excluded(r.URL.Query(), "members")
+		for i := range rows {
+			var members []database.SCIMGroupMember
+			if withMembers {
+				if members, err = h.db.ListSCIMGroupMembers(r.Context(), rows[i].ID); err != nil {
+					log.Printf("❌ Failed to list SCIM group members: %v", err)
+					scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to load groups"))
+					return
+				}
+			}
+			groups = append(groups, scimGroupResource(&rows[i], members))
+		}
+	}
+
+	scim.Respond(w, http.StatusOK, scim.ListResponse{
+		Schemas:      []string{scim.SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(groups),
+		Resources:    groups,
+	})
+}
+
+// GetSCIMGroupHandler returns one group with its members
+// GET /scim/v2/Groups/{id}
+func (h *AuthHandler) GetSCIMGroupHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := scimOrganization(w, r)
+	if !ok {
+		return
+	}
+	group, ok := h.scimGroupFromPath(w, r, org)
+	if !ok {
+		return
+	}
+
+	h.respondSCIMGroup(w, r, http.StatusOK, group)
+}
+
+// CreateSCIMGroupHandler adds a group. members are user ids from /Users, ids not on the roster are skipped
+// POST /scim/v2/Groups
+func (h *AuthHandler) CreateSCIMGroupHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := scimOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	var req scim.Group
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		scim.WriteError(w, scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, "Invalid request format"))
+		return
+	}
+
+	group := &database.SCIMGroup{OrganizationID: org.ID}
+	if err := h.setSCIMGroupName(r, group, req.DisplayName, req.ExternalID); err != nil {
+		scimFail(w, err)
+		return
+	}
+	memberIDs, err := scimMemberIDs(req.Members)
+	if err != nil {
+		scimFail(w, err)
+		return
+	}
+
+	group.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	if err := h.db.CreateSCIMGroup(r.Context(), group); err != nil {
+		log.Printf("❌ Failed to create SCIM group: %v", err)
+		scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to create group"))
+		return
+	}
+	if len(memberIDs) > 0 {
+		if err := h.db.AddSCIMGroupMembers(r.Context(), org.ID, group.ID, memberIDs); err != nil {
+			log.Printf("❌ Failed to add SCIM group members: %v", err)
+			scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to add group members"))
+			return
+		}
+	}
+
+	log.Printf("✅ %s created SCIM group %d", org.Slug, group.ID)
+
+	h.reloadSCIMGroup(w, r, org, group.ID, http.StatusCreated)
+}
+
+// ReplaceSCIMGroupHandler replaces a group's name and its whole membership
+// PUT /scim/v2/Groups/{id}
+func (h *AuthHandler) ReplaceSCIMGroupHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := scimOrganization(w, r)
+	if !ok {
+		return
+	}
+	group, ok := h.scimGroupFromPath(w, r, org)
+	if !ok {
+		return
+	}
+
+	var req scim.Group
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		scim.WriteError(w, scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, "Invalid request format"))
+		return
+	}
+
+	if err := h.setSCIMGroupName(r, group, req.DisplayName, req.ExternalID); err != nil {
+		scimFail(w, err)
+		return
+	}
+	memberIDs, err := scimMemberIDs(req.Members)
+	if err != nil {
+		scimFail(w, err)
+		return
+	}
+
+	group.UpdatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	if err := h.db.UpdateSCIMGroup(r.Context(), group); err != nil {
+		log.Printf("❌ Failed to update SCIM group: %v", err)
+		scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to update group"))
+		return
+	}
+	if err := h.db.ReplaceSCIMGroupMembers(r.Context(), org.ID, group.ID, memberIDs); err != nil {
+		log.Printf("❌ Failed to replace SCIM group members: %v", err)
+		scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to update group members"))
+		return
+	}
+
+	h.reloadSCIMGroup(w, r, org, group.ID, http.StatusOK)
+}
+
+// PatchSCIMGroupHandler renames a group or adds, removes or replaces members. the operations run in order
+// PATCH /scim/v2/Groups/{id}
+func (h *AuthHandler) PatchSCIMGroupHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := scimOrganization(w, r)
+	if !ok {
+		return
+	}
+	group, ok := h.scimGroupFromPath(w, r, org)
+	if !ok {
+		return
+	}
+
+	var req scim.PatchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		scim.WriteError(w, scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, "Invalid request format"))
+		return
+	}
+	if len(req.Operations) == 0 {
+		scim.WriteError(w, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "Operations is required"))
+		return
+	}
+
+	for _, op := range req.Operations {
+		change, err := scim.ParseGroupOperation(op)
+		if err != nil {
+			scimFail(w, err)
+			return
+		}
+
+		if change.DisplayName != nil || change.ExternalID != nil {
+			name, externalID := group.DisplayName, group.ExternalID
+			if change.DisplayName != nil {
+				name = *change.DisplayName
+			}
+			if change.ExternalID != nil {
+				externalID = *change.ExternalID
+			}
+			if err := h.setSCIMGroupName(r, group, name, externalID); err != nil {
+				scimFail(w, err)
+				return
+			}
+			group.UpdatedAt = time.Now().UTC().Truncate(time.Microsecond)
+			if err := h.db.UpdateSCIMGroup(r.Context(), group); err != nil {
+				log.Printf("❌ Failed to update SCIM group: %v", err)
+				scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to update group"))
+				return
+			}
+		}
+
+		if change.MemberOp == "" {
+			continue
+		}
+		memberIDs, err := scimMemberIDs(change.Members)
+		if err != nil {
+			scimFail(w, err)
+			return
+		}
+		switch {
+		case change.MemberOp == "add":
+			err = h.db.AddSCIMGroupMembers(r.Context(), org.ID, group.ID, memberIDs)
+		case change.MemberOp == "remove" && len(memberIDs) > 0:
+			err = h.db.RemoveSCIMGroupMembers(r.Context(), org.ID, group.ID, memberIDs)
+		default:
+			// replace, or a remove without a value which takes everyone out
+			err = h.db.ReplaceSCIMGroupMembers(r.Context(), org.ID, group.ID, memberIDs)
+		}
+		if err != nil {
+			log.Printf("❌ Failed to update SCIM group members: %v", err)
+			scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to update group members"))
+			return
+		}
+	}
+
+	h.reloadSCIMGroup(w, r, org, group.ID, http.StatusOK)
+}
+
+// DeleteSCIMGroupHandler deletes a group, its members keep their accounts
+// DELETE /scim/v2/Groups/{id}
+func (h *AuthHandler) DeleteSCIMGroupHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := scimOrganization(w, r)
+	if !ok {
+		return
+	}
+	group, ok := h.scimGroupFromPath(w, r, org)
+	if !ok {
+		return
+	}
+
+	if err := h.db.DeleteSCIMGroup(r.Context(), org.ID, group.ID); err != nil {
+		log.Printf("❌ Failed to delete SCIM group: %v", err)
+		scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to delete group"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setSCIMGroupName validates a group's new name and externalId and sets them on group
+func (h *AuthHandler) setSCIMGroupName(r *http.Request, group *database.SCIMGroup, name, externalID string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 255 {
+		return scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "displayName must be between 1 and 255 characters")
+	}
+	if len(externalID) > 255 {
+		return scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "externalId must be at most 255 characters")
+	}
+
+	if !strings.EqualFold(name, group.DisplayName) {
+		taken, err := h.db.CountSCIMGroups(r.Context(), group.OrganizationID, database.SCIMGroupFilter{DisplayName: name})
+		if err != nil {
+			return err
+		}
+		if taken > 0 {
+			return scim.NewError(http.StatusConflict, scim.ErrUniqueness, "displayName is already taken")
+		}
+	}
+
+	group.DisplayName, group.ExternalID = name, externalID
+	return nil
+}
+
+// scimGroupFromPath loads the {id} group of the organization
+func (h *AuthHandler) scimGroupFromPath(w http.ResponseWriter, r *http.Request, org *database.Organization) (*database.SCIMGroup, bool) {
+	groupID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		scim.WriteError(w, scim.NewError(http.StatusNotFound, "", "Group not found"))
+		return nil, false
+	}
+
+	group, err := h.db.GetSCIMGroup(r.Context(), org.ID, groupID)
+	if err != nil {
+		scim.WriteError(w, scim.NewError(http.StatusNotFound, "", "Group not found"))
+		return nil, false
+	}
+	return group, true
+}
+
+// reloadSCIMGroup answers a write with the group as it is saved now
+func (h *AuthHandler) reloadSCIMGroup(w http.ResponseWriter, r *http.Request, org *database.Organization, groupID, status int) {
+	group, err := h.db.GetSCIMGroup(r.Context(), org.ID, groupID)
+	if err != nil {
+		log.Printf("❌ Failed to reload SCIM group: %v", err)
+		scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to load group"))
+		return
+	}
+	h.respondSCIMGroup(w, r, status, group)
+}
+
+// respondSCIMGroup writes one group with its members
+func (h *AuthHandler) respondSCIMGroup(w http.ResponseWriter, r *http.Request, status int, group *database.SCIMGroup) {
+	members, err := h.db.ListSCIMGroupMembers(r.Context(), group.ID)
+	if err != nil {
+		log.Printf("❌ Failed to list SCIM group members: %v", err)
+		scim.WriteError(w, scim.NewError(http.StatusInternalServerError, "", "Failed to load group"))
+		return
+	}
+
+	resource := scimGroupResource(group, members)
+	w.Header().Set("Location", resource.Meta.Location)
+	scim.Respond(w, status, resource)
+}
+
+// scimGroupResource is what the SIS sees of a group
+func scimGroupResource(group *database.SCIMGroup, members []database.SCIMGroupMember) scim.Group {
+	resource := scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          strconv.Itoa(group.ID),
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt,
+			LastModified: group.UpdatedAt,
+			Location:     scimLocation("Groups", group.ID),
+		},
+	}
+	for _, member := range members {
+		resource.Members = append(resource.Members, scim.Member{
+			Value:   strconv.Itoa(member.UserID),
+			Display: member.UserName,
+			Ref:     scimLocation("Users", member.UserID),
+		})
+	}
+	return resource
+}
+
+// scimMemberIDs reads the user ids out of members
+func scimMemberIDs(members []scim.Member) ([]int, error) {
+	var ids []int
+	for _, member := range members {
+		id, err := strconv.Atoi(member.Value)
+		if err != nil {
+			return nil, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "member "+member.Value+" is not a user id")
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ============================================
+// HELPERS
+// ============================================
+
+// scimOrganization returns the organization the request's SCIM token belongs to
+func scimOrganization(w http.ResponseWriter, r *http.Request) (*database.Organization, bool) {
+	org, ok := middleware.SCIMOrganizationFromContext(r.Context())
+	if !ok {
+		scim.WriteError(w, scim.NewError(http.StatusUnauthorized, "", "A SCIM token is required"))
+	}
+	return org, ok
+}
+
+// scimSAMLConnection is the organization's sso settings, nil when it doesn't use sso
+func (h *AuthHandler) scimSAMLConnection(r *http.Request, org *database.Organization) *database.SAMLConnection {
+	conn, err := h.db.GetSAMLConnection(r.Context(), org.ID)
+	if err != nil {
+		return nil
+	}
+	return conn
+}
+
+// scimLocation is the url of a user or group
+func scimLocation(resource string, id int) string {
+	return strings.TrimSuffix(config.GetBackendURL(), "/") + "/scim/v2/" + resource + "/" + strconv.Itoa(id)
+}
+
+// toSCIMError passes scim errors through, anything else is a database error and becomes a 500
+func toSCIMError(err error) *scim.Error {
+	var scimErr *scim.Error
+	if errors.As(err, &scimErr) {
+		return scimErr
+	}
+	log.Printf("❌ SCIM request failed: %v", err)
+	return scim.NewError(http.StatusInternalServerError, "", "Internal error")
+}
+
+// scimFail answers with err, see toSCIMError
+func scimFail(w http.ResponseWriter, err error) {
+	scim.WriteError(w, toSCIMError(err))
+}
@@ -0,0 +1,99 @@
+// backend/mailer/sendgrid.go
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"time"
+)
+
+const sendGridURL = "https://api.sendgrid.com/v3/mail/send"
+
+// SendGridMailer talks to the sendgrid v3 http api directly so we dont need their sdk
+type SendGridMailer struct {
+	APIKey string
+	From   string
+	client *http.Client
+}
+
+// NewSendGridMailer creates a sendgrid mailer with a sane http timeout
+func NewSendGridMailer(apiKey, from string) *SendGridMailer {
+	return &SendGridMailer{
+		APIKey: apiKey,
+		From:   from,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// these mirror the json body from the sendgrid docs
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridRequest struct {
+	Personalizations []struct {
+		To []sendGridAddress `json:"to"`
+	} `json:"personalizations"`
+	From    sendGridAddress   `json:"from"`
+	Subject string            `json:"subject"`
+	Content []sendGridContent `json:"content"`
+}
+
+// Send posts the message to sendgrid, anything but a 2xx is an error
+func (m *SendGridMailer) Send(ctx context.Context, msg Message) error {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("invalid from address: %w", err)
+	}
+
+	var body sendGridRequest
+	body.Personalizations = make([]struct {
+		To []sendGridAddress `json:"to"`
+	}, 1)
+	body.Personalizations[0].To = []sendGridAddress{{Email: msg.To}}
+	body.From = sendGridAddress{Email: from.Address, Name: from.Name}
+	body.Subject = msg.Subject
+	// sendgrid wants text/plain before text/html
+	if msg.TextBody != "" {
+		body.Content = append(body.Content, sendGridContent{Type: "text/plain", Value: msg.TextBody})
+	}
+	if msg.HTMLBody != "" {
+		body.Content = append(body.Content, sendGridContent{Type: "text/html", Value: msg.HTMLBody})
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("unable to encode sendgrid request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sendGridURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("unable to build sendgrid request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+m.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sendgrid request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("sendgrid returned %d: %s", resp.StatusCode, detail)
+	}
+
+	return nil
+}
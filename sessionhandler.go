@@ -0,0 +1,220 @@
+// backend/handlers/session_handlers.go
+package handlers
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/middleware"
+	"backend/utils"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ============================================
+// DEVICE / SESSION MANAGEMENT
+// ============================================
+
+// SessionResponse is what the "My devices" page shows for one session
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	IPAddress  string    `json:"ipAddress"`
+	UserAgent  string    `json:"userAgent"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"`
+}
+
+// currentUserID reads the logged in user, from the context when LoadUser already ran, otherwise from the auth-session
+func currentUserID(r *http.Request) (int, bool) {
+	if user, ok := middleware.UserFromContext(r.Context()); ok {
+		return user.ID, true
+	}
+
+	session, _ := config.GetSessionStore().Get(r, "auth-session")
+	userID, ok := session.Values["user_id"].(int)
+	return userID, ok
+}
+
+// currentSessionRecordID is the id of this request's session as stored in the sessions table
+func currentSessionRecordID(r *http.Request) string {
+	session, _ := config.GetSessionStore().Get(r, "auth-session")
+	if session.ID == "" {
+		return ""
+	}
+	return config.SessionRecordID(session.ID)
+}
+
+// ListSessionsHandler lists every device the user is signed in on
+// GET /api/auth/sessions
+func (h *AuthHandler) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	sessions, err := h.db.ListUserSessions(r.Context(), userID)
+	if err != nil {
+		log.Printf("❌ Failed to list sessions: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to load sessions")
+		return
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"sessions": sessionResponses(sessions, currentSessionRecordID(r)),
+	})
+}
+
+// sessionResponses turns sessions rows into what the device lists show, current marks the caller's own session
+func sessionResponses(sessions []database.Session, current string) []SessionResponse {
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, SessionResponse{
+			ID:         session.ID,
+			Device:     describeDevice(session.UserAgent),
+			IPAddress:  session.IPAddress,
+			UserAgent:  session.UserAgent,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.ID == current,
+		})
+	}
+	return response
+}
+
+// RevokeSessionHandler signs out one device
+// DELETE /api/auth/sessions/{id}
+func (h *AuthHandler) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	sessionID := mux.Vars(r)["id"]
+	if sessionID == currentSessionRecordID(r) {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Use logout to end your current session")
+		return
+	}
+
+	if err := h.db.DeleteUserSession(r.Context(), userID, sessionID); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		"session_revoked",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		"",
+	)
+
+	log.Printf("✅ Session revoked for user ID: %d", userID)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"message": "Session signed out",
+	})
+}
+
+// RevokeOtherSessionsHandler signs out everywhere except the current device
+// DELETE /api/auth/sessions
+func (h *AuthHandler) RevokeOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		utils.ErrorResponseJSON(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	revoked, err := h.db.DeleteUserSessions(r.Context(), userID, currentSessionRecordID(r))
+	if err != nil {
+		log.Printf("❌ Failed to revoke sessions: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to sign out other devices")
+		return
+	}
+	// the mobile app signs in with tokens, not a session
+	signedOut, err := h.db.RevokeUserRefreshTokens(r.Context(), userID, currentRefreshFamily(r))
+	if err != nil {
+		log.Printf("❌ Failed to revoke refresh tokens: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to sign out other devices")
+		return
+	}
+	revoked += signedOut
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		"sessions_revoked",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		"",
+	)
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Signed out of all other devices",
+		"revoked": revoked,
+	})
+}
+
+// CSRFTokenHandler hands the Next.js app the token it sends back in X-CSRF-Token, call it on load and again after
+// signing in or out, the token belongs to the session
+// GET /api/auth/csrf
+func (h *AuthHandler) CSRFTokenHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"csrfToken": middleware.CSRFToken(w, r),
+	})
+}
+
+// describeDevice turns a user agent into something readable like "Chrome on Windows"
+// this is only a rough guess for display, the raw user agent is sent too
+func describeDevice(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"), strings.Contains(userAgent, "CriOS/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	}
+
+	os := "Unknown OS"
+	switch {
+	case strings.Contains(userAgent, "iPhone"):
+		os = "iPhone"
+	case strings.Contains(userAgent, "iPad"):
+		os = "iPad"
+	case strings.Contains(userAgent, "Android"):
+		os = "Android"
+	case strings.Contains(userAgent, "Windows"):
+		os = "Windows"
+	case strings.Contains(userAgent, "Mac OS X"):
+		os = "macOS"
+	case strings.Contains(userAgent, "CrOS"):
+		os = "ChromeOS"
+	case strings.Contains(userAgent, "Linux"):
+		os = "Linux"
+	}
+
+	return browser + " on " + os
+}
@@ -0,0 +1,145 @@
+// backend/handlers/throttle.go
+package handlers
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/mailer"
+	"backend/ratelimit"
+	"backend/utils"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================
+// BRUTE FORCE PROTECTION
+// ============================================
+
+// how fast each endpoint can be hit, one bucket per ip and (where it makes sense) one per email.
+// the per ip limits are generous on purpose, a whole classroom can sit behind one school ip
+var (
+	loginIPRate          = ratelimit.Rate{Burst: 30, Every: 2 * time.Second}
+	loginEmailRate       = ratelimit.Rate{Burst: 10, Every: 30 * time.Second}
+	registerIPRate       = ratelimit.Rate{Burst: 30, Every: time.Minute}
+	forgotPasswordIPRate = ratelimit.Rate{Burst: 10, Every: time.Minute}
+	forgotPasswordRate   = ratelimit.Rate{Burst: 3, Every: 20 * time.Minute}
+	resetPasswordIPRate  = ratelimit.Rate{Burst: 10, Every: time.Minute}
+	magicLinkIPRate      = ratelimit.Rate{Burst: 10, Every: time.Minute}
+	magicLinkRate        = ratelimit.Rate{Burst: 3, Every: 10 * time.Minute}
+	dataExportRate       = ratelimit.Rate{Burst: 3, Every: 20 * time.Minute}
+	// partner backends swap codes from one server ip, so this one is about as loose as login
+	oauthTokenIPRate = ratelimit.Rate{Burst: 30, Every: 2 * time.Second}
+)
+
+const (
+	// the first few wrong passwords are free, after that every attempt has to wait a bit longer
+	loginDelayAfter = 3
+	maxLoginDelay   = 5 * time.Minute
+	// at this many wrong passwords in a row the account is locked and the owner gets an email
+	lockoutThreshold = 10
+	lockoutDuration  = 30 * time.Minute
+)
+
+// throttle takes a token from the bucket for key and writes a 429 when it is empty
+// if the limiter itself is broken we let the request through rather than locking everyone out
+func (h *AuthHandler) throttle(w http.ResponseWriter, r *http.Request, key string, rate ratelimit.Rate) bool {
+	allowed, retryAfter, err := h.limiter.Allow(r.Context(), key, rate)
+	if err != nil {
+		log.Printf("⚠️  Rate limiter error for %s: %v", key, err)
+		return true
+	}
+	if !allowed {
+		log.Printf("⚠️  Rate limit hit for %s", key)
+		tooManyRequests(w, retryAfter, "Too many attempts, please try again later")
+		return false
+	}
+	return true
+}
+
+// throttleIP limits one endpoint per client ip
+func (h *AuthHandler) throttleIP(w http.ResponseWriter, r *http.Request, endpoint string, rate ratelimit.Rate) bool {
+	return h.throttle(w, r, endpoint+":ip:"+utils.GetIPAddress(r), rate)
+}
+
+// throttleEmail limits one endpoint per email address, no matter how many ips the requests come from
+func (h *AuthHandler) throttleEmail(w http.ResponseWriter, r *http.Request, endpoint, email string, rate ratelimit.Rate) bool {
+	return h.throttle(w, r, endpoint+":email:"+strings.ToLower(strings.TrimSpace(email)), rate)
+}
+
+// tooManyRequests writes a 429 with a Retry-After header in whole seconds
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	utils.ErrorResponseJSON(w, http.StatusTooManyRequests, message)
+}
+
+// loginDelay is how long the account has to wait after this many wrong passwords in a row
+// 1s, 2s, 4s ... up to maxLoginDelay, and the full lockout once lockoutThreshold is reached
+func loginDelay(attempts int) time.Duration {
+	if attempts >= lockoutThreshold {
+		return lockoutDuration
+	}
+	if attempts < loginDelayAfter {
+		return 0
+	}
+	delay := time.Second << uint(attempts-loginDelayAfter)
+	if delay > maxLoginDelay {
+		delay = maxLoginDelay
+	}
+	return delay
+}
+
+// recordFailedLogin counts a wrong password and locks the account for a while when needed
+func (h *AuthHandler) recordFailedLogin(r *http.Request, user *database.User) {
+	attempts, err := h.db.RecordFailedLogin(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("❌ Failed to record failed login: %v", err)
+		return
+	}
+
+	delay := loginDelay(attempts)
+	if delay == 0 {
+		return
+	}
+	if err := h.db.LockUser(r.Context(), user.ID, delay); err != nil {
+		log.Printf("❌ Failed to lock user: %v", err)
+		return
+	}
+
+	// only the attempt that crosses the threshold locks the account properly, tell the owner once
+	if attempts == lockoutThreshold {
+		h.db.CreateAuditLog(
+			r.Context(),
+			&user.ID,
+			"account_locked",
+			utils.GetIPAddress(r),
+			r.UserAgent(),
+			true,
+			fmt.Sprintf("%d failed logins", attempts),
+		)
+		log.Printf("🔒 Account locked after %d failed logins: %s", attempts, user.Email)
+		h.sendLockoutEmail(r, user)
+	}
+}
+
+// sendLockoutEmail tells the owner their account was locked and gives them a reset link,
+// resetting the password also unlocks the account so they dont have to wait
+func (h *AuthHandler) sendLockoutEmail(r *http.Request, user *database.User) {
+	token := utils.GenerateSecureToken(32)
+	expiresAt := time.Now().Add(15 * time.Minute)
+	if err := h.db.CreatePasswordResetToken(r.Context(), user.ID, token, expiresAt); err != nil {
+		log.Printf("❌ Failed to create reset token: %v", err)
+		return
+	}
+
+	h.sendEmail(r, user, mailer.TemplateAccountLocked, config.GetFrontendURL()+"/reset-password?token="+url.QueryEscape(token))
+}
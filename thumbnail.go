@@ -0,0 +1,66 @@
+// backend/storage/thumbnail.go
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// MaxImagePixels stops "decompression bombs", a tiny file that claims to be 50000x50000 pixels
+const MaxImagePixels = 40_000_000
+
+// Thumbnail decodes an image and scales it down to fit in a size x size box, keeping the aspect ratio.
+// the result is always a png, small images are not scaled up
+func Thumbnail(r io.ReadSeeker, size int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read image: %w", err)
+	}
+	if config.Width*config.Height > MaxImagePixels {
+		return nil, errors.New("image is too large")
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode image: %w", err)
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			height = height * size / width
+			width = size
+		} else {
+			width = width * size / height
+			height = size
+		}
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, fmt.Errorf("unable to encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,58 @@
+// backend/totp/totp_test.go
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// the SHA1 vectors from RFC 6238 appendix B. the rfc prints 8 digits, our 6 digit codes are the last 6 of them
+func TestGenerateCodeRFC6238(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, v := range vectors {
+		code, err := GenerateCode(secret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("GenerateCode(%d): %v", v.unix, err)
+		}
+		if code != v.code {
+			t.Errorf("GenerateCode(%d) = %s, want %s", v.unix, code, v.code)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+
+	// the code from the step before still works, one from two steps back doesn't
+	previous, _ := GenerateCode(secret, Step(now)-1)
+	if step, ok := Validate(secret, previous, now); !ok || step != Step(now)-1 {
+		t.Errorf("Validate(previous step) = %d, %v, want %d, true", step, ok, Step(now)-1)
+	}
+	old, _ := GenerateCode(secret, Step(now)-2)
+	if _, ok := Validate(secret, old, now); ok {
+		t.Error("Validate accepted a code from two steps back")
+	}
+
+	// spaces are allowed, wrong lengths aren't
+	if _, ok := Validate(secret, "050 471", now); !ok {
+		t.Error("Validate refused a code with a space in it")
+	}
+	if _, ok := Validate(secret, "50471", now); ok {
+		t.Error("Validate accepted a 5 digit code")
+	}
+}
@@ -1,9 +1,12 @@
 package models
 
 import (
+	"backend/mailer"
 	"backend/utils"
 	"errors"
+	"net/url"
 	"regexp"
+	"strings"
 	"time"
 )
 
@@ -44,12 +47,67 @@ type ChangePasswordRequest struct {
 }
 type ForgotPasswordRequest struct {
 	Email string `json:"email"`
-	// we will add SendGrid to this
 }
 type ResetPasswordRequest struct {
 	Token       string `json:"token"`
 	NewPassword string `json:"newPassword"`
 }
+
+// the token comes from the link in the verification email
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+// the profile page only sends the fields that changed, so everything is a pointer and nil means "leave it alone".
+// an empty targetInterviewDate clears it, the other optional fields are cleared with an empty string too
+type UpdateProfileRequest struct {
+	FirstName           *string `json:"firstName"`
+	LastName            *string `json:"lastName"`
+	PreferredLanguage   *string `json:"preferredLanguage"`
+	Timezone            *string `json:"timezone"`
+	NativeLanguage      *string `json:"nativeLanguage"`
+	TargetInterviewDate *string `json:"targetInterviewDate"` // YYYY-MM-DD
+	AvatarURL           *string `json:"avatarUrl"`
+}
+
+// deleting your account needs your password again, accounts without one (oauth, passkeys) just send {}
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// passwordless sign in, the link is emailed to this address
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// creating a personal access token, scopes are permissions like "students:view".
+// expiresInDays defaults to 90, tokens that never expire aren't allowed
+type CreateAPITokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+// used by every two factor endpoint, send either the 6 digit code or one recovery code
+type MFACodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// the mobile app's token request, named like oauth since the answer is an oauth token response.
+// grant_type "session" swaps the auth-session cookie for tokens, "refresh_token" rotates a refresh token
+type MobileTokenRequest struct {
+	GrantType    string `json:"grant_type"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// signing out on the mobile app, revokes the refresh token and every token rotated from it
+type RevokeTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
 type AuditLog struct {
 	ID            int       `json:"id"`
 	UserID        *int      `json:"userId,omitempty"` // Nullable
@@ -85,7 +143,8 @@ func (request *RegisterRequest) Validate() error {
 		return err
 	}
 	// now we check if the request is either teacher and student
-	if request.Role != "student" && request.Role != "teacher" {
+	// the admin roles are only ever handed out by another admin
+	if request.Role != RoleStudent && request.Role != RoleTeacher {
 		return errors.New("invalid role: must be either student or teacher")
 	}
 	//
@@ -131,3 +190,102 @@ func (passwordrequest *ChangePasswordRequest) Validate() error {
 	}
 	return nil
 }
+
+// one of the two has to be filled in
+func (mfarequest *MFACodeRequest) Validate() error {
+	if mfarequest.Code == "" && mfarequest.RecoveryCode == "" {
+		return errors.New("a code or a recovery code is required")
+	}
+	return nil
+}
+
+// names are required, the rest is optional but has to make sense when it's there
+func (profilerequest *UpdateProfileRequest) Validate() error {
+	if profilerequest.FirstName != nil {
+		*profilerequest.FirstName = strings.TrimSpace(*profilerequest.FirstName)
+		if *profilerequest.FirstName == "" || len(*profilerequest.FirstName) > 100 {
+			return errors.New("first name must be between 1 and 100 characters")
+		}
+	}
+	if profilerequest.LastName != nil {
+		*profilerequest.LastName = strings.TrimSpace(*profilerequest.LastName)
+		if *profilerequest.LastName == "" || len(*profilerequest.LastName) > 100 {
+			return errors.New("last name must be between 1 and 100 characters")
+		}
+	}
+	// only the languages we have emails (and the app) in
+	if profilerequest.PreferredLanguage != nil && *profilerequest.PreferredLanguage != "" {
+		supported := false
+		for _, locale := range mailer.SupportedLocales {
+			if *profilerequest.PreferredLanguage == locale {
+				supported = true
+			}
+		}
+		if !supported {
+			return errors.New("preferred language must be en, es or zh")
+		}
+	}
+	// IANA names like America/Mexico_City, LoadLocation also accepts "" and "Local" so those are checked first
+	if profilerequest.Timezone != nil && *profilerequest.Timezone != "" {
+		if *profilerequest.Timezone == "Local" || len(*profilerequest.Timezone) > 64 {
+			return errors.New("timezone is not valid")
+		}
+		if _, err := time.LoadLocation(*profilerequest.Timezone); err != nil {
+			return errors.New("timezone is not valid")
+		}
+	}
+	if profilerequest.NativeLanguage != nil && len(*profilerequest.NativeLanguage) > 64 {
+		return errors.New("native language must be at most 64 characters")
+	}
+	if profilerequest.TargetInterviewDate != nil && *profilerequest.TargetInterviewDate != "" {
+		if _, err := time.Parse("2006-01-02", *profilerequest.TargetInterviewDate); err != nil {
+			return errors.New("target interview date must look like 2006-01-02")
+		}
+	}
+	// the avatar is a link we show in an img tag, so only http(s) and nothing like javascript:
+	if profilerequest.AvatarURL != nil && *profilerequest.AvatarURL != "" {
+		avatar, err := url.Parse(*profilerequest.AvatarURL)
+		if err != nil || (avatar.Scheme != "https" && avatar.Scheme != "http") || avatar.Host == "" || len(*profilerequest.AvatarURL) > 2048 {
+			return errors.New("avatar must be an http or https url")
+		}
+	}
+	return nil
+}
+
+// the name is only there so the user can tell their tokens apart
+func (tokenrequest *CreateAPITokenRequest) Validate() error {
+	tokenrequest.Name = strings.TrimSpace(tokenrequest.Name)
+	if tokenrequest.Name == "" || len(tokenrequest.Name) > 100 {
+		return errors.New("name must be between 1 and 100 characters")
+	}
+	if len(tokenrequest.Scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range tokenrequest.Scopes {
+		if !ValidPermission(Permission(scope)) {
+			return errors.New("unknown scope: " + scope)
+		}
+	}
+	if tokenrequest.ExpiresInDays == 0 {
+		tokenrequest.ExpiresInDays = 90
+	}
+	if tokenrequest.ExpiresInDays < 1 || tokenrequest.ExpiresInDays > 365 {
+		return errors.New("tokens must expire within 1 to 365 days")
+	}
+	return nil
+}
+
+// a refresh grant without a refresh token is the client's mistake, not a bad token
+func (tokenrequest *MobileTokenRequest) Validate() error {
+	switch tokenrequest.GrantType {
+	case "session":
+		return nil
+	case "refresh_token":
+		if strings.TrimSpace(tokenrequest.RefreshToken) == "" {
+			return errors.New("refresh_token is required")
+		}
+		return nil
+	default:
+		return errors.New("grant_type must be session or refresh_token")
+	}
+}
@@ -0,0 +1,142 @@
+// backend/handlers/verify_handlers.go
+package handlers
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/mailer"
+	"backend/models"
+	"backend/utils"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ============================================
+// EMAIL VERIFICATION
+// ============================================
+
+// sendVerificationEmail burns any older tokens, creates a fresh one and emails the link to the user
+func (h *AuthHandler) sendVerificationEmail(r *http.Request, user *database.User) error {
+	if err := h.db.InvalidateEmailVerificationTokens(r.Context(), user.ID); err != nil {
+		return err
+	}
+
+	token := utils.GenerateSecureToken(32)
+	expiresAt := time.Now().Add(config.EmailVerificationTTL)
+	if err := h.db.CreateEmailVerificationToken(r.Context(), user.ID, utils.HashToken(token), expiresAt); err != nil {
+		return err
+	}
+
+	h.sendEmail(r, user, mailer.TemplateVerifyEmail, config.GetFrontendURL()+"/verify-email?token="+url.QueryEscape(token))
+	return nil
+}
+
+// VerifyEmailHandler marks the user's email as verified using the token from the email
+// POST /api/auth/verify-email
+func (h *AuthHandler) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("📧 Email verification request received")
+
+	var req models.VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+
+	if req.Token == "" {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Token is required")
+		return
+	}
+
+	// Step 1: Validate token, only its hash is stored
+	tokenHash := utils.HashToken(req.Token)
+	userID, expiresAt, used, err := h.db.GetEmailVerificationToken(r.Context(), tokenHash)
+	if err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid or expired token")
+		return
+	}
+
+	if used {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Token has already been used")
+		return
+	}
+
+	if time.Now().After(expiresAt) {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Token has expired")
+		return
+	}
+
+	// Step 2: Burn the token first so the same link cant be replayed
+	if err := h.db.MarkEmailVerificationTokenAsUsed(r.Context(), tokenHash); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Token has already been used")
+		return
+	}
+
+	// Step 3: Mark the email as verified
+	if err := h.db.VerifyEmail(r.Context(), userID); err != nil {
+		log.Printf("❌ Failed to verify email: %v", err)
+		utils.ErrorResponseJSON(w, http.StatusInternalServerError, "Failed to verify email")
+		return
+	}
+
+	h.db.CreateAuditLog(
+		r.Context(),
+		&userID,
+		"email_verified",
+		utils.GetIPAddress(r),
+		r.UserAgent(),
+		true,
+		"",
+	)
+
+	log.Printf("✅ Email verified for user ID: %d", userID)
+
+	// now that we know the address is real send the welcome email
+	if user, err := h.db.GetUserByID(r.Context(), userID); err == nil {
+		h.sendEmail(r, user, mailer.TemplateWelcome, config.GetFrontendURL()+"/Courses")
+	}
+
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"message": "Your email has been verified. You can now log in.",
+	})
+}
+
+// ResendVerificationHandler sends a new verification email
+// POST /api/auth/resend-verification
+func (h *AuthHandler) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("📧 Resend verification request received")
+
+	var req models.ResendVerificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+
+	if req.Email == "" {
+		utils.ErrorResponseJSON(w, http.StatusBadRequest, "Email is required")
+		return
+	}
+
+	// same limits as forgot password, nobody gets to flood an unverified inbox
+	if !h.throttleIP(w, r, "resend-verification", forgotPasswordIPRate) ||
+		!h.throttleEmail(w, r, "resend-verification", req.Email, forgotPasswordRate) {
+		return
+	}
+
+	// unverified local accounts get a new link, OAuth emails are verified by the provider.
+	// saml:<org> accounts are verified by the idp, one that isn't was provisioned over SCIM without sso and verifies like a local one
+	user, err := h.db.GetUserByEmail(r.Context(), req.Email)
+	if err == nil && (user.Provider == "local" || strings.HasPrefix(user.Provider, "saml:")) && !user.EmailVerified {
+		if err := h.sendVerificationEmail(r, user); err != nil {
+			log.Printf("❌ Failed to create verification token: %v", err)
+		}
+	}
+
+	// Always return success (don't reveal if email exists)
+	utils.ResponseJSON(w, http.StatusOK, map[string]string{
+		"message": "If an unverified account exists with this email, you will receive a new verification link.",
+	})
+}